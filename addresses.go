@@ -0,0 +1,139 @@
+package herald
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// UpdateAddresses publishes an advertisement carrying the given provider
+// addresses, so that indexers serve them without the content being
+// republished, and uses them in every subsequent advertisement of the default
+// provider. The advertisement re-advertises the most recently published
+// catalog of the default provider that is not retracted, reusing its entries
+// link and metadata unless multihashes were since removed from it, or else
+// retracts its most recently retracted catalog again, with no entries. If no
+// catalog of the default provider has been published yet, only subsequent
+// advertisements carry the addresses, and cid.Undef is returned. Addresses of
+// other providers are updated via AddProvider.
+//
+// The addresses are not persisted; WithProviderAddress should be set
+// accordingly when Herald is restarted.
+func (h *Herald) UpdateAddresses(ctx context.Context, addrs []multiaddr.Multiaddr) (cid.Cid, error) {
+	if err := h.limiter.acquire(ctx); err != nil {
+		return cid.Undef, err
+	}
+	defer h.limiter.release()
+	return h.publisher.UpdateAddresses(ctx, addrs)
+}
+
+func (p *httpPublisher) UpdateAddresses(ctx context.Context, addrs []multiaddr.Multiaddr) (cid.Cid, error) {
+	return p.dsPublisher.UpdateAddresses(ctx, addrs)
+}
+
+func (l *dsPublisher) UpdateAddresses(ctx context.Context, addrs []multiaddr.Multiaddr) (cid.Cid, error) {
+	providerAddrs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		providerAddrs = append(providerAddrs, addr.String())
+	}
+	if err := validateProviderAddrs(providerAddrs, l.h.permissiveValidation); err != nil {
+		return cid.Undef, err
+	}
+
+	l.gcLocker.RLock()
+	defer l.gcLocker.RUnlock()
+	if l.closed {
+		return cid.Undef, ErrClosed
+	}
+	for {
+		head, err := l.GetHead(ctx)
+		if err != nil {
+			return cid.Undef, err
+		}
+		var target *schema.Advertisement
+		if head.Defined() {
+			if target, err = l.addressUpdateTarget(ctx, head); err != nil {
+				return cid.Undef, err
+			}
+		}
+		if target == nil {
+			l.locker.Lock()
+			current, err := l.GetHead(ctx)
+			if err == nil && current.Equals(head) {
+				l.providerAddrs.Store(&providerAddrs)
+			}
+			l.locker.Unlock()
+			switch {
+			case err != nil:
+				return cid.Undef, err
+			case !current.Equals(head):
+				continue
+			}
+			l.h.publisherLogger.Infow("Updated provider addresses", "addrs", providerAddrs)
+			return cid.Undef, nil
+		}
+		id := CatalogID(target.ContextID)
+		// The head is expected not to move, so that the catalog is never
+		// re-advertised once retracted concurrently.
+		opts := &publishOptions{
+			metadata:            target.Metadata,
+			providerAddrs:       providerAddrs,
+			updateProviderAddrs: true,
+			expectHead:          true,
+			expectedHead:        head,
+		}
+		newHead, err := l.generateAdvertisement(ctx, id, target.Entries, target.IsRm, opts)
+		switch {
+		case errors.Is(err, ErrHeadMoved):
+			l.h.publisherLogger.Debugw("head moved while updating provider addresses; retrying", "err", err)
+			continue
+		case err != nil:
+			return cid.Undef, err
+		}
+		l.h.publisherLogger.Infow("Published provider addresses update", "ad", newHead, "addrs", providerAddrs, "id", id)
+		return newHead, nil
+	}
+}
+
+// addressUpdateTarget returns the advertisement re-advertised by an address
+// update: that of the most recently published catalog of the default provider
+// that is not retracted, or else a retraction of its most recently retracted
+// one, or nil if it has no catalogs.
+func (l *dsPublisher) addressUpdateTarget(ctx context.Context, head cid.Cid) (*schema.Advertisement, error) {
+	var live, retracted *schema.Advertisement
+	seen := make(map[string]struct{})
+	provider := l.h.providerID.String()
+	if err := l.walkChain(ctx, head, func(_ cid.Cid, ad *schema.Advertisement) (bool, error) {
+		// Catalogs of other providers carry their own addresses.
+		if _, ok := seen[string(ad.ContextID)]; ok || ad.Provider != provider {
+			return true, nil
+		}
+		seen[string(ad.ContextID)] = struct{}{}
+		switch {
+		case isPartialRemoval(ad):
+			// Entries published before the removal would add the removed
+			// multihashes back, so only the metadata is re-advertised.
+			live = &schema.Advertisement{ContextID: ad.ContextID, Entries: schema.NoEntries, Metadata: ad.Metadata}
+			return false, nil
+		case !ad.IsRm:
+			live = ad
+			return false, nil
+		case retracted == nil:
+			retracted = ad
+		}
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	switch {
+	case live != nil:
+		return live, nil
+	case retracted != nil:
+		return &schema.Advertisement{ContextID: retracted.ContextID, Entries: schema.NoEntries, Metadata: retracted.Metadata, IsRm: true}, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,293 @@
+package herald
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+type (
+	// AdminStats describes the advertisement chain, as served by the admin
+	// API. See WithAdminServer.
+	AdminStats struct {
+		Head        cid.Cid `json:"head"`
+		ChainLength int     `json:"chainLength"`
+		// CatalogCount is the number of catalogs published, including
+		// RetractedCount retracted ones.
+		CatalogCount   int `json:"catalogCount"`
+		RetractedCount int `json:"retractedCount"`
+		// MultihashCount is the number of multihashes of catalogs that are not
+		// retracted, as far as they are known. See CatalogStatus.
+		MultihashCount int                `json:"multihashCount"`
+		Datastores     []DatastoreOpStats `json:"datastores,omitempty"`
+	}
+
+	adminServer struct {
+		h      *Herald
+		server http.Server
+		token  string
+	}
+)
+
+// AdminHandler returns the handler of the admin API, through which Herald is
+// driven by external systems. It is served on a separate listener by
+// WithAdminServer, and otherwise must be mounted behind appropriate access
+// control. The routes are:
+//
+//   - POST /publish/{contextID}: publishes the multihashes streamed in the
+//     body, as described by IngestHandler.
+//   - POST /retract/{contextID}: retracts a catalog.
+//   - GET /catalogs: lists the status of every catalog.
+//   - GET /catalogs/{contextID}: returns the status of a catalog.
+//   - GET /head: returns the head of the chain.
+//   - POST /announce: announces the head to indexers.
+//   - GET /stats: returns AdminStats.
+//   - GET /queue: lists the QueuedPublishes.
+func (h *Herald) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(pattern, endpoint string, handler http.Handler) {
+		mux.Handle(pattern, h.metrics.instrument(endpoint, traced(h.tracer, endpoint, handler.ServeHTTP)))
+	}
+	handle("/publish/", "adminPublish", http.StripPrefix("/publish", h.IngestHandler()))
+	handle("/retract/", "adminRetract", http.HandlerFunc(h.handleAdminRetract))
+	handle("/catalogs", "adminCatalogs", http.HandlerFunc(h.handleAdminCatalogs))
+	handle("/catalogs/", "adminCatalog", http.HandlerFunc(h.handleAdminCatalog))
+	handle("/head", "adminHead", http.HandlerFunc(h.handleAdminHead))
+	handle("/announce", "adminAnnounce", http.HandlerFunc(h.handleAdminAnnounce))
+	handle("/stats", "adminStats", http.HandlerFunc(h.handleAdminStats))
+	handle("/queue", "adminQueue", http.HandlerFunc(h.handleAdminQueue))
+	return h.publisher.withClientAddr(mux)
+}
+
+// Stats walks the advertisement chain and returns statistics about it.
+func (h *Herald) Stats(ctx context.Context) (*AdminStats, error) {
+	p := h.publisher.dsPublisher
+	statuses, err := h.ListCatalogs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats := AdminStats{
+		CatalogCount: len(statuses),
+		Datastores:   h.DatastoreStats(),
+	}
+	for _, status := range statuses {
+		if status.Retracted {
+			stats.RetractedCount++
+		} else {
+			stats.MultihashCount += status.MultihashCount
+		}
+	}
+	p.gcLocker.RLock()
+	defer p.gcLocker.RUnlock()
+	if stats.Head, err = p.GetHead(ctx); err != nil {
+		return nil, err
+	}
+	if err := p.walkChain(ctx, stats.Head, func(cid.Cid, *schema.Advertisement) (bool, error) {
+		stats.ChainLength++
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func newAdminServer(h *Herald) *adminServer {
+	s := &adminServer{h: h, token: h.adminToken}
+	s.server.Handler = s.authenticated(h.AdminHandler())
+	return s
+}
+
+func (s *adminServer) Start(_ context.Context) error {
+	listener, err := net.Listen("tcp", s.h.adminListenAddr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.server.Serve(listener); errors.Is(err, http.ErrServerClosed) {
+			s.h.httpLogger.Info("Admin server stopped successfully.")
+		} else {
+			s.h.httpLogger.Errorw("Admin server stopped erroneously.", "err", err)
+		}
+	}()
+	s.h.httpLogger.Infow("Admin server started successfully.", "address", listener.Addr())
+	return nil
+}
+
+func (s *adminServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// authenticated wraps the given handler to reject requests that do not bear
+// the admin token.
+func (s *adminServer) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			s.h.httpLogger.Warnw("rejected unauthenticated admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Herald) handleAdminRetract(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := CatalogID(strings.TrimPrefix(r.URL.Path, "/retract/"))
+	if len(id) == 0 {
+		http.Error(w, "context ID must be specified", http.StatusBadRequest)
+		return
+	}
+	ad, err := h.Retract(r.Context(), id)
+	if err != nil {
+		h.httpLogger.Warnw("failed to retract catalog", "contextID", string(id), "client", clientAddr(r), "err", err)
+		writeAdminError(w, err)
+		return
+	}
+	h.httpLogger.Infow("Retracted catalog", "contextID", string(id), "ad", ad, "client", clientAddr(r))
+	h.writeAdminJSON(w, r, struct {
+		Advertisement string `json:"advertisement"`
+	}{ad.String()})
+}
+
+func (h *Herald) handleAdminCatalogs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	statuses, err := h.ListCatalogs(r.Context())
+	if err != nil {
+		h.httpLogger.Errorw("failed to list catalogs", "err", err)
+		writeAdminError(w, err)
+		return
+	}
+	if statuses == nil {
+		statuses = []*CatalogStatus{}
+	}
+	h.writeAdminJSON(w, r, statuses)
+}
+
+func (h *Herald) handleAdminCatalog(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := CatalogID(strings.TrimPrefix(r.URL.Path, "/catalogs/"))
+	if len(id) == 0 {
+		http.Error(w, "context ID must be specified", http.StatusBadRequest)
+		return
+	}
+	status, err := h.GetCatalogStatus(r.Context(), id)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	h.writeAdminJSON(w, r, status)
+}
+
+func (h *Herald) handleAdminHead(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	head, err := h.GetHead(r.Context())
+	if err != nil {
+		h.httpLogger.Errorw("failed to get head CID", "err", err)
+		writeAdminError(w, err)
+		return
+	}
+	// The head is omitted if nothing is published yet.
+	var resp struct {
+		Head string `json:"head,omitempty"`
+	}
+	if head.Defined() {
+		resp.Head = head.String()
+	}
+	h.writeAdminJSON(w, r, &resp)
+}
+
+func (h *Herald) handleAdminAnnounce(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.Announce(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Herald) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats, err := h.Stats(r.Context())
+	if err != nil {
+		h.httpLogger.Errorw("failed to get chain stats", "err", err)
+		writeAdminError(w, err)
+		return
+	}
+	h.writeAdminJSON(w, r, stats)
+}
+
+func (h *Herald) handleAdminQueue(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	queued, err := h.QueuedPublishes()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	h.writeAdminJSON(w, r, queued)
+}
+
+func writeAdminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrCatalogNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrClosed):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, ErrInvalidContextID):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrNoPublishQueue):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Herald) writeAdminJSON(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.httpLogger.Debugw("failed to write admin response", "path", r.URL.Path, "client", clientAddr(r), "err", err)
+	}
+}
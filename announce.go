@@ -0,0 +1,301 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/httpsender"
+	"github.com/ipni/go-libipni/announce/p2psender"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+var (
+	_ Announcer = (*senderAnnouncer)(nil)
+	_ Announcer = noopAnnouncer{}
+
+	// NoopAnnouncer discards announcements, e.g. to stand in for announcers
+	// that are disabled by configuration.
+	NoopAnnouncer Announcer = noopAnnouncer{}
+)
+
+type (
+	// Announcer announces head updates to indexers. Announcers that implement
+	// io.Closer are closed on Shutdown, and those that implement fmt.Stringer
+	// are named by it in logs and metrics. See WithAnnouncer.
+	Announcer interface {
+		Announce(ctx context.Context, head cid.Cid) error
+	}
+	// AnnounceError is the error of an individual announcer. Errors of every
+	// announcer that failed are joined.
+	AnnounceError struct {
+		Announcer Announcer
+		Err       error
+	}
+	// senderAnnouncer announces via an announce.Sender, along with the
+	// publisher addresses at the time of announcing.
+	senderAnnouncer struct {
+		name   string
+		sender announce.Sender
+		addrs  func() []multiaddr.Multiaddr
+		logger *zap.SugaredLogger
+	}
+	noopAnnouncer struct{}
+	// headAnnouncer announces head updates in the background, such that
+	// publishing does not wait for indexers. Heads updated while an
+	// announcement is in flight are coalesced to the latest one.
+	headAnnouncer struct {
+		mu       sync.Mutex
+		pending  cid.Cid
+		notify   chan struct{}
+		stopping chan struct{}
+		cancel   context.CancelFunc
+		done     chan struct{}
+	}
+	// reannouncer periodically re-announces the current head. See
+	// WithReannounceInterval.
+	reannouncer struct {
+		interval time.Duration
+		cancel   context.CancelFunc
+		done     chan struct{}
+	}
+)
+
+// newAnnouncers instantiates the announcers through which head updates are
+// announced, according to the options: gossipsub and HTTP announcers, if
+// enabled, followed by those set via WithAnnouncer.
+func newAnnouncers(h *Herald) ([]Announcer, error) {
+	var announcers []Announcer
+	if h.gossipsubHost != nil {
+		var p2pOpts []p2psender.Option
+		if h.pubsub != nil {
+			topic, err := h.pubsub.Join(h.topic)
+			if err != nil {
+				return nil, err
+			}
+			p2pOpts = append(p2pOpts, p2psender.WithTopic(topic))
+		}
+		sender, err := p2psender.New(h.gossipsubHost, h.topic, p2pOpts...)
+		if err != nil {
+			return nil, err
+		}
+		announcers = append(announcers, &senderAnnouncer{name: "gossipsub", sender: sender, addrs: h.announceAddrs, logger: h.announceLogger})
+	}
+	if len(h.httpAnnounceURLs) != 0 {
+		sender, err := httpsender.New(h.httpAnnounceURLs, h.id)
+		if err != nil {
+			return nil, err
+		}
+		announcers = append(announcers, &senderAnnouncer{name: "http", sender: sender, addrs: h.announceAddrs, logger: h.announceLogger})
+	}
+	return append(announcers, h.extraAnnouncers...), nil
+}
+
+// Announce announces the current head, if any, to indexers.
+func (h *Herald) Announce(ctx context.Context) error {
+	head, err := h.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	return h.announce(ctx, head)
+}
+
+// announce announces the given head via every announcer concurrently, and
+// returns the errors of those that failed as AnnounceError.
+func (h *Herald) announce(ctx context.Context, head cid.Cid) error {
+	if len(h.announcers) == 0 || !head.Defined() {
+		return nil
+	}
+	errs := make([]error, len(h.announcers))
+	var wg sync.WaitGroup
+	for i, a := range h.announcers {
+		wg.Add(1)
+		go func(i int, a Announcer) {
+			defer wg.Done()
+			ctx := ctx
+			if h.announceTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, h.announceTimeout)
+				defer cancel()
+			}
+			err := a.Announce(ctx, head)
+			name := announcerName(a)
+			h.metrics.observeAnnounced(name, err)
+			if err != nil {
+				h.announceLogger.Errorw("failed to announce head", "announcer", name, "head", head, "err", err)
+				h.events.emit(AnnounceFailed{Announcer: name, Head: head, Err: err})
+				errs[i] = &AnnounceError{Announcer: a, Err: err}
+				return
+			}
+			h.events.emit(AnnounceSucceeded{Announcer: name, Head: head})
+		}(i, a)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	h.announceLogger.Infow("Announced head", "head", head, "announcers", len(h.announcers))
+	return nil
+}
+
+// announceAddrs returns the addresses at which the publisher is reachable,
+// which default to the addresses it listens on. See
+// WithHttpPublisherPathPrefix.
+func (h *Herald) announceAddrs() []multiaddr.Multiaddr {
+	if len(h.publisherAddrs) != 0 {
+		return h.publisherAddrs
+	}
+	var addrs []multiaddr.Multiaddr
+	// Listen addresses do not include the HTTP path prefix, if any, and so do
+	// not reach the chain.
+	if h.httpTransport && h.httpPublisherPathPrefix == "" {
+		addrs = append(addrs, h.Addrs()...)
+	}
+	if h.libp2pHost != nil {
+		addrs = append(addrs, h.libp2pHost.Addrs()...)
+	}
+	return addrs
+}
+
+func (a *headAnnouncer) start(ctx context.Context, h *Herald) {
+	ctx, a.cancel = context.WithCancel(ctx)
+	a.notify = make(chan struct{}, 1)
+	a.stopping = make(chan struct{})
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.notify:
+				a.announcePending(ctx, h)
+			case <-a.stopping:
+				a.announcePending(ctx, h)
+				return
+			}
+		}
+	}()
+}
+
+// enqueue hands the given head to be announced, replacing the one pending, if
+// any.
+func (a *headAnnouncer) enqueue(head cid.Cid) {
+	a.mu.Lock()
+	a.pending = head
+	a.mu.Unlock()
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (a *headAnnouncer) announcePending(ctx context.Context, h *Herald) {
+	a.mu.Lock()
+	head := a.pending
+	a.pending = cid.Undef
+	a.mu.Unlock()
+	// The head is set regardless of whether announcing it succeeds; failures
+	// are logged per announcer, and indexers catch up on the next
+	// announcement.
+	_ = h.announce(ctx, head)
+}
+
+// stop stops announcing once the pending head, if any, is announced. It
+// returns an error if that does not complete before the context is done, in
+// which case the announcement is abandoned.
+func (a *headAnnouncer) stop(ctx context.Context) error {
+	if a.cancel == nil {
+		return nil
+	}
+	select {
+	case <-a.stopping:
+	default:
+		close(a.stopping)
+	}
+	defer a.cancel()
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		a.cancel()
+		<-a.done
+		return fmt.Errorf("pending announcement did not complete: %w", ctx.Err())
+	}
+}
+
+func (r *reannouncer) start(ctx context.Context, h *Herald) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Failures are logged per announcer, and retried on the
+				// next tick.
+				_ = h.Announce(ctx)
+			}
+		}
+	}()
+}
+
+func (r *reannouncer) stop() {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+	}
+}
+
+func (h *Herald) closeAnnouncers() error {
+	var errs []error
+	for _, a := range h.announcers {
+		if c, ok := a.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// announcerName returns the name of the given announcer in logs and metrics.
+func announcerName(a Announcer) string {
+	if s, ok := a.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", a)
+}
+
+func (e *AnnounceError) Error() string {
+	return fmt.Sprintf("%s announcer: %v", announcerName(e.Announcer), e.Err)
+}
+
+func (e *AnnounceError) Unwrap() error { return e.Err }
+
+func (a *senderAnnouncer) Announce(ctx context.Context, head cid.Cid) error {
+	addrs := a.addrs()
+	if err := announce.Send(ctx, head, addrs, a.sender); err != nil {
+		return err
+	}
+	a.logger.Debugw("Sent announcement", "announcer", a.name, "head", head, "addrs", addrs)
+	return nil
+}
+
+func (a *senderAnnouncer) Close() error { return a.sender.Close() }
+
+func (a *senderAnnouncer) String() string { return a.name }
+
+func (noopAnnouncer) Announce(context.Context, cid.Cid) error { return nil }
+
+func (noopAnnouncer) String() string { return "noop" }
@@ -0,0 +1,88 @@
+package herald_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+)
+
+// blockingAnnouncer records the heads it announces, each once released.
+type blockingAnnouncer struct {
+	release   chan struct{}
+	announced chan cid.Cid
+}
+
+func (a *blockingAnnouncer) Announce(ctx context.Context, head cid.Cid) error {
+	select {
+	case <-a.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	a.announced <- head
+	return nil
+}
+
+func TestPublishDoesNotWaitForAnnouncers(t *testing.T) {
+	ctx := context.Background()
+	a := &blockingAnnouncer{release: make(chan struct{}), announced: make(chan cid.Cid, 8)}
+	h, err := herald.New(heraldtest.Options(herald.WithAnnouncer(a))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := h.Publish(ctx, heraldtest.Catalog("a", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Heads updated while the first is being announced are coalesced to the
+	// latest one.
+	if _, err := h.Publish(ctx, heraldtest.Catalog("b", 1)); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := h.Publish(ctx, heraldtest.Catalog("c", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(a.release)
+
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	close(a.announced)
+	var announced []cid.Cid
+	for head := range a.announced {
+		announced = append(announced, head)
+	}
+	switch {
+	case len(announced) == 0 || len(announced) > 2:
+		t.Fatalf("expected the first and latest heads to be announced, got %v", announced)
+	case !announced[len(announced)-1].Equals(latest):
+		t.Fatalf("expected latest head %s to be announced last, got %v", latest, announced)
+	case len(announced) == 2 && !announced[0].Equals(first):
+		t.Fatalf("expected first head %s to be announced first, got %v", first, announced)
+	}
+}
+
+func TestAnnounceTimeout(t *testing.T) {
+	ctx := context.Background()
+	a := &blockingAnnouncer{release: make(chan struct{}), announced: make(chan cid.Cid, 1)}
+	h, err := herald.New(heraldtest.Options(
+		herald.WithAnnouncer(a),
+		herald.WithAnnounceTimeout(10*time.Millisecond),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if _, err := h.Publish(ctx, heraldtest.Catalog("a", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Announce(ctx); err == nil {
+		t.Fatal("expected announcing to time out")
+	}
+}
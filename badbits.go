@@ -0,0 +1,432 @@
+package herald
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+const DefaultBadbitsURL = "https://badbits.dwebops.pub/badbits.deny"
+
+var (
+	// badbitsCodecs are the codecs for which CIDs are derived from a multihash
+	// when matching double-hashed badbits entries, since entries are keyed by
+	// CID rather than multihash.
+	badbitsCodecs = []multicodec.Code{multicodec.Raw, multicodec.DagPb}
+)
+
+type (
+	BadbitsOption  func(*badbitsOptions)
+	badbitsOptions struct {
+		url       string
+		interval  time.Duration
+		client    *http.Client
+		cacheFile string
+		onFlagged func(CatalogID)
+	}
+
+	// Badbits maintains a local copy of a badbits denylist, periodically
+	// refreshed from a URL. It filters denied content out of publishes when
+	// passed to Herald via WithBadbits, and flags already published catalogs
+	// that contain newly denied content so that they can be retracted.
+	Badbits struct {
+		*badbitsOptions
+		h      *Herald
+		cancel context.CancelFunc
+		done   chan struct{}
+
+		locker       sync.RWMutex
+		doubleHashes map[[sha256.Size]byte]struct{}
+		multihashes  map[string]struct{}
+		etag         string
+		// digest is that of the loaded denylist, and generation is
+		// incremented whenever a denylist with another digest is loaded.
+		digest     [sha256.Size]byte
+		generation uint64
+
+		flaggedLocker sync.Mutex
+		flagged       map[string]struct{}
+		// scanned holds the latest advertisement of each live catalog as of
+		// when its entries were scanned against the denylist of
+		// scannedGeneration, keyed by catalog ID. See flagPublished.
+		scanLocker        sync.Mutex
+		scanned           map[string]cid.Cid
+		scannedGeneration uint64
+	}
+)
+
+// WithBadbitsURL sets the URL from which the denylist is fetched. Defaults to
+// DefaultBadbitsURL.
+func WithBadbitsURL(url string) BadbitsOption {
+	return func(o *badbitsOptions) { o.url = url }
+}
+
+// WithBadbitsRefreshInterval sets the interval at which the denylist is
+// refreshed. Defaults to 1 hour.
+func WithBadbitsRefreshInterval(d time.Duration) BadbitsOption {
+	return func(o *badbitsOptions) { o.interval = d }
+}
+
+func WithBadbitsHttpClient(c *http.Client) BadbitsOption {
+	return func(o *badbitsOptions) { o.client = c }
+}
+
+// WithBadbitsCacheFile keeps a copy of the latest fetched denylist at the given
+// path, which is loaded on start so that the denylist is enforced before the
+// first fetch completes.
+func WithBadbitsCacheFile(path string) BadbitsOption {
+	return func(o *badbitsOptions) { o.cacheFile = path }
+}
+
+// WithBadbitsOnFlagged sets a callback that is called with the ID of every
+// published catalog newly found to contain denied content.
+func WithBadbitsOnFlagged(f func(CatalogID)) BadbitsOption {
+	return func(o *badbitsOptions) { o.onFlagged = f }
+}
+
+func NewBadbits(o ...BadbitsOption) *Badbits {
+	opts := badbitsOptions{
+		url:      DefaultBadbitsURL,
+		interval: time.Hour,
+		client:   http.DefaultClient,
+	}
+	for _, apply := range o {
+		apply(&opts)
+	}
+	return &Badbits{
+		badbitsOptions: &opts,
+		doubleHashes:   make(map[[sha256.Size]byte]struct{}),
+		multihashes:    make(map[string]struct{}),
+		flagged:        make(map[string]struct{}),
+		scanned:        make(map[string]cid.Cid),
+	}
+}
+
+// logger returns the logger of the Herald instance the denylist is passed to,
+// if any.
+func (b *Badbits) logger() *zap.SugaredLogger {
+	if b.h != nil {
+		return b.h.logger
+	}
+	return defaultLoggers.logger
+}
+
+// Allowed reports whether the given multihash is not denied.
+func (b *Badbits) Allowed(mh multihash.Multihash) bool {
+	b.locker.RLock()
+	defer b.locker.RUnlock()
+	if _, denied := b.multihashes[string(mh)]; denied {
+		return false
+	}
+	if len(b.doubleHashes) == 0 {
+		return true
+	}
+	for _, codec := range badbitsCodecs {
+		key, err := cid.NewCidV1(uint64(codec), mh).StringOfBase(multibase.Base32)
+		if err != nil {
+			continue
+		}
+		if _, denied := b.doubleHashes[sha256.Sum256([]byte(key+"/"))]; denied {
+			return false
+		}
+	}
+	return true
+}
+
+// Flagged returns the IDs of published catalogs found to contain denied
+// content that have not been retracted since.
+func (b *Badbits) Flagged() []CatalogID {
+	b.flaggedLocker.Lock()
+	defer b.flaggedLocker.Unlock()
+	ids := make([]CatalogID, 0, len(b.flagged))
+	for id := range b.flagged {
+		ids = append(ids, CatalogID(id))
+	}
+	return ids
+}
+
+func (b *Badbits) start(ctx context.Context) {
+	if b.cacheFile != "" {
+		if f, err := os.Open(b.cacheFile); err == nil {
+			err = b.load(f)
+			_ = f.Close()
+			if err != nil {
+				b.logger().Warnw("failed to load cached badbits denylist", "path", b.cacheFile, "err", err)
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			b.logger().Warnw("failed to open cached badbits denylist", "path", b.cacheFile, "err", err)
+		}
+	}
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.done = make(chan struct{})
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		for {
+			if err := b.Refresh(ctx); err != nil && ctx.Err() == nil {
+				b.logger().Errorw("failed to refresh badbits denylist", "url", b.url, "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (b *Badbits) stop() {
+	if b.cancel != nil {
+		b.cancel()
+		<-b.done
+	}
+}
+
+// Refresh fetches the denylist, replacing the local copy if it has changed,
+// and flags published catalogs that contain denied content.
+func (b *Badbits) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		return err
+	}
+	b.locker.RLock()
+	if b.etag != "" {
+		req.Header.Set("If-None-Match", b.etag)
+	}
+	b.locker.RUnlock()
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		b.logger().Debugw("badbits denylist is unchanged", "url", b.url)
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if err := b.load(bytes.NewReader(body)); err != nil {
+			return err
+		}
+		b.locker.Lock()
+		b.etag = resp.Header.Get("ETag")
+		b.locker.Unlock()
+		if b.cacheFile != "" {
+			if err := os.WriteFile(b.cacheFile, body, 0o644); err != nil {
+				b.logger().Warnw("failed to cache badbits denylist", "path", b.cacheFile, "err", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected response status fetching badbits denylist: %s", resp.Status)
+	}
+	// Catalogs published since the last refresh are scanned even if the
+	// denylist is unchanged.
+	if b.h != nil {
+		return b.flagPublished(ctx)
+	}
+	return nil
+}
+
+// load parses a denylist in either the legacy badbits anchors format, with one
+// hex encoded double-hash per line, or the compact denylist format specified
+// by IPIP-383.
+func (b *Badbits) load(r io.Reader) error {
+	doubleHashes := make(map[[sha256.Size]byte]struct{})
+	multihashes := make(map[string]struct{})
+	digest := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(r, digest))
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	var lines, skipped int
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		lines++
+		switch {
+		case line == "", line == "---", strings.HasPrefix(line, "#"), strings.HasPrefix(line, "!"):
+			// Skip empty lines, the IPIP-383 header separator, comments and
+			// allow rules.
+			continue
+		case strings.HasPrefix(line, "//"):
+			if dh, ok := parseBadbitsDoubleHash(line[2:], true); ok {
+				doubleHashes[dh] = struct{}{}
+				continue
+			}
+		default:
+			if dh, ok := parseBadbitsDoubleHash(line, false); ok {
+				doubleHashes[dh] = struct{}{}
+				continue
+			}
+		}
+		if mh, ok := parseBadbitsMultihash(line); ok {
+			multihashes[string(mh)] = struct{}{}
+			continue
+		}
+		skipped++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	b.locker.Lock()
+	b.doubleHashes, b.multihashes = doubleHashes, multihashes
+	if !bytes.Equal(b.digest[:], digest.Sum(nil)) {
+		copy(b.digest[:], digest.Sum(nil))
+		b.generation++
+	}
+	b.locker.Unlock()
+	b.logger().Infow("Loaded badbits denylist", "lines", lines, "doubleHashes", len(doubleHashes), "multihashes", len(multihashes), "skipped", skipped)
+	return nil
+}
+
+// parseBadbitsDoubleHash parses a hex encoded double-hash, or when allowMultihash
+// is set, a base58 encoded SHA2-256 multihash of the double-hash.
+func parseBadbitsDoubleHash(v string, allowMultihash bool) ([sha256.Size]byte, bool) {
+	var dh [sha256.Size]byte
+	if decoded, err := hex.DecodeString(v); err == nil && len(decoded) == sha256.Size {
+		copy(dh[:], decoded)
+		return dh, true
+	}
+	if !allowMultihash {
+		return dh, false
+	}
+	if mh, err := multihash.FromB58String(v); err == nil {
+		if decoded, err := multihash.Decode(mh); err == nil && decoded.Code == multihash.SHA2_256 && len(decoded.Digest) == sha256.Size {
+			copy(dh[:], decoded.Digest)
+			return dh, true
+		}
+	}
+	return dh, false
+}
+
+func parseBadbitsMultihash(v string) (multihash.Multihash, bool) {
+	v = strings.TrimPrefix(v, "/ipfs/")
+	if i := strings.IndexByte(v, '/'); i >= 0 {
+		// Only whole blocks are denied; paths within blocks cannot be matched.
+		if i != len(v)-1 {
+			return nil, false
+		}
+		v = v[:i]
+	}
+	c, err := cid.Decode(v)
+	if err != nil {
+		return nil, false
+	}
+	return c.Hash(), true
+}
+
+// flagPublished scans the entries of live catalogs for denied content,
+// according to their statuses. Catalogs are only scanned again once their
+// latest advertisement changes, or once another denylist is loaded.
+func (b *Badbits) flagPublished(ctx context.Context) error {
+	b.scanLocker.Lock()
+	defer b.scanLocker.Unlock()
+	b.locker.RLock()
+	generation := b.generation
+	b.locker.RUnlock()
+	if generation != b.scannedGeneration {
+		b.scanned, b.scannedGeneration = make(map[string]cid.Cid), generation
+	}
+	p := b.h.publisher.dsPublisher
+	statuses, err := p.listCatalogs(ctx)
+	if err != nil {
+		return err
+	}
+	live := make(map[string]struct{}, len(statuses))
+	var flagged []CatalogID
+	var scanned int
+	for _, status := range statuses {
+		id := string(status.ID)
+		if status.Retracted {
+			continue
+		}
+		live[id] = struct{}{}
+		if ad, ok := b.scanned[id]; ok && ad.Equals(status.Advertisement) {
+			continue
+		}
+		denied, err := b.catalogContainsDenied(ctx, p, status)
+		if err != nil {
+			return err
+		}
+		b.scanned[id] = status.Advertisement
+		scanned++
+		b.flaggedLocker.Lock()
+		_, wasFlagged := b.flagged[id]
+		if denied {
+			b.flagged[id] = struct{}{}
+		} else {
+			delete(b.flagged, id)
+		}
+		b.flaggedLocker.Unlock()
+		if denied && !wasFlagged {
+			flagged = append(flagged, status.ID)
+		}
+	}
+	// Catalogs that are no longer live, e.g. since retracted or rolled back,
+	// are no longer flagged.
+	for id := range b.scanned {
+		if _, ok := live[id]; !ok {
+			delete(b.scanned, id)
+		}
+	}
+	b.flaggedLocker.Lock()
+	for id := range b.flagged {
+		if _, ok := live[id]; !ok {
+			delete(b.flagged, id)
+		}
+	}
+	b.flaggedLocker.Unlock()
+	b.logger().Debugw("Scanned published catalogs for denied content", "live", len(live), "scanned", scanned, "flagged", len(flagged))
+	for _, id := range flagged {
+		b.logger().Warnw("Published catalog contains denied content and should be retracted", "id", id)
+		if b.onFlagged != nil {
+			b.onFlagged(id)
+		}
+	}
+	return nil
+}
+
+// catalogContainsDenied reports whether the multihashes advertised for the
+// given catalog contain denied content: those tracked for it if published by
+// diff, or else the entries of its latest advertisement, other than partial
+// removals.
+func (b *Badbits) catalogContainsDenied(ctx context.Context, p *dsPublisher, status *CatalogStatus) (bool, error) {
+	var denied bool
+	allowed := func(mh multihash.Multihash) bool {
+		denied = !b.Allowed(mh)
+		return !denied
+	}
+	// The latest advertisement of a catalog published by diff only holds the
+	// multihashes it added.
+	if tracked, err := p.forEachDiffMultihash(ctx, status.ID, status.Advertisement, allowed); err != nil || tracked {
+		return denied, err
+	}
+	_, ad, err := p.latestAdvertisement(ctx, status.ID)
+	switch {
+	case errors.Is(err, ErrCatalogNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	case !hasEntries(ad.Entries):
+		return false, nil
+	}
+	err = p.forEachEntry(ctx, ad.Entries.(cidlink.Link).Cid, allowed)
+	return denied, err
+}
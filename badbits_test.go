@@ -0,0 +1,116 @@
+package herald_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+	"github.com/multiformats/go-multicodec"
+)
+
+func TestBadbitsFlagsHamtCatalogs(t *testing.T) {
+	ctx := context.Background()
+	var mu sync.Mutex
+	var denylist string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write([]byte(denylist))
+	}))
+	defer server.Close()
+
+	var flagged []herald.CatalogID
+	badbits := herald.NewBadbits(
+		herald.WithBadbitsURL(server.URL),
+		herald.WithBadbitsOnFlagged(func(id herald.CatalogID) { flagged = append(flagged, id) }),
+	)
+	h, err := herald.New(heraldtest.Options(
+		herald.WithBadbits(badbits),
+		herald.WithEntryChunker(herald.HamtEntryChunker(5, 3, multicodec.Murmur3X64_64)),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	a := heraldtest.Catalog("a", 3)
+	if _, err := h.Publish(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Publish(ctx, heraldtest.Catalog("b", 3)); err != nil {
+		t.Fatal(err)
+	}
+	denied, err := a.Iterator().Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	denylist = cid.NewCidV1(cid.Raw, denied).String() + "\n"
+	mu.Unlock()
+
+	// Refreshing the same denylist scans no catalog again, and flags each
+	// once.
+	for i := 0; i < 2; i++ {
+		if err := badbits.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(flagged) != 1 || string(flagged[0]) != "a" {
+			t.Fatalf("expected a to be flagged once, got %q", flagged)
+		}
+		if got := badbits.Flagged(); len(got) != 1 || string(got[0]) != "a" {
+			t.Fatalf("expected a to be flagged, got %q", got)
+		}
+	}
+
+	if _, err := h.Retract(ctx, a.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := badbits.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := badbits.Flagged(); len(got) != 0 {
+		t.Fatalf("expected no catalog to be flagged once a is retracted, got %q", got)
+	}
+}
+
+func TestBadbitsFlagsDiffCatalogs(t *testing.T) {
+	ctx := context.Background()
+	a := heraldtest.Catalog("a", 3)
+	denied, err := a.Iterator().Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(cid.NewCidV1(cid.Raw, denied).String() + "\n"))
+	}))
+	defer server.Close()
+
+	badbits := herald.NewBadbits(herald.WithBadbitsURL(server.URL))
+	h, err := herald.New(heraldtest.Options(herald.WithBadbits(badbits))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// The denied multihash is advertised by the first diff of a, but not
+	// held by the entries of its latest advertisement.
+	if _, err := h.PublishDiff(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if receipt, err := h.PublishDiff(ctx, heraldtest.Catalog("a", 4)); err != nil {
+		t.Fatal(err)
+	} else if receipt.AddedCount != 1 {
+		t.Fatalf("expected a single added multihash, got %d", receipt.AddedCount)
+	}
+	if err := badbits.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := badbits.Flagged(); len(got) != 1 || string(got[0]) != "a" {
+		t.Fatalf("expected a to be flagged, got %q", got)
+	}
+}
@@ -0,0 +1,104 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Catalog         = (*blockstoreCatalog)(nil)
+	_ CatalogIterator = (*blockstoreCatalogIterator)(nil)
+)
+
+type (
+	// Blockstore lists the keys of the blocks in a store. It is satisfied by
+	// the Blockstore interfaces of go-ipfs-blockstore and boxo, without
+	// depending on either.
+	Blockstore interface {
+		AllKeysChan(ctx context.Context) (<-chan cid.Cid, error)
+	}
+
+	blockstoreCatalog struct {
+		ctx context.Context
+		id  CatalogID
+		bs  Blockstore
+	}
+	blockstoreCatalogIterator struct {
+		ctx    context.Context
+		keys   <-chan cid.Cid
+		cancel context.CancelFunc
+		next   multihash.Multihash
+		err    error
+	}
+)
+
+// CatalogFromBlockstore returns a catalog with the given ID of the multihashes
+// of all blocks in the given blockstore, such that a node can advertise
+// everything it already stores. Blocks with an identity multihash are skipped,
+// since their content is inlined in the CID.
+//
+// The keys are listed every time the catalog is iterated, bound to the given
+// context. Listing stops once the iteration completes, or when the context is
+// done if the iteration is abandoned, e.g. because the publish failed.
+//
+// To scan the datastore of a node directly instead, see CatalogFromDatastore
+// and MultihashFromKeyMultihash.
+func CatalogFromBlockstore(ctx context.Context, id CatalogID, bs Blockstore) Catalog {
+	return &blockstoreCatalog{ctx: ctx, id: id, bs: bs}
+}
+
+func (c *blockstoreCatalog) ID() []byte { return c.id }
+
+func (c *blockstoreCatalog) Iterator() CatalogIterator {
+	iter := &blockstoreCatalogIterator{}
+	iter.ctx, iter.cancel = context.WithCancel(c.ctx)
+	if iter.keys, iter.err = c.bs.AllKeysChan(iter.ctx); iter.err != nil {
+		iter.cancel()
+		return iter
+	}
+	iter.advance()
+	return iter
+}
+
+func (c *blockstoreCatalog) Transport() interface{ Providers() any } { return nil }
+
+func (i *blockstoreCatalogIterator) advance() {
+	i.next = nil
+	for {
+		select {
+		case key, ok := <-i.keys:
+			if !ok {
+				// Blockstores close the channel early when the context is
+				// done, which must not pass for the end of the listing.
+				i.err = i.ctx.Err()
+				i.cancel()
+				return
+			}
+			if key.Prefix().MhType == multihash.IDENTITY {
+				continue
+			}
+			i.next = key.Hash()
+			return
+		case <-i.ctx.Done():
+			i.err = i.ctx.Err()
+			i.cancel()
+			return
+		}
+	}
+}
+
+func (i *blockstoreCatalogIterator) Next() (multihash.Multihash, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	if i.next == nil {
+		return nil, ErrCatalogIteratorDone
+	}
+	mh := i.next
+	i.advance()
+	return mh, nil
+}
+
+func (i *blockstoreCatalogIterator) Done() bool { return i.next == nil && i.err == nil }
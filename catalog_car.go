@@ -0,0 +1,124 @@
+package herald
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Catalog         = (*carCatalog)(nil)
+	_ CatalogIterator = (*carCatalogIterator)(nil)
+)
+
+type (
+	carCatalog struct {
+		id   CatalogID
+		path string
+	}
+	carCatalogIterator struct {
+		file   *os.File
+		reader *carv2.BlockReader
+		next   multihash.Multihash
+		err    error
+	}
+)
+
+// CatalogFromCAR returns a catalog of the multihashes of the blocks in the
+// CARv1 or CARv2 file at the given path. Its ID is the CID of the root of the
+// CAR if it has exactly one, and the SHA-256 multihash of the file otherwise.
+// Blocks with an identity multihash are skipped, since their content is
+// inlined in the CID.
+//
+// The file is read every time the catalog is iterated, skipping over block
+// data, and must not change in the meantime.
+func CatalogFromCAR(path string) (Catalog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br, err := carv2.NewBlockReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CAR: %w", err)
+	}
+	if len(br.Roots) == 1 {
+		return &carCatalog{id: br.Roots[0].Bytes(), path: path}, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return nil, err
+	}
+	id, err := multihash.Encode(digest.Sum(nil), multihash.SHA2_256)
+	if err != nil {
+		return nil, err
+	}
+	return &carCatalog{id: id, path: path}, nil
+}
+
+func (c *carCatalog) ID() []byte { return c.id }
+
+func (c *carCatalog) Iterator() CatalogIterator {
+	iter := &carCatalogIterator{}
+	if iter.file, iter.err = os.Open(c.path); iter.err != nil {
+		return iter
+	}
+	if iter.reader, iter.err = carv2.NewBlockReader(iter.file); iter.err != nil {
+		iter.err = fmt.Errorf("failed to read CAR: %w", iter.err)
+		iter.close()
+		return iter
+	}
+	iter.advance()
+	return iter
+}
+
+func (c *carCatalog) Transport() interface{ Providers() any } { return nil }
+
+func (i *carCatalogIterator) advance() {
+	for {
+		blk, err := i.reader.SkipNext()
+		switch {
+		case errors.Is(err, io.EOF):
+			i.next = nil
+			i.close()
+			return
+		case err != nil:
+			i.err = fmt.Errorf("failed to read CAR block: %w", err)
+			i.close()
+			return
+		}
+		if blk.Prefix().MhType == multihash.IDENTITY {
+			continue
+		}
+		i.next = blk.Hash()
+		return
+	}
+}
+
+func (i *carCatalogIterator) close() {
+	if err := i.file.Close(); err != nil {
+		defaultLoggers.publisherLogger.Debugw("failed to close CAR file", "err", err)
+	}
+}
+
+func (i *carCatalogIterator) Next() (multihash.Multihash, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
+	if i.next == nil {
+		return nil, ErrCatalogIteratorDone
+	}
+	mh := i.next
+	i.advance()
+	return mh, nil
+}
+
+func (i *carCatalogIterator) Done() bool { return i.next == nil && i.err == nil }
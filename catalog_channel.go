@@ -0,0 +1,82 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Catalog         = (*channelCatalog)(nil)
+	_ CatalogIterator = (*channelCatalogIterator)(nil)
+)
+
+type (
+	// channelCatalog is a catalog whose multihashes are received from a
+	// channel until it is closed.
+	channelCatalog struct {
+		ctx context.Context
+		id  CatalogID
+		mhs <-chan multihash.Multihash
+	}
+	channelCatalogIterator struct {
+		ctx  context.Context
+		mhs  <-chan multihash.Multihash
+		next multihash.Multihash
+		done bool
+	}
+)
+
+// NewChannelCatalog returns a catalog with the given ID whose multihashes are
+// received from the given channel until it is closed, such that a streaming
+// pipeline can be published without holding all of its multihashes in memory.
+//
+// Multihashes are received only as fast as they are chunked, so a producer
+// blocked on sending is throttled by the publish; the channel buffer bounds how
+// far ahead it may run. Iteration stops with the context error once the
+// context is done, which fails the publish. The producer should then stop
+// sending, as nothing receives from the channel anymore.
+//
+// The channel is drained as the catalog is iterated, so the catalog must be
+// published at most once.
+func NewChannelCatalog(ctx context.Context, id CatalogID, mhs <-chan multihash.Multihash) Catalog {
+	return &channelCatalog{ctx: ctx, id: id, mhs: mhs}
+}
+
+func (c *channelCatalog) ID() []byte { return c.id }
+
+func (c *channelCatalog) Iterator() CatalogIterator {
+	return &channelCatalogIterator{ctx: c.ctx, mhs: c.mhs}
+}
+
+func (c *channelCatalog) Transport() interface{ Providers() any } { return nil }
+
+// Done blocks until the next multihash is received, the channel is closed, or
+// the context is done. In the latter case Next returns the context error.
+func (i *channelCatalogIterator) Done() bool {
+	if i.done || i.next != nil {
+		return i.done
+	}
+	// Nil multihashes are skipped.
+	for i.next == nil && !i.done {
+		select {
+		case mh, ok := <-i.mhs:
+			i.next, i.done = mh, !ok
+		case <-i.ctx.Done():
+			return false
+		}
+	}
+	return i.done
+}
+
+func (i *channelCatalogIterator) Next() (multihash.Multihash, error) {
+	if i.Done() {
+		return nil, ErrCatalogIteratorDone
+	}
+	if i.next == nil {
+		return nil, i.ctx.Err()
+	}
+	mh := i.next
+	i.next = nil
+	return mh, nil
+}
@@ -0,0 +1,151 @@
+package herald
+
+import (
+	"context"
+	"encoding/base32"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Catalog         = (*datastoreCatalog)(nil)
+	_ CatalogIterator = (*datastoreCatalogIterator)(nil)
+
+	blockstoreKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+type (
+	// MultihashExtractor extracts a multihash from a datastore entry. Entries
+	// for which the extractor returns a nil multihash and a nil error are
+	// skipped.
+	MultihashExtractor func(query.Entry) (multihash.Multihash, error)
+
+	datastoreCatalog struct {
+		ctx     context.Context
+		id      CatalogID
+		ds      datastore.Read
+		q       query.Query
+		extract MultihashExtractor
+	}
+	datastoreCatalogIterator struct {
+		*datastoreCatalog
+		results query.Results
+		next    multihash.Multihash
+		err     error
+		done    bool
+	}
+)
+
+// CatalogFromDatastore returns a catalog with the given ID that iterates over
+// the entries of a datastore matching the given query, typically restricted to
+// a key prefix, and extracts a multihash from each using extract. The query is
+// run every time the catalog is iterated, and is bound to the given context.
+//
+// Set query.Query.KeysOnly when the extractor only uses entry keys, as is the
+// case with MultihashFromKeyCid.
+func CatalogFromDatastore(ctx context.Context, id CatalogID, ds datastore.Read, q query.Query, extract MultihashExtractor) Catalog {
+	return &datastoreCatalog{
+		ctx:     ctx,
+		id:      id,
+		ds:      ds,
+		q:       q,
+		extract: extract,
+	}
+}
+
+// MultihashFromKeyCid extracts the multihash of the CID that is the base
+// namespace of the entry key, skipping entries whose key is not a CID.
+func MultihashFromKeyCid(e query.Entry) (multihash.Multihash, error) {
+	c, err := cid.Decode(datastore.RawKey(e.Key).BaseNamespace())
+	if err != nil {
+		return nil, nil
+	}
+	return c.Hash(), nil
+}
+
+// MultihashFromKeyMultihash extracts the multihash that is the base namespace
+// of the entry key, encoded as unpadded base32 as is done by blockstores, e.g.
+// to scan the "/blocks" prefix of the datastore of an IPFS node. Entries whose
+// key is not such a multihash are skipped.
+func MultihashFromKeyMultihash(e query.Entry) (multihash.Multihash, error) {
+	b, err := blockstoreKeyEncoding.DecodeString(datastore.RawKey(e.Key).BaseNamespace())
+	if err != nil {
+		return nil, nil
+	}
+	mh, err := multihash.Cast(b)
+	if err != nil {
+		return nil, nil
+	}
+	return mh, nil
+}
+
+// MultihashFromValue extracts the entry value as a multihash.
+func MultihashFromValue(e query.Entry) (multihash.Multihash, error) {
+	_, mh, err := multihash.MHFromBytes(e.Value)
+	return mh, err
+}
+
+func (c *datastoreCatalog) ID() []byte { return c.id }
+
+func (c *datastoreCatalog) Iterator() CatalogIterator {
+	iter := &datastoreCatalogIterator{datastoreCatalog: c}
+	iter.results, iter.err = c.ds.Query(c.ctx, c.q)
+	iter.advance()
+	return iter
+}
+
+func (c *datastoreCatalog) Transport() interface{ Providers() any } { return nil }
+
+func (i *datastoreCatalogIterator) advance() {
+	i.next = nil
+	if i.err != nil {
+		return
+	}
+	for {
+		r, ok := i.results.NextSync()
+		if !ok {
+			i.done = true
+			i.closeResults()
+			return
+		}
+		if r.Error != nil {
+			i.err = r.Error
+			i.closeResults()
+			return
+		}
+		mh, err := i.extract(r.Entry)
+		if err != nil {
+			defaultLoggers.publisherLogger.Errorw("failed to extract multihash from datastore entry", "key", r.Key, "err", err)
+			i.err = err
+			i.closeResults()
+			return
+		}
+		if mh != nil {
+			i.next = mh
+			return
+		}
+	}
+}
+
+func (i *datastoreCatalogIterator) closeResults() {
+	if err := i.results.Close(); err != nil {
+		defaultLoggers.publisherLogger.Debugw("failed to close datastore query results", "err", err)
+	}
+}
+
+func (i *datastoreCatalogIterator) Next() (multihash.Multihash, error) {
+	switch {
+	case i.err != nil:
+		return nil, i.err
+	case i.done:
+		return nil, ErrCatalogIteratorDone
+	}
+	next := i.next
+	i.advance()
+	return next, nil
+}
+
+func (i *datastoreCatalogIterator) Done() bool { return i.done && i.err == nil }
@@ -5,10 +5,6 @@ import (
 	"github.com/multiformats/go-multihash"
 )
 
-func CatalogFromCar() (Catalog, error) {
-	return nil, nil
-}
-
 func CatalogFromCids(cids ...cid.Cid) (Catalog, error) {
 	return nil, nil
 }
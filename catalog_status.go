@@ -0,0 +1,366 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	// catalogsPrefix is the namespace under which the status of each catalog
+	// is tracked, keyed by catalog ID.
+	catalogsPrefix = datastore.NewKey("catalogs")
+	// catalogsHeadKey is the head up to which catalog statuses are tracked.
+	// Statuses are caught up with the chain lazily when it moved otherwise
+	// than by publishing, e.g. by an import or a rollback.
+	catalogsHeadKey = datastore.NewKey("catalogs-head")
+	// catalogProvidersPrefix is the namespace under which the providers of
+	// tracked catalog statuses are kept, keyed by peer ID, so that catalogs
+	// are known to all be published for the default provider without
+	// looking them up.
+	catalogProvidersPrefix = datastore.NewKey("catalog-providers")
+)
+
+// CatalogStatus describes the latest advertisement of a catalog.
+type CatalogStatus struct {
+	// ID is the catalog ID, i.e. the context ID of its advertisements.
+	ID CatalogID `json:"id"`
+	// Advertisement is the CID of the latest advertisement of the catalog.
+	Advertisement cid.Cid `json:"ad"`
+	// Entries is the CID of the root entry chunk of the latest advertisement,
+	// or cid.Undef if it has none. The entries of a removal are those of the
+	// multihashes it removes.
+	Entries cid.Cid `json:"entries"`
+	// MultihashCount is the number of multihashes advertised for the catalog,
+	// or zero if unknown, e.g. for catalogs imported from another publisher.
+	MultihashCount int `json:"multihashCount"`
+	// Published is the time at which the latest advertisement was published,
+	// or the zero time if unknown.
+	Published time.Time `json:"published"`
+	// Retracted is set when the latest advertisement is a removal of the
+	// catalog as a whole.
+	Retracted bool `json:"retracted"`
+	// Provider is the provider of the latest advertisement.
+	Provider peer.ID `json:"provider,omitempty"`
+}
+
+func catalogStatusKey(id CatalogID) datastore.Key {
+	return catalogsPrefix.ChildString(catalogKeyString(id))
+}
+
+// ListCatalogs returns the status of every catalog published on the chain,
+// including retracted ones, in no particular order.
+func (h *Herald) ListCatalogs(ctx context.Context) ([]*CatalogStatus, error) {
+	return h.publisher.dsPublisher.listCatalogs(ctx)
+}
+
+// GetCatalogStatus returns the status of the catalog with the given ID, or
+// ErrCatalogNotFound if it has never been published.
+func (h *Herald) GetCatalogStatus(ctx context.Context, id CatalogID) (*CatalogStatus, error) {
+	return h.publisher.dsPublisher.getCatalogStatus(ctx, id)
+}
+
+func (l *dsPublisher) listCatalogs(ctx context.Context) ([]*CatalogStatus, error) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if err := l.syncCatalogs(ctx); err != nil {
+		return nil, err
+	}
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: catalogsPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	var statuses []*CatalogStatus
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var status CatalogStatus
+		if err := json.Unmarshal(r.Value, &status); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, &status)
+	}
+	return statuses, nil
+}
+
+// liveCatalogs returns the statuses of the catalogs of the given provider that
+// are not retracted, ordered from the most recently published. Catalogs whose
+// provider is unknown are included.
+func (l *dsPublisher) liveCatalogs(ctx context.Context, provider peer.ID) ([]*CatalogStatus, error) {
+	statuses, err := l.listCatalogs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	live := statuses[:0]
+	for _, status := range statuses {
+		if !status.Retracted && (status.Provider == "" || status.Provider == provider) {
+			live = append(live, status)
+		}
+	}
+	sort.SliceStable(live, func(i, j int) bool { return live[i].Published.After(live[j].Published) })
+	return live, nil
+}
+
+func (l *dsPublisher) getCatalogStatus(ctx context.Context, id CatalogID) (*CatalogStatus, error) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if err := l.syncCatalogs(ctx); err != nil {
+		return nil, err
+	}
+	status, err := l.loadCatalogStatus(ctx, id)
+	if err == nil && status == nil {
+		return nil, ErrCatalogNotFound
+	}
+	return status, err
+}
+
+// loadCatalogStatus returns the tracked status of the given catalog, or nil
+// if there is none.
+func (l *dsPublisher) loadCatalogStatus(ctx context.Context, id CatalogID) (*CatalogStatus, error) {
+	value, err := l.h.ds.Get(ctx, catalogStatusKey(id))
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var status CatalogStatus
+	if err := json.Unmarshal(value, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (l *dsPublisher) putCatalogStatus(ctx context.Context, status *CatalogStatus) error {
+	if _, ok := l.catalogProviders[status.Provider]; !ok && status.Provider != "" {
+		if err := l.h.ds.Put(ctx, catalogProvidersPrefix.ChildString(status.Provider.String()), nil); err != nil {
+			return err
+		}
+		l.catalogProviders[status.Provider] = struct{}{}
+	}
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	return l.h.ds.Put(ctx, catalogStatusKey(status.ID), value)
+}
+
+// loadCatalogProviders loads the providers of tracked catalog statuses.
+func (l *dsPublisher) loadCatalogProviders(ctx context.Context) error {
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: catalogProvidersPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	l.catalogProviders = make(map[peer.ID]struct{})
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		provider, err := peer.Decode(datastore.RawKey(r.Key).Name())
+		if err != nil {
+			l.h.datastoreLogger.Warnw("ignoring invalid catalog provider key", "key", r.Key, "err", err)
+			continue
+		}
+		l.catalogProviders[provider] = struct{}{}
+	}
+	return nil
+}
+
+// publishedForOtherProviders reports whether any tracked catalog status is of
+// a provider other than the default one. It is called with the publisher lock
+// held.
+func (l *dsPublisher) publishedForOtherProviders() bool {
+	for provider := range l.catalogProviders {
+		if provider != l.h.providerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *dsPublisher) getCatalogsHead(ctx context.Context) (cid.Cid, error) {
+	value, err := l.h.ds.Get(ctx, catalogsHeadKey)
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return cid.Undef, nil
+	case err != nil:
+		return cid.Undef, err
+	}
+	_, c, err := cid.CidFromBytes(value)
+	return c, err
+}
+
+// trackCatalog updates the status of a catalog once the given advertisement of
+// it is set as the head, chained onto previousHead. The status is left to be
+// caught up lazily unless those of all catalogs are tracked up to
+// previousHead. appended is set when the advertisement appends the multihashes
+// counted by status to the entries of the previous one. It is called with the
+// publisher lock held.
+func (l *dsPublisher) trackCatalog(ctx context.Context, previousHead, ad cid.Cid, status *CatalogStatus, appended bool) error {
+	if tracked, err := l.getCatalogsHead(ctx); err != nil || !tracked.Equals(previousHead) {
+		return err
+	}
+	previous, err := l.loadCatalogStatus(ctx, status.ID)
+	if err != nil {
+		return err
+	}
+	if previous != nil && !previous.Retracted {
+		switch {
+		case appended:
+			status.MultihashCount += previous.MultihashCount
+		case previous.Entries.Equals(status.Entries):
+			status.MultihashCount = previous.MultihashCount
+		}
+	}
+	status.Advertisement = ad
+	if err := l.putCatalogStatus(ctx, status); err != nil {
+		return err
+	}
+	return l.h.ds.Put(ctx, catalogsHeadKey, ad.Bytes())
+}
+
+// syncCatalogs catches the tracked catalog statuses up with the head, by
+// walking the chain back to the head they were tracked up to. They are all
+// rebuilt if that head is not on the chain, e.g. after a rollback. It is
+// called with the publisher lock held.
+func (l *dsPublisher) syncCatalogs(ctx context.Context) error {
+	head, err := l.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	tracked, err := l.getCatalogsHead(ctx)
+	if err != nil || tracked.Equals(head) {
+		return err
+	}
+	type latestAd struct {
+		cid cid.Cid
+		ad  *schema.Advertisement
+	}
+	var latest []latestAd
+	seen := make(map[string]struct{})
+	found := !tracked.Defined()
+	if err := l.walkChain(ctx, head, func(c cid.Cid, ad *schema.Advertisement) (bool, error) {
+		if c.Equals(tracked) {
+			found = true
+			return false, nil
+		}
+		if _, ok := seen[string(ad.ContextID)]; !ok {
+			seen[string(ad.ContextID)] = struct{}{}
+			latest = append(latest, latestAd{cid: c, ad: ad})
+		}
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	for _, a := range latest {
+		previous, err := l.loadCatalogStatus(ctx, a.ad.ContextID)
+		if err != nil {
+			return err
+		}
+		if previous != nil && previous.Advertisement.Equals(a.cid) {
+			continue
+		}
+		status := &CatalogStatus{
+			ID:            a.ad.ContextID,
+			Advertisement: a.cid,
+			Retracted:     isRetraction(a.ad),
+		}
+		if status.Provider, err = peer.Decode(a.ad.Provider); err != nil {
+			return err
+		}
+		if hasEntries(a.ad.Entries) {
+			status.Entries = a.ad.Entries.(cidlink.Link).Cid
+		}
+		// Multihashes are only counted as they are published.
+		if previous != nil && !previous.Retracted && previous.Entries.Equals(status.Entries) {
+			status.MultihashCount = previous.MultihashCount
+		}
+		if status.Published, err = l.publishedAt(ctx, a.cid); err != nil {
+			return err
+		}
+		if err := l.putCatalogStatus(ctx, status); err != nil {
+			return err
+		}
+	}
+	if !found {
+		if err := l.deleteCatalogStatuses(ctx, seen); err != nil {
+			return err
+		}
+	}
+	if err := l.h.ds.Put(ctx, catalogsHeadKey, head.Bytes()); err != nil {
+		return err
+	}
+	l.h.datastoreLogger.Infow("Caught up catalog statuses with head", "head", head, "from", headString(tracked), "rebuilt", !found, "updated", len(latest))
+	return nil
+}
+
+// remapCatalogs updates the advertisements of the catalog statuses tracked up
+// to previousHead once the chain is rewritten, along with the head they are
+// tracked up to. Statuses tracked up to another head are caught up with the
+// chain once read.
+func (l *dsPublisher) remapCatalogs(ctx context.Context, previousHead, head cid.Cid, rewritten map[cid.Cid]cid.Cid) error {
+	if tracked, err := l.getCatalogsHead(ctx); err != nil || !tracked.Equals(previousHead) {
+		return err
+	}
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: catalogsPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var status CatalogStatus
+		if err := json.Unmarshal(e.Value, &status); err != nil {
+			return err
+		}
+		c, ok := rewritten[status.Advertisement]
+		if !ok {
+			continue
+		}
+		status.Advertisement = c
+		if err := l.putCatalogStatus(ctx, &status); err != nil {
+			return err
+		}
+	}
+	return l.h.ds.Put(ctx, catalogsHeadKey, head.Bytes())
+}
+
+// deleteCatalogStatuses deletes the status of every catalog except those whose
+// IDs are kept.
+func (l *dsPublisher) deleteCatalogStatuses(ctx context.Context, keep map[string]struct{}) error {
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: catalogsPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var status CatalogStatus
+		if err := json.Unmarshal(e.Value, &status); err != nil {
+			return err
+		}
+		if _, ok := keep[string(status.ID)]; ok {
+			continue
+		}
+		if err := l.h.ds.Delete(ctx, datastore.RawKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+var ErrCatalogNotFound = errors.New("catalog is not found")
+
+// walkChain calls fn with each advertisement in the chain, starting from the
+// given CID and moving towards the oldest advertisement. Walking stops when
+// the chain ends, at the tail left by pruning, or when fn returns false.
+func (l *dsPublisher) walkChain(ctx context.Context, from cid.Cid, fn func(cid.Cid, *schema.Advertisement) (bool, error)) error {
+	tail, err := l.getTail(ctx)
+	if err != nil {
+		return err
+	}
+	for next := from; !cid.Undef.Equals(next); {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ad, err := l.loadAdvertisement(ctx, next)
+		if err != nil {
+			return err
+		}
+		if more, err := fn(next, ad); err != nil || !more {
+			return err
+		}
+		if ad.PreviousID == nil || next.Equals(tail) {
+			break
+		}
+		next = ad.PreviousID.(cidlink.Link).Cid
+	}
+	return nil
+}
+
+// latestAdvertisement is findLatestAdvertisement, taking the publisher lock.
+func (l *dsPublisher) latestAdvertisement(ctx context.Context, id CatalogID) (cid.Cid, *schema.Advertisement, error) {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	return l.findLatestAdvertisement(ctx, id)
+}
+
+// findLatestAdvertisement returns the most recent advertisement with the given
+// context ID, other than removals of some of its multihashes.
+// ErrCatalogNotFound is returned if there is no such advertisement or if the
+// catalog has since been retracted. It is called with the publisher lock held.
+func (l *dsPublisher) findLatestAdvertisement(ctx context.Context, id CatalogID) (cid.Cid, *schema.Advertisement, error) {
+	if err := l.syncCatalogs(ctx); err != nil {
+		return cid.Undef, nil, err
+	}
+	status, err := l.loadCatalogStatus(ctx, id)
+	switch {
+	case err != nil:
+		return cid.Undef, nil, err
+	case status == nil || status.Retracted:
+		return cid.Undef, nil, ErrCatalogNotFound
+	}
+	// The latest advertisement is that of the status unless it is a partial
+	// removal, in which case the chain is walked back from it.
+	var foundCid cid.Cid
+	var found *schema.Advertisement
+	if err := l.walkChain(ctx, status.Advertisement, func(c cid.Cid, ad *schema.Advertisement) (bool, error) {
+		if !bytes.Equal(ad.ContextID, id) || isPartialRemoval(ad) {
+			return true, nil
+		}
+		foundCid, found = c, ad
+		return false, nil
+	}); err != nil {
+		return cid.Undef, nil, err
+	}
+	if found == nil || found.IsRm {
+		return cid.Undef, nil, ErrCatalogNotFound
+	}
+	return foundCid, found, nil
+}
+
+// unchangedAdvertisement returns the latest advertisement of the catalog of
+// the given advertisement if it has the same provider, entries, metadata and
+// addresses, or cid.Undef otherwise.
+func (l *dsPublisher) unchangedAdvertisement(ctx context.Context, ad *schema.Advertisement) (cid.Cid, error) {
+	latestCid, latest, err := l.findLatestAdvertisement(ctx, ad.ContextID)
+	switch {
+	case errors.Is(err, ErrCatalogNotFound):
+		return cid.Undef, nil
+	case err != nil:
+		return cid.Undef, err
+	}
+	if latest.Provider != ad.Provider ||
+		latest.Entries.Binary() != ad.Entries.Binary() ||
+		!bytes.Equal(latest.Metadata, ad.Metadata) ||
+		len(latest.Addresses) != len(ad.Addresses) {
+		return cid.Undef, nil
+	}
+	for i := range ad.Addresses {
+		if latest.Addresses[i] != ad.Addresses[i] {
+			return cid.Undef, nil
+		}
+	}
+	return latestCid, nil
+}
+
+func (l *dsPublisher) loadAdvertisement(ctx context.Context, c cid.Cid) (*schema.Advertisement, error) {
+	n, err := l.ls.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, schema.AdvertisementPrototype)
+	if err != nil {
+		return nil, err
+	}
+	return schema.UnwrapAdvertisement(n)
+}
+
+func (l *dsPublisher) loadEntryChunk(ctx context.Context, c cid.Cid) (*schema.EntryChunk, error) {
+	n, err := l.entriesLs.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, schema.EntryChunkPrototype)
+	if err != nil {
+		return nil, err
+	}
+	return schema.UnwrapEntryChunk(n)
+}
+
+// hasEntries checks whether the given entries link refers to entry chunks.
+func hasEntries(entries ipld.Link) bool {
+	return entries != nil && entries != schema.NoEntries
+}
+
+// isRetraction checks whether the given advertisement retracts its catalog as
+// a whole, rather than removing only the multihashes in its entries.
+func isRetraction(ad *schema.Advertisement) bool {
+	return ad.IsRm && !hasEntries(ad.Entries)
+}
+
+// isPartialRemoval checks whether the given advertisement removes only the
+// multihashes in its entries from its catalog.
+func isPartialRemoval(ad *schema.Advertisement) bool {
+	return ad.IsRm && hasEntries(ad.Entries)
+}
+
+// blockLinks returns the CIDs of the blocks that the given block links to,
+// which for entries are the next entry chunk or the child nodes of a HAMT.
+func blockLinks(c cid.Cid, data []byte) ([]cid.Cid, error) {
+	n, err := decodeBlock(c, data, basicnode.Prototype.Any)
+	if err != nil {
+		return nil, err
+	}
+	var links []cid.Cid
+	var collect func(ipld.Node) error
+	collect = func(n ipld.Node) error {
+		switch n.Kind() {
+		case ipld.Kind_Link:
+			l, err := n.AsLink()
+			if err != nil {
+				return err
+			}
+			links = append(links, l.(cidlink.Link).Cid)
+		case ipld.Kind_Map:
+			for it := n.MapIterator(); !it.Done(); {
+				_, v, err := it.Next()
+				if err != nil {
+					return err
+				}
+				if err := collect(v); err != nil {
+					return err
+				}
+			}
+		case ipld.Kind_List:
+			for it := n.ListIterator(); !it.Done(); {
+				_, v, err := it.Next()
+				if err != nil {
+					return err
+				}
+				if err := collect(v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := collect(n); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
@@ -0,0 +1,60 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+// ChainIterator iterates over the advertisements of the chain, from the
+// newest, decoding each as it goes. See Herald.ChainIterator.
+type ChainIterator struct {
+	ctx  context.Context
+	p    *dsPublisher
+	next cid.Cid
+	tail cid.Cid
+}
+
+// ChainIterator returns an iterator over the advertisements of the chain in
+// reverse-chronological order, starting from the given advertisement, or from
+// the head if from is cid.Undef, and ending at the oldest advertisement
+// retained by pruning.
+func (h *Herald) ChainIterator(ctx context.Context, from cid.Cid) (*ChainIterator, error) {
+	p := h.publisher.dsPublisher
+	if !from.Defined() {
+		var err error
+		if from, err = p.GetHead(ctx); err != nil {
+			return nil, err
+		}
+	}
+	tail, err := p.getTail(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ChainIterator{ctx: ctx, p: p, next: from, tail: tail}, nil
+}
+
+// Next returns the next advertisement along with its CID. Once an error is
+// returned, the iterator is done.
+func (it *ChainIterator) Next() (cid.Cid, *schema.Advertisement, error) {
+	c := it.next
+	it.next = cid.Undef
+	if err := it.ctx.Err(); err != nil {
+		return cid.Undef, nil, err
+	}
+	ad, err := it.p.loadAdvertisement(it.ctx, c)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	if ad.PreviousID != nil && !c.Equals(it.tail) {
+		it.next = ad.PreviousID.(cidlink.Link).Cid
+	}
+	return c, ad, nil
+}
+
+// Done reports whether every advertisement has been returned by Next.
+func (it *ChainIterator) Done() bool {
+	return !it.next.Defined()
+}
@@ -0,0 +1,277 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+var (
+	// tailKey is the key at which the CID of the oldest advertisement retained
+	// by pruning is stored. The chain is walked up to it, since chains pruned
+	// before retained advertisements were rewritten still link past it.
+	tailKey = datastore.NewKey("tail")
+	// publishedPrefix is the namespace under which the publish time of each
+	// advertisement is stored when pruning by age.
+	publishedPrefix = datastore.NewKey("published")
+
+	// ChainPruning deletes the advertisements beyond the limits set via
+	// WithChainPruning. Publishes wait for it to complete.
+	ChainPruning = MaintenanceTask{Name: "chain-pruning", Run: pruneChain}
+)
+
+// prunableAd holds the fields of an advertisement needed to prune it.
+type prunableAd struct {
+	cid     cid.Cid
+	id      CatalogID
+	isRm    bool
+	entries cid.Cid
+	// partialRm is set for removals of some multihashes of the catalog.
+	partialRm bool
+}
+
+func publishedKey(ad cid.Cid) datastore.Key {
+	return publishedPrefix.ChildString(ad.String())
+}
+
+func pruneChain(ctx context.Context, h *Herald) error {
+	_, err := h.PruneChain(ctx)
+	return err
+}
+
+// PruneChain deletes the advertisements beyond the limits set via
+// WithChainPruning, and returns the number of deleted advertisements. The
+// head and the latest advertisement of every catalog that is not retracted,
+// other than removals of some of its multihashes, are retained, along with
+// every advertisement published after them. The retained advertisements are
+// rewritten as a chain starting from the oldest, which sets a new head; see
+// WithChainPruning.
+func (h *Herald) PruneChain(ctx context.Context) (int, error) {
+	if h.chainMaxLength == 0 && h.chainMaxAge == 0 {
+		return 0, nil
+	}
+	return h.publisher.dsPublisher.pruneChain(ctx, h.chainMaxLength, h.chainMaxAge)
+}
+
+func (l *dsPublisher) pruneChain(ctx context.Context, maxLength int, maxAge time.Duration) (int, error) {
+	l.gcLocker.Lock()
+	defer l.gcLocker.Unlock()
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if l.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	head, err := l.GetHead(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var ads []prunableAd
+	if err := l.walkChain(ctx, head, func(c cid.Cid, ad *schema.Advertisement) (bool, error) {
+		pad := prunableAd{cid: c, id: ad.ContextID, isRm: isRetraction(ad), partialRm: isPartialRemoval(ad)}
+		if hasEntries(ad.Entries) {
+			pad.entries = ad.Entries.(cidlink.Link).Cid
+		}
+		ads = append(ads, pad)
+		return true, nil
+	}); err != nil {
+		return 0, err
+	}
+
+	cut := len(ads)
+	if maxLength > 0 && maxLength < cut {
+		cut = maxLength
+	}
+	if maxAge > 0 {
+		before := time.Now().Add(-maxAge)
+		for i := 0; i < cut; i++ {
+			expired, err := l.publishedBefore(ctx, ads[i].cid, before)
+			if err != nil {
+				return 0, err
+			}
+			if expired {
+				cut = i
+				break
+			}
+		}
+	}
+	if cut == 0 {
+		cut = 1
+	}
+	// Partial removals are retained along with the latest advertisement of
+	// their catalog preceding them, whose multihashes they do not all remove.
+	seen := make(map[string]struct{})
+	for i, ad := range ads {
+		if _, ok := seen[string(ad.id)]; ok || ad.partialRm {
+			continue
+		}
+		seen[string(ad.id)] = struct{}{}
+		if !ad.isRm && i >= cut {
+			cut = i + 1
+		}
+	}
+	if cut >= len(ads) {
+		return 0, nil
+	}
+
+	// The retained advertisements are rewritten such that the oldest no
+	// longer links to a pruned one, and the chain is synced from the new head
+	// by indexers, including those that have not synced it before or whose
+	// latest synced advertisement is pruned.
+	rewritten, err := l.rewriteChain(ctx, ads[:cut])
+	if err != nil {
+		return 0, err
+	}
+	newHead, tail := rewritten[head], rewritten[ads[cut-1].cid]
+	if err := l.setHead(ctx, newHead); err != nil {
+		return 0, err
+	}
+	if err := l.h.ds.Put(ctx, tailKey, tail.Bytes()); err != nil {
+		return 0, err
+	}
+	// The pruning succeeded regardless; statuses are rebuilt from the chain
+	// once read, and diffs take their baseline from it.
+	if err := l.remapCatalogs(ctx, head, newHead, rewritten); err != nil {
+		l.h.datastoreLogger.Errorw("failed to update catalog statuses of rewritten chain", "err", err)
+	}
+	if err := l.remapDiffs(ctx, rewritten); err != nil {
+		l.h.datastoreLogger.Errorw("failed to update catalog diffs of rewritten chain", "err", err)
+	}
+	if l.recorder != nil {
+		if err := l.recorder.remap(ctx, rewritten); err != nil {
+			l.h.datastoreLogger.Errorw("failed to update publish records of rewritten chain", "err", err)
+		}
+	}
+	var retainedRoots, prunedRoots []cid.Cid
+	for i, ad := range ads {
+		if !ad.entries.Defined() {
+			continue
+		}
+		if i < cut {
+			retainedRoots = append(retainedRoots, ad.entries)
+		} else {
+			prunedRoots = append(prunedRoots, ad.entries)
+		}
+	}
+	retained, err := l.entriesBlocks(ctx, retainedRoots, nil)
+	if err != nil {
+		return 0, err
+	}
+	var deletedBlocks int
+	if _, err := l.entriesBlocks(ctx, prunedRoots, func(c cid.Cid) error {
+		if _, ok := retained[c]; ok {
+			return nil
+		}
+		deletedBlocks++
+		return l.entriesDs.Delete(ctx, dsKey(cidlink.Link{Cid: c}))
+	}); err != nil {
+		return 0, err
+	}
+	// The retained advertisements are deleted as rewritten, along with the
+	// pruned ones.
+	for i, ad := range ads {
+		keys := []datastore.Key{dsKey(cidlink.Link{Cid: ad.cid}), labelsKey(ad.cid), publishedKey(ad.cid)}
+		if _, ok := retained[ad.entries]; i >= cut && ad.entries.Defined() && !ok {
+			keys = append(keys, entriesIndexKey(ad.id, ad.entries))
+		}
+		for _, key := range keys {
+			if err := l.h.ds.Delete(ctx, key); err != nil {
+				l.h.datastoreLogger.Errorw("failed to delete pruned advertisement", "ad", ad.cid, "key", key, "err", err)
+				return 0, err
+			}
+		}
+	}
+	pruned := len(ads) - cut
+	l.h.datastoreLogger.Infow("Pruned advertisement chain", "previousHead", head, "head", newHead, "tail", tail, "retained", cut, "pruned", pruned, "deletedEntryBlocks", deletedBlocks)
+	return pruned, nil
+}
+
+// rewriteChain stores the given advertisements, ordered from the newest, anew
+// such that the oldest has no previous advertisement and each of the others
+// links to the rewritten one before it. Their labels and publish times are
+// carried over. It returns the rewritten CID of each advertisement.
+func (l *dsPublisher) rewriteChain(ctx context.Context, ads []prunableAd) (map[cid.Cid]cid.Cid, error) {
+	rewritten := make(map[cid.Cid]cid.Cid, len(ads))
+	var previous ipld.Link
+	for i := len(ads) - 1; i >= 0; i-- {
+		ad, err := l.loadAdvertisement(ctx, ads[i].cid)
+		if err != nil {
+			return nil, err
+		}
+		ad.PreviousID = previous
+		if err := ad.Sign(l.h.identity); err != nil {
+			return nil, err
+		}
+		n, err := ad.ToNode()
+		if err != nil {
+			return nil, err
+		}
+		link, err := l.ls.Store(ipld.LinkContext{Ctx: ctx}, l.h.linkPrototype, n)
+		if err != nil {
+			return nil, err
+		}
+		c := link.(cidlink.Link).Cid
+		for _, key := range []func(cid.Cid) datastore.Key{labelsKey, publishedKey} {
+			switch value, err := l.h.ds.Get(ctx, key(ads[i].cid)); {
+			case errors.Is(err, datastore.ErrNotFound):
+			case err != nil:
+				return nil, err
+			default:
+				if err := l.h.ds.Put(ctx, key(c), value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		rewritten[ads[i].cid], previous = c, link
+	}
+	return rewritten, nil
+}
+
+// publishedBefore reports whether the given advertisement is known to have
+// been published before the given time.
+func (l *dsPublisher) publishedBefore(ctx context.Context, ad cid.Cid, before time.Time) (bool, error) {
+	at, err := l.publishedAt(ctx, ad)
+	if err != nil || at.IsZero() {
+		return false, err
+	}
+	return at.Before(before), nil
+}
+
+// publishedAt returns the time at which the given advertisement was published,
+// or the zero time if it is not recorded.
+func (l *dsPublisher) publishedAt(ctx context.Context, ad cid.Cid) (time.Time, error) {
+	value, err := l.h.ds.Get(ctx, publishedKey(ad))
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return time.Time{}, nil
+	case err != nil:
+		return time.Time{}, err
+	}
+	at, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		l.h.datastoreLogger.Warnw("ignoring invalid advertisement publish time", "ad", ad, "err", err)
+		return time.Time{}, nil
+	}
+	return time.Unix(0, at), nil
+}
+
+// getTail returns the oldest advertisement retained by pruning, or cid.Undef
+// if the chain has never been pruned.
+func (l *dsPublisher) getTail(ctx context.Context) (cid.Cid, error) {
+	value, err := l.h.ds.Get(ctx, tailKey)
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return cid.Undef, nil
+	case err != nil:
+		return cid.Undef, err
+	}
+	_, c, err := cid.CidFromBytes(value)
+	return c, err
+}
@@ -0,0 +1,192 @@
+package herald_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestPruneChainSyncsFromScratch(t *testing.T) {
+	ctx := context.Background()
+	h, err := herald.New(heraldtest.Options(herald.WithChainPruning(2, 0))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	synced := heraldtest.NewSyncer(h, h.ID())
+	for _, c := range []herald.Catalog{
+		heraldtest.Catalog("a", 3),
+		heraldtest.Catalog("a", 5),
+		heraldtest.Catalog("b", 2),
+	} {
+		if _, err := h.Publish(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := synced.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []herald.Catalog{
+		heraldtest.Catalog("a", 7),
+		heraldtest.Catalog("a", 9),
+	} {
+		if _, err := h.Publish(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := h.PruneChain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Fatalf("expected 2 pruned advertisements, got %d", pruned)
+	}
+	if err := h.ValidateChain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both an indexer syncing from scratch and one whose latest synced
+	// advertisement is no longer on the chain must sync the retained chain.
+	for name, s := range map[string]*heraldtest.Syncer{
+		"scratch": heraldtest.NewSyncer(h, h.ID()),
+		"synced":  synced,
+	} {
+		ads, err := s.Sync(ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(ads) != 3 {
+			t.Fatalf("%s: expected 3 synced advertisements, got %d", name, len(ads))
+		}
+		if ads[0].Ad.PreviousID != nil {
+			t.Fatalf("%s: oldest retained advertisement links to %s", name, ads[0].Ad.PreviousID)
+		}
+		if got := len(s.Multihashes(h.ID(), []byte("a"))); got != 9 {
+			t.Fatalf("%s: expected 9 multihashes of a, got %d", name, got)
+		}
+		if got := len(s.Multihashes(h.ID(), []byte("b"))); got != 2 {
+			t.Fatalf("%s: expected 2 multihashes of b, got %d", name, got)
+		}
+	}
+
+	head, err := h.GetHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := h.GetCatalogStatus(ctx, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Advertisement.Equals(head) {
+		t.Fatalf("expected status of a at head %s, got %s", head, status.Advertisement)
+	}
+}
+
+func TestPruneChainAfterRemoveMultihashes(t *testing.T) {
+	ctx := context.Background()
+	h, err := herald.New(heraldtest.Options(herald.WithChainPruning(1, 0))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	a := heraldtest.Catalog("a", 3)
+	if _, err := h.Publish(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := a.Iterator().Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.RemoveMultihashes(ctx, a.ID(), []multihash.Multihash{removed}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Publish(ctx, heraldtest.Catalog("b", 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.PruneChain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ads, err := heraldtest.NewSyncer(h, h.ID()).Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ads) != 3 {
+		t.Fatalf("expected the put advertisement of a to be retained, got %d advertisements", len(ads))
+	}
+	entries, err := h.GetEntriesCAR(ctx, a.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries.Close()
+	if _, err := h.PublishAppend(ctx, heraldtest.Catalog("a", 4)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneChainRemapsPublishRecords(t *testing.T) {
+	ctx := context.Background()
+	records := sync.MutexWrap(datastore.NewMapDatastore())
+	h, err := herald.New(heraldtest.Options(
+		herald.WithChainPruning(2, 0),
+		herald.WithPublishRecorder(records),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	var b cid.Cid
+	for _, c := range []herald.Catalog{
+		heraldtest.Catalog("a", 3),
+		heraldtest.Catalog("a", 5),
+		heraldtest.Catalog("b", 2),
+		heraldtest.Catalog("a", 7),
+	} {
+		ad, err := h.Publish(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(c.ID()) == "b" {
+			b = ad
+		}
+	}
+	if _, err := h.PruneChain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ads, err := heraldtest.NewSyncer(h, h.ID()).Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ads) != 2 {
+		t.Fatalf("expected 2 retained advertisements, got %d", len(ads))
+	}
+
+	// Rolling back to the rewritten advertisement of b keeps the records up
+	// to it, which replay the unpruned chain.
+	if err := h.RollbackHead(ctx, ads[0].CID); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := herald.New(heraldtest.Options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	head, err := replayed.Replay(ctx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !head.Equals(b) {
+		t.Fatalf("expected replay to reproduce %s, got %s", b, head)
+	}
+
+}
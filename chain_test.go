@@ -0,0 +1,67 @@
+package herald_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestLatestAdvertisementFromCatalogStatus(t *testing.T) {
+	ctx := context.Background()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	h, err := herald.New(heraldtest.Options(herald.WithDatastore(ds))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	a := heraldtest.Catalog("a", 3)
+	put, err := h.Publish(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Publish(ctx, heraldtest.Catalog("b", 2)); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := a.Iterator().Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.RemoveMultihashes(ctx, a.ID(), []multihash.Multihash{removed}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := h.Publish(ctx, heraldtest.Catalog("c", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Publish(ctx, heraldtest.Catalog("d", 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest advertisement of a is found from its status, walking back
+	// from its partial removal, without walking the chain from the head.
+	if err := ds.Delete(ctx, datastore.NewKey("blocks").ChildString(c.Hash().B58String())); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := h.GetEntriesCAR(ctx, a.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries.Close()
+	if unchanged, err := h.Publish(ctx, a); err != nil {
+		t.Fatal(err)
+	} else if !unchanged.Equals(put) {
+		t.Fatalf("expected unchanged catalog to be skipped at %s, got %s", put, unchanged)
+	}
+	if _, err := h.PublishAppend(ctx, heraldtest.Catalog("a", 4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.GetEntriesCAR(ctx, []byte("c")); err == nil {
+		t.Fatal("expected the entries of c to be missing along with its advertisement")
+	}
+}
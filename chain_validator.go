@@ -0,0 +1,157 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+type (
+	ChainValidatorOption  func(*chainValidatorOptions)
+	chainValidatorOptions struct {
+		repair bool
+	}
+
+	// ChainValidator walks the advertisement chain to verify that it is
+	// intact and valid, e.g. after datastore corruption. Unlike
+	// ValidateChain, it reports every invalid advertisement rather than only
+	// the first.
+	ChainValidator struct {
+		h    *Herald
+		opts chainValidatorOptions
+	}
+
+	// ChainReport describes the outcome of validating the chain.
+	ChainReport struct {
+		// From is the advertisement from which the chain was walked.
+		From cid.Cid
+		// Validated is the number of advertisements loaded and checked.
+		Validated int
+		// Invalid are the advertisements that violate a validation rule,
+		// e.g. whose signature does not verify or whose entry chunks are not
+		// reachable, from the newest.
+		Invalid []InvalidAdvertisement
+		// BrokenLink is the first advertisement of the chain that could not
+		// be loaded, or cid.Undef if the chain is intact. LinkedFrom is the
+		// advertisement that links to it, or cid.Undef if it is From.
+		BrokenLink cid.Cid
+		LinkedFrom cid.Cid
+		// Truncated is set when the chain was truncated after LinkedFrom to
+		// repair the broken link. See WithChainRepair.
+		Truncated bool
+	}
+
+	// InvalidAdvertisement is an advertisement that violates a validation
+	// rule, as described by Err, which wraps ErrInvalidAdvertisement.
+	InvalidAdvertisement struct {
+		Advertisement cid.Cid
+		Err           error
+	}
+)
+
+// WithChainRepair truncates the chain when walking it from the head finds a
+// broken link, such that the chain ends at the oldest advertisement that can
+// be loaded, as pruning does. Invalid advertisements are only reported.
+// Disabled by default.
+func WithChainRepair(v bool) ChainValidatorOption {
+	return func(o *chainValidatorOptions) {
+		o.repair = v
+	}
+}
+
+// NewChainValidator instantiates a validator of the chain of the given Herald.
+func NewChainValidator(h *Herald, o ...ChainValidatorOption) *ChainValidator {
+	v := &ChainValidator{h: h}
+	for _, apply := range o {
+		apply(&v.opts)
+	}
+	return v
+}
+
+// Validate walks the chain backwards from the given advertisement, or from
+// the head if fromHead is cid.Undef, and checks every advertisement as
+// ValidateAdvertisement does. Problems found are reported rather than
+// returned as errors, which are returned only if validation cannot proceed.
+func (v *ChainValidator) Validate(ctx context.Context, fromHead cid.Cid) (*ChainReport, error) {
+	p := v.h.publisher.dsPublisher
+	head, err := p.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !fromHead.Defined() {
+		fromHead = head
+	}
+	tail, err := p.getTail(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &ChainReport{From: fromHead}
+	// Like indexers, skip the entries of advertisements whose catalog is
+	// retracted later in the chain, since they may have been deleted.
+	retracted := make(map[string]struct{})
+	var previous cid.Cid
+	for next := fromHead; next.Defined(); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		ad, err := p.loadAdvertisement(ctx, next)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			report.BrokenLink, report.LinkedFrom = next, previous
+			v.h.logger.Errorw("advertisement chain is broken", "from", fromHead, "ad", next, "linkedFrom", previous, "err", err)
+			break
+		}
+		report.Validated++
+		_, skipEntries := retracted[string(ad.ContextID)]
+		if isRetraction(ad) {
+			retracted[string(ad.ContextID)] = struct{}{}
+		}
+		switch err := p.validateAdvertisement(ctx, ad, skipEntries); {
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			return nil, err
+		case err != nil:
+			report.Invalid = append(report.Invalid, InvalidAdvertisement{Advertisement: next, Err: err})
+		}
+		if ad.PreviousID == nil || next.Equals(tail) {
+			break
+		}
+		previous, next = next, ad.PreviousID.(cidlink.Link).Cid
+	}
+	if report.BrokenLink.Defined() && v.opts.repair {
+		if err := v.truncate(ctx, report, head); err != nil {
+			return report, err
+		}
+	}
+	v.h.logger.Infow("Validated advertisement chain", "from", fromHead, "validated", report.Validated, "invalid", len(report.Invalid), "brokenLink", report.BrokenLink, "truncated", report.Truncated)
+	return report, nil
+}
+
+// truncate ends the chain at the advertisement linking to the broken link of
+// the given report, provided that the chain was walked from the head.
+func (v *ChainValidator) truncate(ctx context.Context, report *ChainReport, head cid.Cid) error {
+	switch {
+	case !report.From.Equals(head):
+		return fmt.Errorf("cannot repair chain walked from %s rather than the head %s", report.From, head)
+	case !report.LinkedFrom.Defined():
+		return fmt.Errorf("cannot repair chain whose head %s cannot be loaded; see RollbackHead", head)
+	}
+	p := v.h.publisher.dsPublisher
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if current, err := p.GetHead(ctx); err != nil {
+		return err
+	} else if !current.Equals(head) {
+		return fmt.Errorf("%w: head moved to %s while validating", ErrHeadMoved, current)
+	}
+	if err := v.h.ds.Put(ctx, tailKey, report.LinkedFrom.Bytes()); err != nil {
+		return err
+	}
+	report.Truncated = true
+	v.h.datastoreLogger.Warnw("truncated advertisement chain at broken link", "tail", report.LinkedFrom, "brokenLink", report.BrokenLink)
+	return nil
+}
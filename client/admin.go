@@ -0,0 +1,208 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/herald"
+)
+
+// adminErrors are the errors of Herald that admin responses are matched
+// against by status and message. See Error.
+var adminErrors = map[int]error{
+	http.StatusNotFound:           herald.ErrCatalogNotFound,
+	http.StatusBadRequest:         herald.ErrInvalidContextID,
+	http.StatusServiceUnavailable: herald.ErrClosed,
+	http.StatusNotImplemented:     herald.ErrNoPublishQueue,
+}
+
+// PublishCatalog streams the multihashes of the given catalog to the admin API
+// to be published, and returns the result, or nil if nothing was published.
+func (c *Client) PublishCatalog(ctx context.Context, catalog herald.Catalog) (*herald.IngestResult, error) {
+	return c.publishCatalog(ctx, catalog, false)
+}
+
+// PublishCatalogAppend is PublishCatalog, appending the multihashes to the
+// entries of the latest advertisement of the catalog as herald.PublishAppend
+// does.
+func (c *Client) PublishCatalogAppend(ctx context.Context, catalog herald.Catalog) (*herald.IngestResult, error) {
+	return c.publishCatalog(ctx, catalog, true)
+}
+
+func (c *Client) publishCatalog(ctx context.Context, catalog herald.Catalog, appendEntries bool) (*herald.IngestResult, error) {
+	u := c.adminCatalogURL("publish", catalog.ID())
+	if appendEntries {
+		u.RawQuery = url.Values{"append": {"true"}}.Encode()
+	}
+	// The multihashes are streamed as they are iterated, such that the
+	// catalog is not held in memory. An iteration error aborts the request,
+	// so that Herald does not publish the catalog partially.
+	body, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(writeMultihashes(w, catalog))
+	}()
+	resp, err := c.doAdmin(ctx, http.MethodPost, u, body)
+	if err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, newAdminError(resp)
+	}
+	var published *herald.IngestResult
+	for dec := json.NewDecoder(resp.Body); ; {
+		var result herald.IngestResult
+		if err := dec.Decode(&result); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode ingest result: %w", err)
+		}
+		if result.Error != "" {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: result.Error}
+		}
+		published = &result
+	}
+	return published, nil
+}
+
+// writeMultihashes writes the multihashes of the given catalog as the body of
+// an ingest request, one base58 multihash per line.
+func writeMultihashes(w io.Writer, catalog herald.Catalog) error {
+	bw := bufio.NewWriter(w)
+	for iter := catalog.Iterator(); !iter.Done(); {
+		mh, err := iter.Next()
+		if errors.Is(err, herald.ErrCatalogIteratorDone) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(mh.B58String() + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Retract retracts the catalog with the given ID, and returns the CID of the
+// removal advertisement. herald.ErrCatalogNotFound is returned if the catalog
+// is not advertised.
+func (c *Client) Retract(ctx context.Context, id herald.CatalogID) (cid.Cid, error) {
+	var resp struct {
+		Advertisement string `json:"advertisement"`
+	}
+	if err := c.callAdmin(ctx, http.MethodPost, c.adminCatalogURL("retract", id), &resp); err != nil {
+		return cid.Undef, err
+	}
+	return cid.Decode(resp.Advertisement)
+}
+
+// ListCatalogs returns the status of every catalog published, including
+// retracted ones.
+func (c *Client) ListCatalogs(ctx context.Context) ([]*herald.CatalogStatus, error) {
+	var statuses []*herald.CatalogStatus
+	if err := c.callAdmin(ctx, http.MethodGet, c.adminURL.JoinPath("catalogs"), &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// GetCatalog returns the status of the catalog with the given ID.
+// herald.ErrCatalogNotFound is returned if it has never been published.
+func (c *Client) GetCatalog(ctx context.Context, id herald.CatalogID) (*herald.CatalogStatus, error) {
+	var status herald.CatalogStatus
+	if err := c.callAdmin(ctx, http.MethodGet, c.adminCatalogURL("catalogs", id), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Stats returns statistics about the advertisement chain.
+func (c *Client) Stats(ctx context.Context) (*herald.AdminStats, error) {
+	var stats herald.AdminStats
+	if err := c.callAdmin(ctx, http.MethodGet, c.adminURL.JoinPath("stats"), &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Announce has Herald announce its head to indexers.
+func (c *Client) Announce(ctx context.Context) error {
+	return c.callAdmin(ctx, http.MethodPost, c.adminURL.JoinPath("announce"), nil)
+}
+
+// QueuedPublishes returns the publishes and retractions queued by Herald.
+// herald.ErrNoPublishQueue is returned unless its publish queue is enabled.
+func (c *Client) QueuedPublishes(ctx context.Context) ([]herald.QueuedPublish, error) {
+	var queued []herald.QueuedPublish
+	if err := c.callAdmin(ctx, http.MethodGet, c.adminURL.JoinPath("queue"), &queued); err != nil {
+		return nil, err
+	}
+	return queued, nil
+}
+
+// callAdmin calls the given admin route and decodes its JSON response into
+// v, unless nil.
+func (c *Client) callAdmin(ctx context.Context, method string, u *url.URL, v any) error {
+	resp, err := c.doAdmin(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	default:
+		return newAdminError(resp)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode admin response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) doAdmin(ctx context.Context, method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
+	return c.httpClient.Do(req)
+}
+
+// adminCatalogURL returns the URL of the given admin route for the catalog
+// with the given ID, which is escaped as a single path segment.
+func (c *Client) adminCatalogURL(route string, id herald.CatalogID) *url.URL {
+	u := c.adminURL.JoinPath(route)
+	escaped := u.EscapedPath()
+	u.Path += "/" + string(id)
+	u.RawPath = escaped + "/" + url.PathEscape(string(id))
+	return u
+}
+
+// newAdminError returns the error of the given admin response, wrapping the
+// corresponding error of Herald, if any.
+func newAdminError(resp *http.Response) error {
+	e := newError(resp).(*Error)
+	if target, ok := adminErrors[resp.StatusCode]; ok && strings.Contains(e.Message, target.Error()) {
+		e.err = target
+	}
+	return e
+}
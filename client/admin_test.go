@@ -0,0 +1,81 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/client"
+	"github.com/ipni/herald/heraldtest"
+)
+
+func TestAdminClient(t *testing.T) {
+	ctx := context.Background()
+	h, err := herald.New(heraldtest.Options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	admin := h.AdminHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fish" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		admin.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	c, err := client.New(server.URL, client.WithAdminToken("fish"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := c.PublishCatalog(ctx, heraldtest.Catalog("a/b", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published == nil || published.MultihashCount != 3 {
+		t.Fatalf("expected 3 multihashes to be published, got %+v", published)
+	}
+	status, err := c.GetCatalog(ctx, []byte("a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.MultihashCount != 3 || status.Advertisement.String() != published.Advertisement {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if statuses, err := c.ListCatalogs(ctx); err != nil {
+		t.Fatal(err)
+	} else if len(statuses) != 1 {
+		t.Fatalf("expected a single catalog, got %d", len(statuses))
+	}
+	if _, err := c.GetCatalog(ctx, []byte("c")); !errors.Is(err, herald.ErrCatalogNotFound) {
+		t.Fatalf("expected catalog not found, got %v", err)
+	}
+	removal, err := c.Retract(ctx, []byte("a/b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Head.Equals(removal) || stats.RetractedCount != 1 {
+		t.Fatalf("expected retraction %s to be the head, got %+v", removal, stats)
+	}
+	if _, err := c.QueuedPublishes(ctx); !errors.Is(err, herald.ErrNoPublishQueue) {
+		t.Fatalf("expected no publish queue, got %v", err)
+	}
+
+	unauthenticated, err := client.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cerr *client.Error
+	if _, err := unauthenticated.Stats(ctx); !errors.As(err, &cerr) || cerr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package client provides typed access to the HTTP API of a remote Herald
+// instance.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	_ herald.ChainSource = (*Client)(nil)
+
+	ErrUnexpectedSigner = errors.New("head is signed by an unexpected peer")
+)
+
+type (
+	Option  func(*options) error
+	options struct {
+		httpClient *http.Client
+		peerID     peer.ID
+		// adminURL and adminToken address the admin API. See WithAdminURL
+		// and WithAdminToken.
+		adminURL   string
+		adminToken string
+	}
+
+	// Client calls the HTTP API of a remote Herald instance.
+	Client struct {
+		*options
+		baseURL  *url.URL
+		adminURL *url.URL
+	}
+
+	// Error is returned when Herald responds with an unexpected status. It
+	// wraps the corresponding error of Herald, if any, e.g.
+	// herald.ErrCatalogNotFound.
+	Error struct {
+		StatusCode int
+		Message    string
+		err        error
+	}
+)
+
+// WithHttpClient sets the HTTP client used to call Herald. Defaults to
+// http.DefaultClient.
+func WithHttpClient(c *http.Client) Option {
+	return func(o *options) error {
+		o.httpClient = c
+		return nil
+	}
+}
+
+// WithPeerID sets the expected identity of Herald, against which the head
+// signature is checked. By default any valid signature is accepted.
+func WithPeerID(id peer.ID) Option {
+	return func(o *options) error {
+		o.peerID = id
+		return nil
+	}
+}
+
+// WithAdminURL sets the base URL of the admin API, e.g. that of the listener
+// set via herald.WithAdminServer. Defaults to the base URL of the client, for
+// when herald.AdminHandler is mounted alongside the publisher.
+func WithAdminURL(u string) Option {
+	return func(o *options) error {
+		o.adminURL = u
+		return nil
+	}
+}
+
+// WithAdminToken sets the bearer token sent along with calls to the admin API,
+// as set via herald.WithAdminServer. Defaults to none.
+func WithAdminToken(token string) Option {
+	return func(o *options) error {
+		o.adminToken = token
+		return nil
+	}
+}
+
+// New instantiates a client for the Herald instance at the given base URL,
+// including its path prefix if any.
+func New(baseURL string, o ...Option) (*Client, error) {
+	opts := options{
+		httpClient: http.DefaultClient,
+	}
+	for _, apply := range o {
+		if err := apply(&opts); err != nil {
+			return nil, err
+		}
+	}
+	u, err := parseBaseURL(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{options: &opts, baseURL: u, adminURL: u}
+	if opts.adminURL != "" {
+		if c.adminURL, err = parseBaseURL(opts.adminURL); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func parseBaseURL(v string) (*url.URL, error) {
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme: %q", u.Scheme)
+	}
+	return u, nil
+}
+
+func (e *Error) Unwrap() error { return e.err }
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected response status: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("unexpected response status: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// GetHead returns the head of the advertisement chain after verifying its
+// signature, or cid.Undef if nothing has been published yet.
+func (c *Client) GetHead(ctx context.Context) (cid.Cid, error) {
+	resp, err := c.get(ctx, ipnisync.IpniPath, "head")
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return cid.Undef, nil
+	default:
+		return cid.Undef, newError(resp)
+	}
+	signed, err := head.Decode(resp.Body)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to decode signed head: %w", err)
+	}
+	signer, err := signed.Validate()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if c.peerID != "" && signer != c.peerID {
+		return cid.Undef, fmt.Errorf("%w: %s", ErrUnexpectedSigner, signer)
+	}
+	return signed.Head.(cidlink.Link).Cid, nil
+}
+
+// GetContent returns the block with the given CID. herald.ErrContentNotFound
+// is returned if Herald does not have it.
+func (c *Client) GetContent(ctx context.Context, id cid.Cid) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, id.String())
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, herald.ErrContentNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, newError(resp)
+	}
+}
+
+// ProviderInfo returns the identity, addresses and head of the provider
+// published by Herald.
+func (c *Client) ProviderInfo(ctx context.Context) (*herald.ProviderInfo, error) {
+	resp, err := c.get(ctx, "provider")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newError(resp)
+	}
+	var info herald.ProviderInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode provider info: %w", err)
+	}
+	return &info, nil
+}
+
+func (c *Client) get(ctx context.Context, path ...string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL.JoinPath(path...).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpClient.Do(req)
+}
+
+func newError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+	}
+}
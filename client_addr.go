@@ -0,0 +1,109 @@
+package herald
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientAddrKey struct{}
+
+// withClientAddr resolves the address of the client that originated each
+// request and makes it available via clientAddr. When the request comes from
+// a trusted proxy, the address is taken from the Forwarded header, or the
+// X-Forwarded-For header in its absence, as the right-most address that is
+// not itself a trusted proxy. Forwarding headers from untrusted peers are
+// ignored, since they can be set to anything.
+func (p *httpPublisher) withClientAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := p.resolveClientAddr(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, addr)))
+	})
+}
+
+// clientAddr returns the address of the client that originated the request,
+// or the zero address if it cannot be determined.
+func clientAddr(r *http.Request) netip.Addr {
+	addr, _ := r.Context().Value(clientAddrKey{}).(netip.Addr)
+	return addr
+}
+
+func (p *httpPublisher) resolveClientAddr(r *http.Request) netip.Addr {
+	remote := parseHostAddr(r.RemoteAddr)
+	if !p.isTrustedProxy(remote) {
+		return remote
+	}
+	var forwarded []netip.Addr
+	if values := r.Header.Values("Forwarded"); len(values) != 0 {
+		forwarded = parseForwarded(values)
+	} else {
+		forwarded = parseXForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+	client := remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !forwarded[i].IsValid() {
+			// An obfuscated or malformed address ends the chain of trust.
+			break
+		}
+		client = forwarded[i]
+		if !p.isTrustedProxy(client) {
+			break
+		}
+	}
+	return client
+}
+
+func (p *httpPublisher) isTrustedProxy(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range p.h.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwarded extracts the "for" addresses from Forwarded headers as
+// specified by RFC 7239, in the order they were added.
+func parseForwarded(values []string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(k, "for") {
+					continue
+				}
+				addrs = append(addrs, parseHostAddr(strings.Trim(v, `"`)))
+			}
+		}
+	}
+	return addrs
+}
+
+func parseXForwardedFor(values []string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			addrs = append(addrs, parseHostAddr(strings.TrimSpace(v)))
+		}
+	}
+	return addrs
+}
+
+// parseHostAddr parses an IP address optionally followed by a port, with IPv6
+// addresses optionally enclosed in brackets.
+func parseHostAddr(v string) netip.Addr {
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		v = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(v, "[]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldgrpc"
+	"github.com/multiformats/go-multihash"
+	"google.golang.org/grpc"
+)
+
+// shutdownTimeout bounds the time waited for in-flight publishes on exit.
+const shutdownTimeout = 30 * time.Second
+
+// newHerald instantiates Herald according to the config, along with the
+// given options.
+func newHerald(cfg *config, o ...herald.Option) (*herald.Herald, error) {
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	h, err := herald.New(append(opts, o...)...)
+	if err != nil {
+		return nil, errors.Join(err, cfg.closeDatastore())
+	}
+	return h, nil
+}
+
+// shutdown shuts Herald down, and then closes the datastore opened for it.
+func shutdown(ctx context.Context, h *herald.Herald, cfg *config) error {
+	return errors.Join(h.Shutdown(ctx), cfg.closeDatastore())
+}
+
+// withHerald calls f with a Herald instance that is not started, and shuts it
+// down once f returns.
+func withHerald(ctx context.Context, cfg *config, f func(*herald.Herald) error) (err error) {
+	if cfg.Datastore == "" {
+		return errors.New("datastore must be set")
+	}
+	h, err := newHerald(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		err = errors.Join(err, shutdown(ctx, h, cfg))
+	}()
+	return f(h)
+}
+
+func runCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("run", flag.ContinueOnError)
+	cfg, err := parseFlags(fset, args)
+	if err != nil {
+		return err
+	}
+	h, err := newHerald(cfg)
+	if err != nil {
+		return err
+	}
+	if err := h.Start(ctx); err != nil {
+		return errors.Join(err, shutdown(ctx, h, cfg))
+	}
+	var server *grpc.Server
+	if cfg.GrpcListen != "" {
+		listener, err := net.Listen("tcp", cfg.GrpcListen)
+		if err != nil {
+			return errors.Join(err, shutdown(ctx, h, cfg))
+		}
+		server = grpc.NewServer()
+		heraldgrpc.Register(server, h)
+		go func() {
+			if err := server.Serve(listener); err != nil {
+				fmt.Fprintf(os.Stderr, "gRPC server stopped: %v\n", err)
+			}
+		}()
+		fmt.Fprintf(os.Stderr, "gRPC API is served at %v.\n", listener.Addr())
+	}
+	fmt.Fprintf(os.Stderr, "Herald is running at %v; interrupt to stop.\n", h.Addrs())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	fmt.Fprintln(os.Stderr, "Shutting down...")
+	if server != nil {
+		server.GracefulStop()
+	}
+	ctx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	return shutdown(ctx, h, cfg)
+}
+
+func publishCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("publish", flag.ContinueOnError)
+	carPath := fset.String("car", "", "Path to a CAR whose blocks are published as a catalog, identified by its root CID.")
+	mhPath := fset.String("multihashes", "", "Path to a file listing the CIDs or base58 multihashes of a catalog, one per line, or - for stdin.")
+	contextID := fset.String("context-id", "", "Base64 context ID of the catalog read from the multihash file.")
+	cfg, err := parseFlags(fset, args)
+	if err != nil {
+		return err
+	}
+	return withHerald(ctx, cfg, func(h *herald.Herald) error {
+		var catalog herald.Catalog
+		switch {
+		case *carPath != "" && *mhPath != "":
+			return errors.New("only one of -car and -multihashes may be set")
+		case *carPath != "":
+			if catalog, err = herald.CatalogFromCAR(*carPath); err != nil {
+				return err
+			}
+		case *mhPath != "":
+			id, err := parseContextID(*contextID)
+			if err != nil {
+				return err
+			}
+			f := os.Stdin
+			if *mhPath != "-" {
+				if f, err = os.Open(*mhPath); err != nil {
+					return err
+				}
+				defer f.Close()
+			}
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			mhs := make(chan multihash.Multihash)
+			errs := make(chan error, 1)
+			// A read error cancels the publish rather than closing mhs, so
+			// that the catalog is not published truncated.
+			go func() {
+				err := readMultihashes(ctx, f, mhs)
+				if err != nil {
+					cancel()
+				} else {
+					close(mhs)
+				}
+				errs <- err
+			}()
+			receipt, err := h.PublishWithOptions(ctx, herald.NewChannelCatalog(ctx, id, mhs))
+			cancel()
+			if readErr := <-errs; readErr != nil && !errors.Is(readErr, context.Canceled) {
+				return readErr
+			}
+			if err != nil {
+				return err
+			}
+			return printReceipt(id, receipt)
+		default:
+			return errors.New("one of -car or -multihashes must be set")
+		}
+		receipt, err := h.PublishWithOptions(ctx, catalog)
+		if err != nil {
+			return err
+		}
+		return printReceipt(catalog.ID(), receipt)
+	})
+}
+
+// readMultihashes sends the multihashes listed in r, one per line as a CID or
+// base58 multihash, ignoring empty lines.
+func readMultihashes(ctx context.Context, r io.Reader, mhs chan<- multihash.Multihash) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		v := strings.TrimSpace(scanner.Text())
+		if v == "" {
+			continue
+		}
+		var mh multihash.Multihash
+		if c, err := cid.Decode(v); err == nil {
+			mh = c.Hash()
+		} else if mh, err = multihash.FromB58String(v); err != nil {
+			return fmt.Errorf("invalid CID or multihash on line %d: %q", line, v)
+		}
+		select {
+		case mhs <- mh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+func printReceipt(id []byte, receipt *herald.PublishReceipt) error {
+	_, err := fmt.Printf("%s\t%s\n", receipt.Advertisement, formatContextID(id))
+	return err
+}
+
+func retractCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("retract", flag.ContinueOnError)
+	contextID := fset.String("context-id", "", "Base64 context ID of the catalog to retract.")
+	all := fset.Bool("all", false, "Retract every advertised catalog of the provider, e.g. to decommission it.")
+	cfg, err := parseFlags(fset, args)
+	if err != nil {
+		return err
+	}
+	var id herald.CatalogID
+	switch {
+	case *all && *contextID != "":
+		return errors.New("-all and -context-id are mutually exclusive")
+	case !*all:
+		if id, err = parseContextID(*contextID); err != nil {
+			return err
+		}
+	}
+	return withHerald(ctx, cfg, func(h *herald.Herald) error {
+		var ad cid.Cid
+		if *all {
+			ad, err = h.RetractAll(ctx)
+		} else {
+			ad, err = h.Retract(ctx, id)
+		}
+		if err != nil || !ad.Defined() {
+			return err
+		}
+		_, err = fmt.Println(ad)
+		return err
+	})
+}
+
+func headCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("head", flag.ContinueOnError)
+	cfg, err := parseFlags(fset, args)
+	if err != nil {
+		return err
+	}
+	return withHerald(ctx, cfg, func(h *herald.Herald) error {
+		head, err := h.GetHead(ctx)
+		if err != nil || !head.Defined() {
+			return err
+		}
+		_, err = fmt.Println(head)
+		return err
+	})
+}
+
+func exportCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("export", flag.ContinueOnError)
+	contextID := fset.String("context-id", "", "Base64 context ID of the catalog to export.")
+	output := fset.String("o", "-", "Path of the CAR to write, or - for stdout.")
+	cfg, err := parseFlags(fset, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseContextID(*contextID)
+	if err != nil {
+		return err
+	}
+	return withHerald(ctx, cfg, func(h *herald.Herald) error {
+		car, err := h.GetEntriesCAR(ctx, id)
+		if err != nil {
+			return err
+		}
+		defer car.Close()
+		w := os.Stdout
+		if *output != "-" {
+			if w, err = os.Create(*output); err != nil {
+				return err
+			}
+		}
+		_, err = io.Copy(w, car)
+		if w != os.Stdout {
+			err = errors.Join(err, w.Close())
+		}
+		return err
+	})
+}
+
+func verifyCommand(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("verify", flag.ContinueOnError)
+	repair := fset.Bool("repair", false, "Truncate the chain at the oldest advertisement that can be loaded, if a link is broken.")
+	cfg, err := parseFlags(fset, args)
+	if err != nil {
+		return err
+	}
+	return withHerald(ctx, cfg, func(h *herald.Herald) error {
+		report, err := herald.NewChainValidator(h, herald.WithChainRepair(*repair)).Validate(ctx, cid.Undef)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("validated %d advertisements from %s\n", report.Validated, report.From)
+		for _, invalid := range report.Invalid {
+			fmt.Printf("invalid %s: %v\n", invalid.Advertisement, invalid.Err)
+		}
+		if report.BrokenLink.Defined() {
+			fmt.Printf("broken link to %s from %s; truncated: %t\n", report.BrokenLink, report.LinkedFrom, report.Truncated)
+		}
+		if len(report.Invalid) != 0 || (report.BrokenLink.Defined() && !report.Truncated) {
+			return errors.New("chain is not valid")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+type (
+	// config holds the settings shared by all commands, which are read from
+	// the optional config file and overridden by flags.
+	config struct {
+		// Identity is the path to the file holding the libp2p private key of
+		// the publisher, which is generated if the file does not exist.
+		Identity string `json:"identity"`
+		// Datastore is the directory of the leveldb datastore in which the
+		// chain is stored.
+		Datastore string `json:"datastore"`
+		// ProviderID is the peer ID of the provider of content, when it
+		// differs from the publisher.
+		ProviderID string `json:"providerID"`
+		// ProviderAddrs are the multiaddrs at which content is retrievable.
+		ProviderAddrs stringList `json:"providerAddrs"`
+		// Metadata are the retrieval protocols advertised for content.
+		Metadata stringList `json:"metadata"`
+		Topic    string     `json:"topic"`
+		// Listen is the host:port or multiaddr, e.g. of a unix socket, on
+		// which the HTTP publisher listens.
+		Listen string `json:"listen"`
+		// AdminListen, when set, is the address on which the admin API is
+		// served, authenticated by AdminToken.
+		AdminListen string `json:"adminListen"`
+		AdminToken  string `json:"adminToken"`
+		// GrpcListen, when set, is the address on which the unauthenticated
+		// gRPC API is served by the run command.
+		GrpcListen string `json:"grpcListen"`
+		// Permissive accepts provider addresses that are not routable and
+		// unknown metadata protocols, e.g. for local testing.
+		Permissive bool `json:"permissive"`
+		// MaxConns limits the connections served concurrently by the HTTP
+		// publisher, where zero means no limit.
+		MaxConns int `json:"maxConns"`
+		// AnnounceURLs are the indexer URLs to which head updates are
+		// announced over HTTP.
+		AnnounceURLs stringList `json:"announceURLs"`
+		// AnnounceOnStart announces the current head when the run command
+		// starts.
+		AnnounceOnStart bool `json:"announceOnStart"`
+		// CorsOrigins are the origins of browser-based tools allowed to
+		// fetch the chain from the HTTP publisher, or "*" for any.
+		CorsOrigins stringList `json:"corsOrigins"`
+
+		// ds is the datastore opened by options, if any, which is closed by
+		// closeDatastore.
+		ds *leveldb.Datastore
+	}
+	// stringList is a comma-separated list flag.
+	stringList []string
+)
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// parseFlags parses the flags of a command, along with those common to all
+// commands. Settings are read from the config file, if any, then overridden by
+// the flags that are set.
+func parseFlags(fset *flag.FlagSet, args []string) (*config, error) {
+	cfg := config{
+		Metadata: stringList{"bitswap"},
+		Topic:    "/indexer/ingest/mainnet",
+		Listen:   "0.0.0.0:40080",
+	}
+	var path string
+	fset.StringVar(&path, "config", "", "Path to a JSON config file, whose settings are overridden by flags.")
+	fset.StringVar(&cfg.Identity, "identity", cfg.Identity, "Path to the private key of the publisher, as protobuf, base64 or PEM, generated if it does not exist.")
+	fset.StringVar(&cfg.Datastore, "datastore", cfg.Datastore, "Directory of the leveldb datastore in which the chain is stored.")
+	fset.StringVar(&cfg.ProviderID, "provider-id", cfg.ProviderID, "Peer ID of the provider of content, if other than the publisher.")
+	fset.Var(&cfg.ProviderAddrs, "provider-addrs", "Comma-separated multiaddrs at which content is retrievable.")
+	fset.Var(&cfg.Metadata, "metadata", "Comma-separated retrieval protocols of content: bitswap, http.")
+	fset.StringVar(&cfg.Topic, "topic", cfg.Topic, "Topic on which advertisements are announced.")
+	fset.StringVar(&cfg.Listen, "listen", cfg.Listen, "Address on which the HTTP publisher listens, as host:port or multiaddr, e.g. /unix/run/herald.sock.")
+	fset.StringVar(&cfg.AdminListen, "admin-listen", cfg.AdminListen, "Address on which the admin API is served, if any.")
+	fset.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "Bearer token authenticating admin API requests.")
+	fset.StringVar(&cfg.GrpcListen, "grpc-listen", cfg.GrpcListen, "Address on which the unauthenticated gRPC API is served by the run command, if any.")
+	fset.BoolVar(&cfg.Permissive, "permissive", cfg.Permissive, "Accept provider addresses that are not routable, e.g. for local testing.")
+	fset.IntVar(&cfg.MaxConns, "max-conns", cfg.MaxConns, "Maximum number of connections served concurrently by the HTTP publisher, or zero for no limit.")
+	fset.Var(&cfg.AnnounceURLs, "announce-urls", "Comma-separated indexer URLs to which head updates are announced over HTTP.")
+	fset.BoolVar(&cfg.AnnounceOnStart, "announce-on-start", cfg.AnnounceOnStart, "Announce the current head when the run command starts.")
+	fset.Var(&cfg.CorsOrigins, "cors-origins", "Comma-separated origins allowed to fetch the chain from browsers, or * for any.")
+	if err := fset.Parse(args); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return &cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	// Parse again so that flags take precedence over the config file.
+	return &cfg, fset.Parse(args)
+}
+
+// options returns the Herald options corresponding to the config.
+func (c *config) options() ([]herald.Option, error) {
+	opts := []herald.Option{
+		herald.WithTopic(c.Topic),
+		herald.WithPermissiveValidation(c.Permissive),
+		herald.WithHttpServerMaxConns(c.MaxConns),
+		herald.WithAnnounceOnStart(c.AnnounceOnStart),
+	}
+	if strings.HasPrefix(c.Listen, "/") {
+		addr, err := multiaddr.NewMultiaddr(c.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen multiaddr %q: %w", c.Listen, err)
+		}
+		opts = append(opts, herald.WithHttpPublisherListenMultiaddr(addr))
+	} else {
+		opts = append(opts, herald.WithHttpPublisherListenAddr(c.Listen))
+	}
+	if c.AdminListen != "" {
+		opts = append(opts, herald.WithAdminServer(c.AdminListen, c.AdminToken))
+	}
+	if c.Identity != "" {
+		opts = append(opts, herald.WithIdentityFromFile(c.Identity))
+	}
+	if len(c.CorsOrigins) != 0 {
+		opts = append(opts, herald.WithCors(c.CorsOrigins...))
+	}
+	if len(c.AnnounceURLs) != 0 {
+		urls := make([]*url.URL, 0, len(c.AnnounceURLs))
+		for _, s := range c.AnnounceURLs {
+			u, err := url.Parse(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid announce URL %q: %w", s, err)
+			}
+			urls = append(urls, u)
+		}
+		opts = append(opts, herald.WithHttpAnnouncer(urls...))
+	}
+	if c.ProviderID != "" {
+		id, err := peer.Decode(c.ProviderID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider ID %q: %w", c.ProviderID, err)
+		}
+		opts = append(opts, herald.WithProviderID(id))
+	}
+	if len(c.ProviderAddrs) != 0 {
+		addrs := make([]multiaddr.Multiaddr, 0, len(c.ProviderAddrs))
+		for _, s := range c.ProviderAddrs {
+			addr, err := multiaddr.NewMultiaddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid provider address %q: %w", s, err)
+			}
+			addrs = append(addrs, addr)
+		}
+		opts = append(opts, herald.WithProviderAddress(addrs...))
+	}
+	if len(c.Metadata) != 0 {
+		protocols := make([]metadata.Protocol, 0, len(c.Metadata))
+		for _, name := range c.Metadata {
+			switch name {
+			case "bitswap":
+				protocols = append(protocols, metadata.Bitswap{})
+			case "http":
+				protocols = append(protocols, metadata.IpfsGatewayHttp{})
+			default:
+				return nil, fmt.Errorf("unknown metadata protocol %q", name)
+			}
+		}
+		opts = append(opts, herald.WithMetadata(metadata.Default.New(protocols...)))
+	}
+	if c.Datastore != "" {
+		ds, err := openDatastore(c.Datastore)
+		if err != nil {
+			return nil, err
+		}
+		c.ds = ds
+		opts = append(opts, herald.WithDatastore(ds))
+	}
+	return opts, nil
+}
+
+// openDatastore opens the leveldb datastore in the given directory, creating
+// it if it does not exist.
+func openDatastore(dir string) (*leveldb.Datastore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return leveldb.NewDatastore(dir, nil)
+}
+
+// closeDatastore closes the datastore opened by options, if any, once Herald
+// is shut down.
+func (c *config) closeDatastore() error {
+	if c.ds == nil {
+		return nil
+	}
+	err := c.ds.Close()
+	c.ds = nil
+	return err
+}
+
+// parseContextID decodes a context ID given as unpadded URL-safe base64, as
+// printed by the publish command.
+func parseContextID(v string) (herald.CatalogID, error) {
+	if v == "" {
+		return nil, errors.New("context ID must be set")
+	}
+	id, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid context ID %q: %w", v, err)
+	}
+	return id, nil
+}
+
+func formatContextID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
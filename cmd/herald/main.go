@@ -0,0 +1,62 @@
+// Command herald runs a standalone IPNI publisher, and publishes or retracts
+// catalogs on its chain.
+//
+// Commands other than run open the datastore directly, and must not be used
+// on a datastore that a running instance uses.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{name: "run", summary: "Serve the chain and publish until interrupted.", run: runCommand},
+	{name: "publish", summary: "Publish a catalog read from a CAR or multihash file.", run: publishCommand},
+	{name: "retract", summary: "Retract a catalog, or every catalog.", run: retractCommand},
+	{name: "head", summary: "Print the head of the chain.", run: headCommand},
+	{name: "export", summary: "Export the entries of a catalog as a CAR.", run: exportCommand},
+	{name: "verify", summary: "Verify the chain, optionally truncating it at a broken link.", run: verifyCommand},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		switch err := c.run(context.Background(), os.Args[2:]); err {
+		case nil:
+			return
+		case flag.ErrHelp:
+			os.Exit(2)
+		default:
+			fmt.Fprintf(os.Stderr, "herald %s: %v\n", c.name, err)
+			os.Exit(1)
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: herald <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s%s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'herald <command> -h' for the flags of a command.")
+}
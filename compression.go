@@ -0,0 +1,168 @@
+package herald
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressSize is the size in bytes below which responses of known length
+// are not worth compressing.
+const minCompressSize = 1 << 10
+
+var (
+	gzipWriters = sync.Pool{
+		New: func() any { return gzip.NewWriter(nil) },
+	}
+	zstdWriters = sync.Pool{
+		New: func() any {
+			// Errors are only returned for invalid options.
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return w
+		},
+	}
+)
+
+type (
+	// compressWriter compresses the body of successful responses with the
+	// negotiated content encoding.
+	compressWriter struct {
+		http.ResponseWriter
+		encoding    string
+		encoder     resettableWriter
+		wroteHeader bool
+	}
+	resettableWriter interface {
+		io.WriteCloser
+		Reset(io.Writer)
+	}
+)
+
+// compressed wraps the given handler to compress its responses with zstd or
+// gzip, as negotiated via the Accept-Encoding header, unless disabled via
+// WithCompression. Range requests are never compressed, since ranges apply
+// to the encoded content, and neither are HEAD requests, so that they report
+// the size of the content.
+func (p *httpPublisher) compressed(next http.HandlerFunc) http.HandlerFunc {
+	if !p.h.compression {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+		if encoding == "" || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer func() {
+			if err := cw.close(); err != nil {
+				p.h.httpLogger.Debugw("failed to complete compressed response", "encoding", encoding, "client", clientAddr(r), "err", err)
+			}
+		}()
+		next(cw, r)
+	}
+}
+
+// negotiateEncoding returns the preferred content encoding among those
+// supported that is acceptable according to the given Accept-Encoding
+// headers, or the empty string if none is. zstd is preferred over gzip
+// regardless of quality values, as long as both are acceptable.
+func negotiateEncoding(values []string) string {
+	var zstdOk, gzipOk bool
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(element, ";")
+			if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+				if v, err := strconv.ParseFloat(q, 64); err != nil || v <= 0 {
+					continue
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "zstd":
+				zstdOk = true
+			case "gzip", "x-gzip", "*":
+				gzipOk = true
+			}
+		}
+	}
+	switch {
+	case zstdOk:
+		return "zstd"
+	case gzipOk:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.Header()
+	if status == http.StatusOK && header.Get("Content-Encoding") == "" && worthCompressing(header.Get("Content-Length")) {
+		var enc resettableWriter
+		switch w.encoding {
+		case "zstd":
+			enc = zstdWriters.Get().(*zstd.Encoder)
+		default:
+			enc = gzipWriters.Get().(*gzip.Writer)
+		}
+		enc.Reset(w.ResponseWriter)
+		w.encoder = enc
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// The compressed representation differs byte for byte, so its entity
+		// tag can only be weak.
+		if tag := header.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+			header.Set("ETag", "W/"+tag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// close flushes the compressed body, if any, and returns the encoder to its
+// pool.
+func (w *compressWriter) close() error {
+	if w.encoder == nil {
+		return nil
+	}
+	err := w.encoder.Close()
+	w.encoder.Reset(nil)
+	switch w.encoding {
+	case "zstd":
+		zstdWriters.Put(w.encoder)
+	default:
+		gzipWriters.Put(w.encoder)
+	}
+	w.encoder = nil
+	return err
+}
+
+// worthCompressing reports whether a response of the given Content-Length is
+// worth compressing, which is assumed when the length is unknown.
+func worthCompressing(contentLength string) bool {
+	if contentLength == "" {
+		return true
+	}
+	n, err := strconv.ParseInt(contentLength, 10, 64)
+	return err != nil || n >= minCompressSize
+}
@@ -0,0 +1,66 @@
+package herald
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+// ErrInvalidContextID signals that the ID of a catalog violates the
+// constraints on context IDs, either of the advertisement schema or those set
+// via WithMaxContextIDLen and WithContextIDNamespace.
+var ErrInvalidContextID = errors.New("invalid context ID")
+
+// WithMaxContextIDLen sets the maximum length of context IDs in bytes,
+// including their namespace, if any. Publishes and retractions of catalogs
+// with longer IDs fail with ErrInvalidContextID rather than being rejected by
+// indexers. Defaults to, and may not exceed, the maximum of the advertisement
+// schema, 64 bytes.
+func WithMaxContextIDLen(n int) Option {
+	return func(o *options) error {
+		if n <= 0 || n > schema.MaxContextIDLen {
+			return fmt.Errorf("maximum context ID length must be between 1 and %d", schema.MaxContextIDLen)
+		}
+		o.maxContextIDLen = n
+		return nil
+	}
+}
+
+// WithContextIDNamespace restricts the catalogs published and retracted to
+// those whose IDs start with the given namespace, e.g. one per tenant sharing
+// the same provider, such that their context IDs cannot collide. Other IDs
+// fail with ErrInvalidContextID. See NamespaceContextID.
+func WithContextIDNamespace(ns []byte) Option {
+	return func(o *options) error {
+		if len(ns) == 0 {
+			return errors.New("context ID namespace must not be empty")
+		}
+		o.contextIDNamespace = ns
+		return nil
+	}
+}
+
+// NamespaceContextID returns the ID of a catalog within the given namespace,
+// which is the ID prefixed with the namespace.
+func NamespaceContextID(ns []byte, id []byte) CatalogID {
+	nsID := make(CatalogID, 0, len(ns)+len(id))
+	return append(append(nsID, ns...), id...)
+}
+
+// validateContextID checks that the given catalog ID satisfies the context ID
+// constraints.
+func (o *options) validateContextID(id CatalogID) error {
+	switch {
+	case len(id) == 0:
+		return fmt.Errorf("%w: context ID is empty", ErrInvalidContextID)
+	case len(id) > o.maxContextIDLen:
+		return fmt.Errorf("%w: context ID is %d bytes, exceeding the maximum of %d", ErrInvalidContextID, len(id), o.maxContextIDLen)
+	case !bytes.HasPrefix(id, o.contextIDNamespace):
+		return fmt.Errorf("%w: context ID %q is not in namespace %q; see NamespaceContextID", ErrInvalidContextID, id, o.contextIDNamespace)
+	case len(id) == len(o.contextIDNamespace):
+		return fmt.Errorf("%w: context ID %q is only the namespace", ErrInvalidContextID, id)
+	}
+	return nil
+}
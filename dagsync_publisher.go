@@ -0,0 +1,69 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// The methods below make Herald satisfy the dagsync.Publisher interface of
+// go-libipni, so that it can be used in place of an ipnisync publisher. The
+// interface is not asserted at compile time, since importing dagsync pulls in
+// its graphsync based transport.
+
+// Addrs returns the HTTP multiaddrs that the publisher listens on, or nil if
+// Herald is not started. They do not include the path prefix set via
+// WithHttpPublisherPathPrefix, if any.
+func (h *Herald) Addrs() []multiaddr.Multiaddr {
+	addr := h.publisher.addr.Load()
+	if addr == nil {
+		return nil
+	}
+	ma, err := manet.FromNetAddr(*addr)
+	if err != nil {
+		h.httpLogger.Errorw("failed to convert listen address to multiaddr", "addr", *addr, "err", err)
+		return nil
+	}
+	return []multiaddr.Multiaddr{ma.Encapsulate(multiaddr.StringCast("/http"))}
+}
+
+// AddrInfo returns the peer ID of the publisher along with the addresses at
+// which it is reachable, as announced to indexers. Clients that sync from
+// these addresses verify the signature of the head against the peer ID.
+func (h *Herald) AddrInfo() peer.AddrInfo {
+	return peer.AddrInfo{ID: h.id, Addrs: h.announceAddrs()}
+}
+
+// ID returns the peer ID of the publisher.
+func (h *Herald) ID() peer.ID {
+	return h.id
+}
+
+// Protocol returns the multiaddr protocol code of the publisher transport.
+func (h *Herald) Protocol() int {
+	return multiaddr.P_HTTP
+}
+
+// SetRoot sets the head to the given CID without publishing an
+// advertisement. The advertisement must already be stored in the datastore.
+// Failures are logged, since the interface offers no way to report them.
+func (h *Herald) SetRoot(c cid.Cid) {
+	p := h.publisher.dsPublisher
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.readOnly {
+		h.logger.Errorw("cannot set root", "root", c, "err", ErrReadOnly)
+		return
+	}
+	if err := p.setHead(context.Background(), c); err != nil {
+		h.logger.Errorw("failed to set root", "root", c, "err", err)
+	}
+}
+
+// Close shuts down Herald, waiting for in-flight requests to complete.
+func (h *Herald) Close() error {
+	return h.Shutdown(context.Background())
+}
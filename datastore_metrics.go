@@ -0,0 +1,214 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+var (
+	_ datastore.Batching = (*instrumentedDatastore)(nil)
+	_ datastore.Batch    = (*instrumentedBatch)(nil)
+	_ StreamingDatastore = (*instrumentedStreamingDatastore)(nil)
+)
+
+const (
+	dsOpGet dsOp = iota
+	dsOpHas
+	dsOpGetSize
+	dsOpGetReader
+	dsOpQuery
+	dsOpPut
+	dsOpDelete
+	dsOpSync
+	dsOpBatchPut
+	dsOpBatchDelete
+	dsOpBatchCommit
+	dsOpCount
+)
+
+var dsOpNames = [dsOpCount]string{
+	dsOpGet:         "get",
+	dsOpHas:         "has",
+	dsOpGetSize:     "getSize",
+	dsOpGetReader:   "getReader",
+	dsOpQuery:       "query",
+	dsOpPut:         "put",
+	dsOpDelete:      "delete",
+	dsOpSync:        "sync",
+	dsOpBatchPut:    "batchPut",
+	dsOpBatchDelete: "batchDelete",
+	dsOpBatchCommit: "batchCommit",
+}
+
+type (
+	// DatastoreOpStats captures the operations of a kind performed on a
+	// datastore used by Herald. See WithDatastoreInstrumentation.
+	DatastoreOpStats struct {
+		// Datastore is either "main" or "entries", the latter being set
+		// only when entries are stored separately.
+		Datastore string
+		Op        string
+		Count     uint64
+		// Errors counts failed operations. Lookups of missing keys are not
+		// considered failures.
+		Errors       uint64
+		TotalLatency time.Duration
+		MaxLatency   time.Duration
+	}
+
+	dsOp         int
+	dsOpRecorder struct {
+		count, errors        atomic.Uint64
+		totalNanos, maxNanos atomic.Int64
+	}
+	instrumentedDatastore struct {
+		datastore.Datastore
+		name string
+		ops  [dsOpCount]dsOpRecorder
+	}
+	instrumentedStreamingDatastore struct {
+		*instrumentedDatastore
+		streaming StreamingDatastore
+	}
+	instrumentedBatch struct {
+		datastore.Batch
+		ds *instrumentedDatastore
+	}
+)
+
+// newInstrumentedDatastore wraps ds to record the count, latency and errors of
+// every operation. The wrapper implements StreamingDatastore only if ds does.
+func newInstrumentedDatastore(name string, ds datastore.Datastore) (datastore.Datastore, *instrumentedDatastore) {
+	ids := &instrumentedDatastore{Datastore: ds, name: name}
+	if sds, ok := ds.(StreamingDatastore); ok {
+		return &instrumentedStreamingDatastore{instrumentedDatastore: ids, streaming: sds}, ids
+	}
+	return ids, ids
+}
+
+func (r *dsOpRecorder) record(start time.Time, err error) {
+	elapsed := int64(time.Since(start))
+	r.count.Add(1)
+	r.totalNanos.Add(elapsed)
+	for {
+		max := r.maxNanos.Load()
+		if elapsed <= max || r.maxNanos.CompareAndSwap(max, elapsed) {
+			break
+		}
+	}
+	if err != nil && !errors.Is(err, datastore.ErrNotFound) {
+		r.errors.Add(1)
+	}
+}
+
+func (d *instrumentedDatastore) stats() []DatastoreOpStats {
+	stats := make([]DatastoreOpStats, 0, dsOpCount)
+	for op := range d.ops {
+		r := &d.ops[op]
+		stats = append(stats, DatastoreOpStats{
+			Datastore:    d.name,
+			Op:           dsOpNames[op],
+			Count:        r.count.Load(),
+			Errors:       r.errors.Load(),
+			TotalLatency: time.Duration(r.totalNanos.Load()),
+			MaxLatency:   time.Duration(r.maxNanos.Load()),
+		})
+	}
+	return stats
+}
+
+func (d *instrumentedDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	start := time.Now()
+	v, err := d.Datastore.Get(ctx, key)
+	d.ops[dsOpGet].record(start, err)
+	return v, err
+}
+
+func (d *instrumentedDatastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	start := time.Now()
+	v, err := d.Datastore.Has(ctx, key)
+	d.ops[dsOpHas].record(start, err)
+	return v, err
+}
+
+func (d *instrumentedDatastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	start := time.Now()
+	v, err := d.Datastore.GetSize(ctx, key)
+	d.ops[dsOpGetSize].record(start, err)
+	return v, err
+}
+
+func (d *instrumentedDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	start := time.Now()
+	v, err := d.Datastore.Query(ctx, q)
+	d.ops[dsOpQuery].record(start, err)
+	return v, err
+}
+
+func (d *instrumentedDatastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	start := time.Now()
+	err := d.Datastore.Put(ctx, key, value)
+	d.ops[dsOpPut].record(start, err)
+	return err
+}
+
+func (d *instrumentedDatastore) Delete(ctx context.Context, key datastore.Key) error {
+	start := time.Now()
+	err := d.Datastore.Delete(ctx, key)
+	d.ops[dsOpDelete].record(start, err)
+	return err
+}
+
+func (d *instrumentedDatastore) Sync(ctx context.Context, prefix datastore.Key) error {
+	start := time.Now()
+	err := d.Datastore.Sync(ctx, prefix)
+	d.ops[dsOpSync].record(start, err)
+	return err
+}
+
+// Batch returns a batch of the wrapped datastore if it supports batching, or
+// a batch that applies operations on commit otherwise.
+func (d *instrumentedDatastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	if bds, ok := d.Datastore.(datastore.Batching); ok {
+		b, err := bds.Batch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &instrumentedBatch{Batch: b, ds: d}, nil
+	}
+	return &instrumentedBatch{Batch: datastore.NewBasicBatch(d.Datastore), ds: d}, nil
+}
+
+func (d *instrumentedStreamingDatastore) GetReader(ctx context.Context, key datastore.Key) (io.ReadCloser, error) {
+	start := time.Now()
+	v, err := d.streaming.GetReader(ctx, key)
+	d.ops[dsOpGetReader].record(start, err)
+	return v, err
+}
+
+func (b *instrumentedBatch) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	start := time.Now()
+	err := b.Batch.Put(ctx, key, value)
+	b.ds.ops[dsOpBatchPut].record(start, err)
+	return err
+}
+
+func (b *instrumentedBatch) Delete(ctx context.Context, key datastore.Key) error {
+	start := time.Now()
+	err := b.Batch.Delete(ctx, key)
+	b.ds.ops[dsOpBatchDelete].record(start, err)
+	return err
+}
+
+func (b *instrumentedBatch) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := b.Batch.Commit(ctx)
+	b.ds.ops[dsOpBatchCommit].record(start, err)
+	return err
+}
@@ -0,0 +1,233 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+var (
+	schemaVersionKey = datastore.NewKey("version")
+	blocksPrefix     = datastore.NewKey("blocks")
+
+	// migrations upgrade the datastore schema in order; the migration at index
+	// i upgrades a datastore at schema version i to version i+1. Migrations must
+	// be safe to re-run, since a crash may interrupt one before the new version
+	// is stored.
+	migrations = []migration{
+		migrateNamespaceBlocks,
+		migrateBlocksByMultihash,
+		migrateCatalogProviders,
+	}
+)
+
+type migration func(context.Context, datastore.Datastore, *zap.SugaredLogger) error
+
+// migrateDatastore upgrades the schema of the given datastore to the latest
+// version by running all pending migrations in order.
+func migrateDatastore(ctx context.Context, ds datastore.Datastore, logger *zap.SugaredLogger) error {
+	current, err := getSchemaVersion(ctx, ds)
+	if err != nil {
+		return err
+	}
+	latest := len(migrations)
+	switch {
+	case current == latest:
+		logger.Debugw("datastore schema is up to date", "version", current)
+		return nil
+	case current > latest:
+		return fmt.Errorf("datastore schema version %d is newer than the latest supported version %d", current, latest)
+	}
+	for v := current; v < latest; v++ {
+		logger.Infow("migrating datastore schema", "from", v, "to", v+1)
+		if err := migrations[v](ctx, ds, logger); err != nil {
+			logger.Errorw("failed to migrate datastore schema", "from", v, "to", v+1, "err", err)
+			return fmt.Errorf("failed to migrate datastore schema from version %d to %d: %w", v, v+1, err)
+		}
+		if err := setSchemaVersion(ctx, ds, v+1); err != nil {
+			return err
+		}
+	}
+	logger.Infow("migrated datastore schema successfully", "version", latest)
+	return ds.Sync(ctx, datastore.NewKey("/"))
+}
+
+func getSchemaVersion(ctx context.Context, ds datastore.Datastore) (int, error) {
+	switch value, err := ds.Get(ctx, schemaVersionKey); {
+	case errors.Is(err, datastore.ErrNotFound):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	default:
+		v, err := strconv.Atoi(string(value))
+		if err != nil {
+			return 0, fmt.Errorf("invalid datastore schema version %q: %w", value, err)
+		}
+		return v, nil
+	}
+}
+
+func setSchemaVersion(ctx context.Context, ds datastore.Datastore, v int) error {
+	return ds.Put(ctx, schemaVersionKey, []byte(strconv.Itoa(v)))
+}
+
+// migrateNamespaceBlocks moves IPLD blocks stored at the root of the datastore,
+// keyed by their CID, under the blocks namespace.
+func migrateNamespaceBlocks(ctx context.Context, ds datastore.Datastore, logger *zap.SugaredLogger) error {
+	results, err := ds.Query(ctx, query.Query{})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	var w datastore.Write = ds
+	var batch datastore.Batch
+	if bds, ok := ds.(datastore.Batching); ok {
+		if batch, err = bds.Batch(ctx); err != nil {
+			return err
+		}
+		w = batch
+	}
+	var moved int
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		key := datastore.NewKey(r.Key)
+		if len(key.Namespaces()) != 1 {
+			continue
+		}
+		if _, err := cid.Decode(key.Name()); err != nil {
+			continue
+		}
+		if err := w.Put(ctx, blocksPrefix.Child(key), r.Value); err != nil {
+			return err
+		}
+		if err := w.Delete(ctx, key); err != nil {
+			return err
+		}
+		moved++
+	}
+	if batch != nil {
+		if err := batch.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	logger.Infow("moved blocks under namespace", "namespace", blocksPrefix, "count", moved)
+	return nil
+}
+
+// migrateBlocksByMultihash re-keys the blocks stored under the blocks
+// namespace from their CID to their multihash.
+func migrateBlocksByMultihash(ctx context.Context, ds datastore.Datastore, logger *zap.SugaredLogger) error {
+	results, err := ds.Query(ctx, query.Query{Prefix: blocksPrefix.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	var w datastore.Write = ds
+	var batch datastore.Batch
+	if bds, ok := ds.(datastore.Batching); ok {
+		if batch, err = bds.Batch(ctx); err != nil {
+			return err
+		}
+		w = batch
+	}
+	var moved int
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		key := datastore.NewKey(r.Key)
+		c, err := cid.Decode(key.Name())
+		if err != nil {
+			continue
+		}
+		// Keys of blocks already migrated may decode as CIDv0, in which case
+		// the key is unchanged.
+		newKey := blockKey(c.Hash())
+		if newKey.Equal(key) {
+			continue
+		}
+		if err := w.Put(ctx, newKey, r.Value); err != nil {
+			return err
+		}
+		if err := w.Delete(ctx, key); err != nil {
+			return err
+		}
+		moved++
+	}
+	if batch != nil {
+		if err := batch.Commit(ctx); err != nil {
+			return err
+		}
+	}
+	logger.Infow("re-keyed blocks by multihash", "count", moved)
+	return nil
+}
+
+// migrateCatalogProviders sets the provider of catalog statuses tracked before
+// they had one, from their latest advertisement, and keeps track of it under
+// catalogProvidersPrefix.
+func migrateCatalogProviders(ctx context.Context, ds datastore.Datastore, logger *zap.SugaredLogger) error {
+	results, err := ds.Query(ctx, query.Query{Prefix: catalogsPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	var updated int
+	for _, e := range entries {
+		var status CatalogStatus
+		if err := json.Unmarshal(e.Value, &status); err != nil {
+			return err
+		}
+		if status.Provider == "" {
+			data, err := ds.Get(ctx, blockKey(status.Advertisement.Hash()))
+			switch {
+			case errors.Is(err, datastore.ErrNotFound):
+				// The advertisement was pruned, e.g. the retraction of the
+				// catalog; the status is caught up with the chain once read.
+				continue
+			case err != nil:
+				return err
+			}
+			n, err := decodeBlock(status.Advertisement, data, schema.AdvertisementPrototype)
+			if err != nil {
+				return err
+			}
+			ad, err := schema.UnwrapAdvertisement(n)
+			if err != nil {
+				return err
+			}
+			if status.Provider, err = peer.Decode(ad.Provider); err != nil {
+				return err
+			}
+			value, err := json.Marshal(&status)
+			if err != nil {
+				return err
+			}
+			if err := ds.Put(ctx, datastore.RawKey(e.Key), value); err != nil {
+				return err
+			}
+			updated++
+		}
+		if err := ds.Put(ctx, catalogProvidersPrefix.ChildString(status.Provider.String()), nil); err != nil {
+			return err
+		}
+	}
+	logger.Infow("set providers of catalog statuses", "count", updated)
+	return nil
+}
@@ -0,0 +1,57 @@
+package herald
+
+import (
+	"sync"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// Denylist is a set of multihashes that must not be advertised. It is safe for
+// concurrent use, and can be used as a publish filter via its Allowed method:
+//
+//	denylist := herald.NewDenylist(takedowns...)
+//	h, err := herald.New(herald.WithContentFilter(denylist.Allowed), ...)
+type Denylist struct {
+	locker sync.RWMutex
+	mhs    map[string]struct{}
+}
+
+func NewDenylist(mhs ...multihash.Multihash) *Denylist {
+	d := &Denylist{mhs: make(map[string]struct{}, len(mhs))}
+	d.Add(mhs...)
+	return d
+}
+
+func (d *Denylist) Add(mhs ...multihash.Multihash) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	for _, mh := range mhs {
+		d.mhs[string(mh)] = struct{}{}
+	}
+}
+
+func (d *Denylist) Remove(mhs ...multihash.Multihash) {
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	for _, mh := range mhs {
+		delete(d.mhs, string(mh))
+	}
+}
+
+func (d *Denylist) Contains(mh multihash.Multihash) bool {
+	d.locker.RLock()
+	defer d.locker.RUnlock()
+	_, found := d.mhs[string(mh)]
+	return found
+}
+
+// Allowed reports whether the given multihash is not denied.
+func (d *Denylist) Allowed(mh multihash.Multihash) bool {
+	return !d.Contains(mh)
+}
+
+func (d *Denylist) Len() int {
+	d.locker.RLock()
+	defer d.locker.RUnlock()
+	return len(d.mhs)
+}
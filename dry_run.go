@@ -0,0 +1,162 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+var _ datastore.Batching = (*dryRunDatastore)(nil)
+
+// dryRunDatastore reads through to a datastore, while keeping the writes to it
+// in memory rather than writing them.
+type dryRunDatastore struct {
+	ds      datastore.Datastore
+	mu      sync.RWMutex
+	puts    map[datastore.Key][]byte
+	deletes map[datastore.Key]struct{}
+}
+
+// WithDryRun computes the advertisements and entries of publishes without
+// persisting or serving anything, e.g. to test catalog pipelines
+// deterministically: writes to the datastores are kept in memory rather than
+// written, and are lost with Herald; the chain is not served over any
+// transport, and head updates are not announced. Publish receipts still carry
+// the CIDs of the advertisements and entries that would have been published,
+// and successive publishes build on each other as they would otherwise.
+// Advertisement CIDs are stable across runs given the same identity, see
+// WithIdentity, and the same datastore contents. Disabled by default.
+func WithDryRun(v bool) Option {
+	return func(o *options) error {
+		o.dryRun = v
+		return nil
+	}
+}
+
+// applyDryRun disables transports and announcers, and wraps the datastores
+// such that they are not written to.
+func (o *options) applyDryRun() {
+	o.httpTransport, o.httpHandlerTransport, o.libp2pHost, o.blobStore, o.localPublisherDir = false, false, nil, nil, ""
+	o.gossipsubHost, o.httpAnnounceURLs, o.extraAnnouncers = nil, nil, nil
+	for _, ds := range []*datastore.Datastore{&o.ds, &o.entriesDs, &o.recorderDs, &o.publishQueueDs} {
+		if *ds != nil {
+			*ds = newDryRunDatastore(*ds)
+		}
+	}
+}
+
+func newDryRunDatastore(ds datastore.Datastore) *dryRunDatastore {
+	return &dryRunDatastore{
+		ds:      ds,
+		puts:    make(map[datastore.Key][]byte),
+		deletes: make(map[datastore.Key]struct{}),
+	}
+}
+
+func (d *dryRunDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	d.mu.RLock()
+	value, put := d.puts[key]
+	_, deleted := d.deletes[key]
+	d.mu.RUnlock()
+	switch {
+	case put:
+		return value, nil
+	case deleted:
+		return nil, datastore.ErrNotFound
+	}
+	return d.ds.Get(ctx, key)
+}
+
+func (d *dryRunDatastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	switch _, err := d.Get(ctx, key); {
+	case errors.Is(err, datastore.ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *dryRunDatastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	value, err := d.Get(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	return len(value), nil
+}
+
+func (d *dryRunDatastore) Put(_ context.Context, key datastore.Key, value []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.puts[key] = append([]byte(nil), value...)
+	delete(d.deletes, key)
+	return nil
+}
+
+func (d *dryRunDatastore) Delete(_ context.Context, key datastore.Key) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.puts, key)
+	d.deletes[key] = struct{}{}
+	return nil
+}
+
+// Query merges the entries written in memory with those of the underlying
+// datastore, which are ordered and limited once merged.
+func (d *dryRunDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	base := q
+	base.Orders, base.Offset, base.Limit = nil, 0, 0
+	results, err := d.ds.Query(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	var entries []query.Entry
+	d.mu.RLock()
+	for r := range results.Next() {
+		if r.Error != nil {
+			d.mu.RUnlock()
+			return nil, r.Error
+		}
+		key := datastore.RawKey(r.Key)
+		_, put := d.puts[key]
+		_, deleted := d.deletes[key]
+		if !put && !deleted {
+			entries = append(entries, r.Entry)
+		}
+	}
+	written := make([]query.Entry, 0, len(d.puts))
+	for key, value := range d.puts {
+		e := query.Entry{Key: key.String(), Size: len(value)}
+		if !q.KeysOnly {
+			e.Value = value
+		}
+		written = append(written, e)
+	}
+	d.mu.RUnlock()
+	rest, err := query.NaiveQueryApply(base, query.ResultsWithEntries(base, written)).Rest()
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, rest...)
+	merged := query.Query{Orders: q.Orders, Offset: q.Offset, Limit: q.Limit}
+	return query.NaiveQueryApply(merged, query.ResultsWithEntries(q, entries)), nil
+}
+
+func (d *dryRunDatastore) Sync(context.Context, datastore.Key) error { return nil }
+
+func (d *dryRunDatastore) Batch(context.Context) (datastore.Batch, error) {
+	return datastore.NewBasicBatch(d), nil
+}
+
+// Close discards the writes kept in memory, leaving the underlying datastore
+// open, since it is owned by the caller.
+func (d *dryRunDatastore) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.puts, d.deletes = make(map[datastore.Key][]byte), make(map[datastore.Key]struct{})
+	return nil
+}
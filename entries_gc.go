@@ -0,0 +1,186 @@
+package herald
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+var (
+	// entriesIndexPrefix is the namespace under which the roots of the
+	// entries published for each catalog are tracked, keyed by catalog ID and
+	// root CID.
+	entriesIndexPrefix = datastore.NewKey("entries")
+	// retractedPrefix is the namespace under which the time at which each
+	// tracked catalog was retracted is stored, until its entries are deleted.
+	retractedPrefix = datastore.NewKey("retracted")
+
+	// RetractedEntriesGC deletes the entries of catalogs retracted longer ago
+	// than the retention set via WithRetractedEntriesRetention.
+	RetractedEntriesGC = MaintenanceTask{Name: "retracted-entries-gc", Run: collectRetractedEntries}
+)
+
+func catalogKeyString(id CatalogID) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func entriesIndexKey(id CatalogID, root cid.Cid) datastore.Key {
+	return entriesIndexPrefix.ChildString(catalogKeyString(id)).ChildString(root.String())
+}
+
+func retractedKey(id CatalogID) datastore.Key {
+	return retractedPrefix.ChildString(catalogKeyString(id))
+}
+
+// trackEntries records the entries published for a catalog, or its
+// retraction, to the given datastore, so that its entries can be deleted once
+// retracted. It is called with the publisher lock held.
+func (l *dsPublisher) trackEntries(ctx context.Context, w datastore.Write, id CatalogID, entries cid.Cid, isRm bool) error {
+	if isRm {
+		// Only catalogs whose entries are tracked have any to delete.
+		results, err := l.h.ds.Query(ctx, query.Query{Prefix: entriesIndexPrefix.ChildString(catalogKeyString(id)).String(), KeysOnly: true, Limit: 1})
+		if err != nil {
+			return err
+		}
+		tracked, err := results.Rest()
+		if err != nil || len(tracked) == 0 {
+			return err
+		}
+		return w.Put(ctx, retractedKey(id), []byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
+	}
+	if err := w.Delete(ctx, retractedKey(id)); err != nil {
+		return err
+	}
+	if !entries.Defined() {
+		return nil
+	}
+	return w.Put(ctx, entriesIndexKey(id, entries), nil)
+}
+
+func collectRetractedEntries(ctx context.Context, h *Herald) error {
+	if h.retractedEntriesRetention < 0 {
+		return nil
+	}
+	return h.publisher.dsPublisher.collectRetractedEntries(ctx, time.Now().Add(-h.retractedEntriesRetention))
+}
+
+// collectRetractedEntries deletes the entries of catalogs retracted before the
+// given time, except for blocks that are also part of the entries of tracked
+// catalogs not being collected.
+func (l *dsPublisher) collectRetractedEntries(ctx context.Context, before time.Time) error {
+	l.gcLocker.Lock()
+	defer l.gcLocker.Unlock()
+
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: retractedPrefix.String()})
+	if err != nil {
+		return err
+	}
+	retracted, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	expired := make(map[string]struct{})
+	for _, r := range retracted {
+		at, err := strconv.ParseInt(string(r.Value), 10, 64)
+		if err != nil {
+			l.h.datastoreLogger.Warnw("ignoring invalid retraction time", "key", r.Key, "err", err)
+			continue
+		}
+		if time.Unix(0, at).Before(before) {
+			expired[datastore.RawKey(r.Key).Name()] = struct{}{}
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	results, err = l.h.ds.Query(ctx, query.Query{Prefix: entriesIndexPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	index, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	var collect, keep []cid.Cid
+	var indexKeys []datastore.Key
+	for _, r := range index {
+		key := datastore.RawKey(r.Key)
+		root, err := cid.Decode(key.Name())
+		if err != nil {
+			l.h.datastoreLogger.Warnw("ignoring invalid entries index key", "key", r.Key, "err", err)
+			continue
+		}
+		if _, ok := expired[key.Parent().Name()]; ok {
+			collect = append(collect, root)
+			indexKeys = append(indexKeys, key)
+		} else {
+			keep = append(keep, root)
+		}
+	}
+	kept, err := l.entriesBlocks(ctx, keep, nil)
+	if err != nil {
+		return err
+	}
+	var deleted int
+	if _, err := l.entriesBlocks(ctx, collect, func(c cid.Cid) error {
+		if _, ok := kept[c]; ok {
+			return nil
+		}
+		deleted++
+		return l.entriesDs.Delete(ctx, dsKey(cidlink.Link{Cid: c}))
+	}); err != nil {
+		return err
+	}
+	for _, key := range indexKeys {
+		if err := l.h.ds.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	for name := range expired {
+		if err := l.h.ds.Delete(ctx, retractedPrefix.ChildString(name)); err != nil {
+			return err
+		}
+	}
+	l.h.datastoreLogger.Infow("Deleted entries of retracted catalogs", "catalogs", len(expired), "deleted", deleted)
+	return nil
+}
+
+// entriesBlocks returns the blocks of the entries with the given roots, calling
+// fn, if set, with each block after its links are read. Blocks that are
+// already missing are skipped.
+func (l *dsPublisher) entriesBlocks(ctx context.Context, roots []cid.Cid, fn func(cid.Cid) error) (map[cid.Cid]struct{}, error) {
+	seen := make(map[cid.Cid]struct{})
+	for queue := roots; len(queue) != 0; queue = queue[1:] {
+		next := queue[0]
+		if _, ok := seen[next]; ok {
+			continue
+		}
+		seen[next] = struct{}{}
+		data, err := l.entriesDs.Get(ctx, dsKey(cidlink.Link{Cid: next}))
+		switch {
+		case errors.Is(err, datastore.ErrNotFound):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		links, err := blockLinks(next, data)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, links...)
+		if fn != nil {
+			if err := fn(next); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return seen, nil
+}
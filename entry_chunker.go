@@ -0,0 +1,305 @@
+package herald
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+var _ EntryChunker = (*linkedEntryChunker)(nil)
+
+// initialChunkCapacity bounds the multihashes for which the pending chunk of
+// linkedEntryChunker is preallocated.
+const initialChunkCapacity = 1 << 10
+
+type (
+	// EntryChunker lays out the multihashes of a catalog as advertisement
+	// entries. A chunker is used for a single publish.
+	EntryChunker interface {
+		// Add consumes the next multihash of the catalog, which is only
+		// valid until Add returns; chunkers must copy the multihashes they
+		// retain. See CatalogIterator.
+		Add(multihash.Multihash) error
+		// Finish stores any pending multihashes and returns the root link
+		// of the entries, or nil if no multihashes were added.
+		Finish() (ipld.Link, error)
+	}
+	// EntryChunkerFactory instantiates the chunker used for a publish, which
+	// must store blocks using the given link system and the link prototype
+	// returned by LinkPrototypeFromContext. When appending to the
+	// entries of a catalog, next is the root of the existing entries to which
+	// the new ones must link, and is nil otherwise. Chunkers whose layout
+	// cannot be appended to should return an error if next is not nil.
+	EntryChunkerFactory func(ctx context.Context, ls ipld.LinkSystem, next ipld.Link) (EntryChunker, error)
+
+	linkedEntryChunker struct {
+		ctx       context.Context
+		ls        ipld.LinkSystem
+		chunkSize int
+		maxDepth  int
+		maxAge    time.Duration
+		logger    *zap.SugaredLogger
+		// lock guards the fields below, which are also accessed by timer
+		// when flushing a chunk that reached maxAge.
+		lock  sync.Mutex
+		depth int
+		next  ipld.Link
+		mhs   []multihash.Multihash
+		timer *time.Timer
+		// arena holds the copies of the pending multihashes, to which mhs
+		// refer, and is reused across chunks.
+		arena []byte
+		// encoded is reused to encode chunks. See storeEntryChunk.
+		encoded []byte
+		// err is the error of the last flush triggered by timer.
+		err error
+	}
+)
+
+// LinkedEntryChunker lays out entries as a linked list of EntryChunk, each
+// holding up to chunkSize multihashes, as specified by IPNI. Adding a chunk
+// that would make the list longer than maxDepth fails with ErrEntriesTooDeep,
+// unless maxDepth is not positive.
+func LinkedEntryChunker(chunkSize, maxDepth int) EntryChunkerFactory {
+	return LinkedEntryChunkerWithMaxAge(chunkSize, maxDepth, 0)
+}
+
+// LinkedEntryChunkerWithMaxAge is like LinkedEntryChunker, except that a
+// partially filled chunk is also stored and linked once maxAge has elapsed
+// since its first multihash was added, so that publishes from slow streams
+// make progress without waiting for a full chunk. A non-positive maxAge
+// disables the deadline.
+func LinkedEntryChunkerWithMaxAge(chunkSize, maxDepth int, maxAge time.Duration) EntryChunkerFactory {
+	return func(ctx context.Context, ls ipld.LinkSystem, next ipld.Link) (EntryChunker, error) {
+		// Since chunks may be large relative to catalogs, pending
+		// multihashes grow up to chunkSize as needed, and are reused across
+		// chunks.
+		capacity := chunkSize
+		if capacity > initialChunkCapacity {
+			capacity = initialChunkCapacity
+		}
+		c := &linkedEntryChunker{
+			ctx:       ctx,
+			ls:        ls,
+			chunkSize: chunkSize,
+			maxDepth:  maxDepth,
+			maxAge:    maxAge,
+			next:      next,
+			mhs:       make([]multihash.Multihash, 0, capacity),
+			logger:    loggersFromContext(ctx).publisherLogger,
+		}
+		if next != nil && maxDepth > 0 {
+			var err error
+			if c.depth, err = c.existingDepth(); err != nil {
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+}
+
+// existingDepth returns the number of chunks in the list to which new chunks
+// are linked.
+func (c *linkedEntryChunker) existingDepth() (int, error) {
+	var depth int
+	for next := c.next; next != nil; depth++ {
+		n, err := c.ls.Load(ipld.LinkContext{Ctx: c.ctx}, next, schema.EntryChunkPrototype)
+		if err != nil {
+			return 0, err
+		}
+		chunk, err := schema.UnwrapEntryChunk(n)
+		if err != nil {
+			return 0, err
+		}
+		next = chunk.Next
+	}
+	return depth, nil
+}
+
+func (c *linkedEntryChunker) Add(mh multihash.Multihash) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	// The multihash is copied into the arena rather than individually, so
+	// that copies do not allocate once the arena has grown to fit a chunk.
+	// Growing it leaves the copies before it in place.
+	start := len(c.arena)
+	c.arena = append(c.arena, mh...)
+	c.mhs = append(c.mhs, c.arena[start:len(c.arena):len(c.arena)])
+	if len(c.mhs) >= c.chunkSize {
+		return c.flush()
+	}
+	if len(c.mhs) == 1 && c.maxAge > 0 {
+		c.timer = time.AfterFunc(c.maxAge, c.flushExpired)
+	}
+	return nil
+}
+
+func (c *linkedEntryChunker) Finish() (ipld.Link, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(c.mhs) != 0 {
+		if err := c.flush(); err != nil {
+			return nil, err
+		}
+	}
+	return c.next, nil
+}
+
+// flushExpired flushes the pending chunk once it reaches maxAge, unless it
+// has been flushed since.
+func (c *linkedEntryChunker) flushExpired() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.timer == nil || len(c.mhs) == 0 || c.err != nil {
+		return
+	}
+	c.logger.Debugw("Flushing entry chunk that reached max age", "mhCount", len(c.mhs), "maxAge", c.maxAge)
+	c.err = c.flush()
+}
+
+func (c *linkedEntryChunker) flush() error {
+	if c.maxDepth > 0 && c.depth+1 > c.maxDepth {
+		return fmt.Errorf("%w: more than %d entry chunks; publish the catalog as multiple catalogs or increase the entries chunk size", ErrEntriesTooDeep, c.maxDepth)
+	}
+	var err error
+	chunk := schema.EntryChunk{Entries: c.mhs, Next: c.next}
+	if c.next, c.encoded, err = storeEntryChunk(c.ctx, c.ls, LinkPrototypeFromContext(c.ctx), &chunk, c.encoded); err != nil {
+		return err
+	}
+	c.depth++
+	// The chunk is encoded by now, so that neither the multihashes nor their
+	// copies are referred to any longer.
+	c.mhs, c.arena = c.mhs[:0], c.arena[:0]
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	return nil
+}
+
+// storeEntryChunk stores the given chunk using the given link system. Chunks
+// of dag-json links are encoded directly into buf, which is returned for reuse,
+// since wrapping and encoding them as IPLD nodes allocates for every
+// multihash. The encoding is identical either way.
+func storeEntryChunk(ctx context.Context, ls ipld.LinkSystem, lp ipld.LinkPrototype, chunk *schema.EntryChunk, buf []byte) (ipld.Link, []byte, error) {
+	clp, ok := lp.(cidlink.LinkPrototype)
+	if !ok || multicodec.Code(clp.Codec) != multicodec.DagJson {
+		n, err := chunk.ToNode()
+		if err != nil {
+			return nil, buf, err
+		}
+		l, err := ls.Store(ipld.LinkContext{Ctx: ctx}, lp, n)
+		return l, buf, err
+	}
+	buf = appendEntryChunkDagJson(buf[:0], chunk)
+	c, err := clp.Sum(buf)
+	if err != nil {
+		return nil, buf, err
+	}
+	l := cidlink.Link{Cid: c}
+	w, commit, err := ls.StorageWriteOpener(ipld.LinkContext{Ctx: ctx})
+	if err != nil {
+		return nil, buf, err
+	}
+	if _, err := w.Write(buf); err != nil {
+		return nil, buf, err
+	}
+	return l, buf, commit(l)
+}
+
+// appendEntryChunkDagJson appends the dag-json encoding of the given chunk to
+// dst, with map keys sorted as by the dag-json codec.
+func appendEntryChunkDagJson(dst []byte, chunk *schema.EntryChunk) []byte {
+	// The encoded size is computed upfront, so that dst grows at most once.
+	size := len(`{"Entries":[]}`)
+	for _, mh := range chunk.Entries {
+		size += len(`{"/":{"bytes":""}},`) + base64.RawStdEncoding.EncodedLen(len(mh))
+	}
+	if chunk.Next != nil {
+		size += len(`,"Next":{"/":""}`) + len(chunk.Next.String())
+	}
+	if cap(dst)-len(dst) < size {
+		dst = append(make([]byte, 0, len(dst)+size), dst...)
+	}
+	dst = append(dst, `{"Entries":[`...)
+	for i, mh := range chunk.Entries {
+		if i != 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, `{"/":{"bytes":"`...)
+		n := base64.RawStdEncoding.EncodedLen(len(mh))
+		dst = append(dst, make([]byte, n)...)
+		base64.RawStdEncoding.Encode(dst[len(dst)-n:], mh)
+		dst = append(dst, `"}}`...)
+	}
+	dst = append(dst, ']')
+	if chunk.Next != nil {
+		dst = append(dst, `,"Next":{"/":"`...)
+		dst = append(dst, chunk.Next.String()...)
+		dst = append(dst, `"}`...)
+	}
+	return append(dst, '}')
+}
+
+// dedupingLinkSystem returns a copy of the given link system that skips
+// storing blocks already in the given datastore, incrementing reused for each.
+// Since entry chunks are content-addressed, re-publishing multihashes that were
+// chunked before reuses their existing chunks.
+func dedupingLinkSystem(ls ipld.LinkSystem, ds datastore.Read, reused *int) ipld.LinkSystem {
+	deduping := ls
+	deduping.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := ls.StorageWriteOpener(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, func(l ipld.Link) error {
+			switch exists, err := ds.Has(lctx.Ctx, dsKey(l)); {
+			case err != nil:
+				return err
+			case exists:
+				*reused++
+				return nil
+			}
+			return commit(l)
+		}, nil
+	}
+	return deduping
+}
+
+// countingLinkSystem returns a copy of the given link system that increments
+// count every time a block is stored.
+func countingLinkSystem(ls ipld.LinkSystem, count *int) ipld.LinkSystem {
+	counting := ls
+	counting.StorageWriteOpener = func(lctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		w, commit, err := ls.StorageWriteOpener(lctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, func(l ipld.Link) error {
+			if err := commit(l); err != nil {
+				return err
+			}
+			*count++
+			return nil
+		}, nil
+	}
+	return counting
+}
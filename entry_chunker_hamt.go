@@ -0,0 +1,79 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ EntryChunker = (*hamtEntryChunker)(nil)
+
+	// ErrAppendUnsupported signals that the configured entry chunker cannot
+	// append to existing entries.
+	ErrAppendUnsupported = errors.New("entry chunker does not support appending")
+)
+
+type hamtEntryChunker struct {
+	ctx     context.Context
+	ls      ipld.LinkSystem
+	builder *hamt.Builder
+	ma      ipld.MapAssembler
+	count   int
+}
+
+// HamtEntryChunker lays out entries as a HAMT keyed by multihash, as
+// specified by IPNI, with the given bit width, bucket size and hash algorithm,
+// e.g. 5, 3 and multicodec.Murmur3X64_64. HAMT entries cannot be appended to;
+// PublishAppend fails with ErrAppendUnsupported.
+//
+// Building the HAMT rewrites interior nodes as multihashes are inserted, which
+// leaves superseded nodes behind in the datastore; see OrphanBlocksGC.
+func HamtEntryChunker(bitWidth, bucketSize int, hashAlg multicodec.Code) EntryChunkerFactory {
+	return func(ctx context.Context, ls ipld.LinkSystem, next ipld.Link) (EntryChunker, error) {
+		if next != nil {
+			return nil, ErrAppendUnsupported
+		}
+		proto := hamt.Prototype{BitWidth: bitWidth, BucketSize: bucketSize}.WithHashAlg(hashAlg)
+		builder := hamt.NewBuilder(proto).WithLinking(ls, LinkPrototypeFromContext(ctx))
+		ma, err := builder.BeginMap(0)
+		if err != nil {
+			return nil, err
+		}
+		return &hamtEntryChunker{
+			ctx:     ctx,
+			ls:      ls,
+			builder: builder,
+			ma:      ma,
+		}, nil
+	}
+}
+
+func (c *hamtEntryChunker) Add(mh multihash.Multihash) error {
+	// The HAMT retains its keys until it is stored.
+	if err := c.ma.AssembleKey().AssignBytes(bytes.Clone(mh)); err != nil {
+		return err
+	}
+	if err := c.ma.AssembleValue().AssignBool(true); err != nil {
+		return err
+	}
+	c.count++
+	return nil
+}
+
+func (c *hamtEntryChunker) Finish() (ipld.Link, error) {
+	if c.count == 0 {
+		return nil, nil
+	}
+	if err := c.ma.Finish(); err != nil {
+		return nil, err
+	}
+	root := hamt.Build(c.builder).Substrate().(schema.TypedNode).Representation()
+	return c.ls.Store(ipld.LinkContext{Ctx: c.ctx}, LinkPrototypeFromContext(c.ctx), root)
+}
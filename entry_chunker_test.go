@@ -0,0 +1,72 @@
+package herald
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestAppendEntryChunkDagJson(t *testing.T) {
+	lp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+		Version:  1,
+		Codec:    uint64(multicodec.DagJson),
+		MhType:   multihash.SHA2_256,
+		MhLength: -1,
+	}}
+	entries := func(n int) []multihash.Multihash {
+		mhs := make([]multihash.Multihash, n)
+		for i := range mhs {
+			mh, err := multihash.Sum([]byte(fmt.Sprint(i)), multihash.SHA2_256, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mhs[i] = mh
+		}
+		return mhs
+	}
+	ls := cidlink.DefaultLinkSystem()
+	next, err := lp.Sum([]byte("next"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, chunk := range map[string]*schema.EntryChunk{
+		"empty":  {},
+		"single": {Entries: entries(1)},
+		// The default chunk size of WithAdEntriesChunkSize.
+		"max":  {Entries: entries(16 << 10)},
+		"next": {Entries: entries(3), Next: cidlink.Link{Cid: next}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			n, err := chunk.ToNode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want bytes.Buffer
+			if err := dagjson.Encode(n, &want); err != nil {
+				t.Fatal(err)
+			}
+			got := appendEntryChunkDagJson([]byte("reused"), chunk)[len("reused"):]
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Fatalf("expected %s, got %s", want.Bytes(), got)
+			}
+			wantLink, err := ls.ComputeLink(lp, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotCid, err := lp.Sum(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantCid := wantLink.(cidlink.Link).Cid; !gotCid.Equals(wantCid) {
+				t.Fatalf("expected CID %s, got %s", wantCid, gotCid)
+			}
+		})
+	}
+}
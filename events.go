@@ -0,0 +1,158 @@
+package herald
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+type (
+	// Event is emitted by Herald over the course of publishes, retractions
+	// and announcements. It is one of PublishStarted, ChunksGenerated,
+	// AdStored, HeadUpdated, AnnounceSucceeded, AnnounceFailed and
+	// RetractCompleted. See Subscribe.
+	Event interface {
+		isEvent()
+	}
+
+	// PublishStarted is emitted when a catalog starts being published.
+	PublishStarted struct {
+		ID     CatalogID
+		Append bool
+	}
+	// ChunksGenerated is emitted once the entries of a catalog are stored,
+	// where Entries is cid.Undef if it has no multihashes.
+	ChunksGenerated struct {
+		ID               CatalogID
+		Entries          cid.Cid
+		MultihashCount   int
+		ChunkCount       int
+		FilteredCount    int
+		ReusedChunkCount int
+	}
+	// AdStored is emitted once an advertisement is signed and stored, before
+	// it is set as the head. It does not become the head if the publish fails
+	// afterwards.
+	AdStored struct {
+		ID            CatalogID
+		Advertisement cid.Cid
+		IsRm          bool
+	}
+	// HeadUpdated is emitted once the head is set, before it is announced.
+	HeadUpdated struct {
+		Previous cid.Cid
+		Head     cid.Cid
+	}
+	// AnnounceSucceeded and AnnounceFailed are emitted for each announcer
+	// once it announces a head, named as in logs and metrics.
+	AnnounceSucceeded struct {
+		Announcer string
+		Head      cid.Cid
+	}
+	AnnounceFailed struct {
+		Announcer string
+		Head      cid.Cid
+		Err       error
+	}
+	// RetractCompleted is emitted once a catalog is retracted.
+	RetractCompleted struct {
+		ID            CatalogID
+		Advertisement cid.Cid
+	}
+
+	// Subscription receives the events emitted by Herald from when it is
+	// subscribed until it is closed. See Subscribe.
+	Subscription struct {
+		bus    *eventBus
+		events chan Event
+	}
+	// eventBus delivers emitted events to subscriptions. Its zero value has
+	// no subscriptions.
+	eventBus struct {
+		mu     sync.RWMutex
+		subs   map[*Subscription]struct{}
+		closed bool
+		logger *zap.SugaredLogger
+	}
+)
+
+func (PublishStarted) isEvent()    {}
+func (ChunksGenerated) isEvent()   {}
+func (AdStored) isEvent()          {}
+func (HeadUpdated) isEvent()       {}
+func (AnnounceSucceeded) isEvent() {}
+func (AnnounceFailed) isEvent()    {}
+func (RetractCompleted) isEvent()  {}
+
+// Subscribe returns a subscription to the events emitted by Herald, buffering
+// up to the given number of events. Events are emitted as they happen, e.g.
+// while publishes hold locks, and are dropped rather than waited for when the
+// buffer is full; subscribers should therefore receive them promptly. The
+// events channel is closed when the subscription is closed, or once Herald is
+// shut down.
+func (h *Herald) Subscribe(buffer int) *Subscription {
+	if buffer < 0 {
+		buffer = 0
+	}
+	return h.events.subscribe(buffer)
+}
+
+// Events returns the channel on which events are received.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops receiving events and closes the events channel. It is safe to
+// call more than once.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+func (b *eventBus) subscribe(buffer int) *Subscription {
+	s := &Subscription{bus: b, events: make(chan Event, buffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.events)
+		return s
+	}
+	if b.subs == nil {
+		b.subs = make(map[*Subscription]struct{})
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.events)
+	}
+}
+
+// emit delivers the given event to every subscription whose buffer is not
+// full.
+func (b *eventBus) emit(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		select {
+		case s.events <- e:
+		default:
+			b.logger.Debugw("dropped event of subscription with full buffer", "event", e)
+		}
+	}
+}
+
+// close closes every subscription, and those subscribed afterwards.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		close(s.events)
+	}
+	b.subs, b.closed = nil, true
+}
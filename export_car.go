@@ -0,0 +1,73 @@
+package herald
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+// GetEntriesCAR returns a CARv1 stream containing the entry chunks of the
+// latest advertisement published for the given catalog ID, ordered from the
+// root chunk to the tail, or the nodes of its HAMT. The root block of the
+// entries is the only root of the CAR.
+//
+// The entries of a catalog published by PublishDiff only hold the multihashes
+// added by its latest diff, not every multihash advertised for it.
+//
+// ErrCatalogNotFound is returned if the catalog has not been published, has
+// been retracted, or has no entries.
+func (h *Herald) GetEntriesCAR(ctx context.Context, id CatalogID) (io.ReadCloser, error) {
+	p := h.publisher.dsPublisher
+	_, ad, err := p.latestAdvertisement(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !hasEntries(ad.Entries) {
+		return nil, ErrCatalogNotFound
+	}
+	root := ad.Entries.(cidlink.Link).Cid
+	r, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(p.writeEntriesCAR(ctx, root, w))
+	}()
+	return r, nil
+}
+
+func (l *dsPublisher) writeEntriesCAR(ctx context.Context, root cid.Cid, w io.Writer) error {
+	car, err := storage.NewWritable(w, []cid.Cid{root}, carv2.WriteAsCarV1(true), carv2.AllowDuplicatePuts(true))
+	if err != nil {
+		return err
+	}
+	// Blocks are exported breadth first, which for entry chunk lists is from
+	// the root chunk to the tail.
+	var count int
+	seen := map[cid.Cid]struct{}{root: {}}
+	for queue := []cid.Cid{root}; len(queue) != 0; queue = queue[1:] {
+		next := queue[0]
+		data, err := l.entriesDs.Get(ctx, dsKey(cidlink.Link{Cid: next}))
+		if err != nil {
+			l.h.publisherLogger.Errorw("failed to get entry chunk while exporting CAR", "root", root, "chunk", next, "err", err)
+			return err
+		}
+		if err := car.Put(ctx, next.KeyString(), data); err != nil {
+			return err
+		}
+		count++
+		links, err := blockLinks(next, data)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			if _, ok := seen[link]; !ok {
+				seen[link] = struct{}{}
+				queue = append(queue, link)
+			}
+		}
+	}
+	l.h.publisherLogger.Debugw("exported entries as CAR", "root", root, "chunkCount", count)
+	return car.Finalize()
+}
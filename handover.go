@@ -0,0 +1,127 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+var (
+	// ErrReadOnly signals that the publisher has handed over its chain to
+	// another instance and no longer accepts publishes.
+	ErrReadOnly = errors.New("publisher is read-only")
+	// ErrHeadDiverged signals that the local head is not part of the chain
+	// being taken over, and therefore taking over would lose advertisements.
+	ErrHeadDiverged = errors.New("head has diverged from source chain")
+)
+
+type (
+	// ChainSource provides the head and blocks of an advertisement chain to
+	// take over, such as another Herald instance.
+	ChainSource interface {
+		GetHead(context.Context) (cid.Cid, error)
+		GetContent(context.Context, cid.Cid) (io.ReadCloser, error)
+	}
+)
+
+// BeginHandover switches Herald into read-only mode, in which publishes and
+// retractions fail with ErrReadOnly, and returns the final head. Content and
+// head continue to be served until CompleteHandover is called, so that the
+// instance taking over can import the chain from this one.
+//
+// A zero-downtime handover from an old instance to a new one proceeds as
+// follows:
+//  1. the old instance calls BeginHandover;
+//  2. the new instance, configured with the same identity, calls TakeOver
+//     with the old instance as source;
+//  3. the old instance calls CompleteHandover with the URL of the new
+//     instance, after which its HTTP publisher redirects every request.
+func (h *Herald) BeginHandover(ctx context.Context) (cid.Cid, error) {
+	p := h.publisher.dsPublisher
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.readOnly = true
+	head, err := p.GetHead(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	h.logger.Infow("Began handover; publisher is now read-only", "head", head)
+	return head, nil
+}
+
+// CompleteHandover makes the HTTP publisher redirect every request to the
+// given base URL of the instance that took over the chain. See BeginHandover.
+func (h *Herald) CompleteHandover(redirect *url.URL) error {
+	p := h.publisher.dsPublisher
+	p.locker.RLock()
+	readOnly := p.readOnly
+	p.locker.RUnlock()
+	if !readOnly {
+		return errors.New("handover has not begun")
+	}
+	h.publisher.redirect.Store(redirect)
+	h.logger.Infow("Completed handover; redirecting HTTP publisher requests", "redirect", redirect)
+	return nil
+}
+
+// TakeOver imports the advertisement chain from the given source, verifies
+// it, and sets the head to that of the source. Every advertisement must be
+// signed by the identity of this instance. The chain is walked from the
+// source head backwards until the local head is reached; the take over fails
+// with ErrHeadDiverged if the local head is not part of the source chain.
+// Publishes are blocked while the chain is imported, and are accepted again
+// afterwards even if this instance previously handed over its chain.
+func (h *Herald) TakeOver(ctx context.Context, src ChainSource) (cid.Cid, error) {
+	p := h.publisher.dsPublisher
+	p.gcLocker.RLock()
+	defer p.gcLocker.RUnlock()
+	p.locker.Lock()
+	defer p.locker.Unlock()
+
+	local, err := p.GetHead(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	head, err := src.GetHead(ctx)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to get source head: %w", err)
+	}
+	imp := newChainImporter(p, func(ctx context.Context, c cid.Cid) ([]byte, error) {
+		r, err := src.GetContent(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	})
+	imp.stopAt = local
+	imp.verify = func(c cid.Cid, ad *schema.Advertisement) error {
+		signer, err := ad.VerifySignature()
+		if err != nil {
+			return fmt.Errorf("invalid signature on advertisement %s: %w", c, err)
+		}
+		if signer != h.id {
+			return fmt.Errorf("advertisement %s is signed by %s instead of %s", c, signer, h.id)
+		}
+		return nil
+	}
+	reached, err := imp.importChain(ctx, head)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if local.Defined() && !reached {
+		return cid.Undef, fmt.Errorf("%w: local head %s", ErrHeadDiverged, local)
+	}
+	if err := p.setHead(ctx, head); err != nil {
+		return cid.Undef, err
+	}
+	p.readOnly = false
+	h.publisher.redirect.Store(nil)
+	h.logger.Infow("Took over advertisement chain", "head", head, "previousHead", local, "ads", imp.ads, "chunks", imp.chunks)
+	return head, nil
+}
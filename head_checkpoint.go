@@ -0,0 +1,96 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+)
+
+// maxHeadCheckpoints is the number of most recent heads kept as checkpoints.
+const maxHeadCheckpoints = 8
+
+// headCheckpointsKey is the key of the most recent heads, from the newest,
+// as concatenated CIDs. The stored head is rolled back to the newest of them
+// that resolves if it does not, e.g. when its advertisement was lost in a
+// crash before it was made durable.
+var headCheckpointsKey = datastore.NewKey("head-checkpoints")
+
+// putHead writes the given head along with the updated checkpoints.
+func (l *dsPublisher) putHead(ctx context.Context, w datastore.Write, head cid.Cid) error {
+	checkpoints, err := l.getHeadCheckpoints(ctx)
+	if err != nil {
+		return err
+	}
+	checkpoints = append([]cid.Cid{head}, checkpoints...)
+	if len(checkpoints) > maxHeadCheckpoints {
+		checkpoints = checkpoints[:maxHeadCheckpoints]
+	}
+	if err := w.Put(ctx, headCheckpointsKey, encodeHeadCheckpoints(checkpoints)); err != nil {
+		return err
+	}
+	return w.Put(ctx, headKey, head.Bytes())
+}
+
+func (l *dsPublisher) getHeadCheckpoints(ctx context.Context) ([]cid.Cid, error) {
+	value, err := l.h.ds.Get(ctx, headCheckpointsKey)
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var checkpoints []cid.Cid
+	for len(value) != 0 {
+		n, c, err := cid.CidFromBytes(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid head checkpoints: %w", err)
+		}
+		checkpoints, value = append(checkpoints, c), value[n:]
+	}
+	return checkpoints, nil
+}
+
+func encodeHeadCheckpoints(checkpoints []cid.Cid) []byte {
+	var value []byte
+	for _, c := range checkpoints {
+		value = append(value, c.Bytes()...)
+	}
+	return value
+}
+
+// recoverHead verifies that the stored head resolves to an advertisement, and
+// otherwise rolls it back to the most recent checkpoint that does. It fails
+// if no checkpoint resolves, since publishing would then fork the chain.
+func (h *Herald) recoverHead(ctx context.Context) error {
+	l := h.publisher.dsPublisher
+	head, err := l.GetHead(ctx)
+	if err != nil || !head.Defined() {
+		return err
+	}
+	if _, err := l.loadAdvertisement(ctx, head); err == nil {
+		return nil
+	} else if !errors.Is(err, datastore.ErrNotFound) {
+		h.datastoreLogger.Errorw("stored head does not resolve", "head", head, "err", err)
+	}
+	checkpoints, err := l.getHeadCheckpoints(ctx)
+	if err != nil {
+		return err
+	}
+	for i, c := range checkpoints {
+		if c.Equals(head) {
+			continue
+		}
+		if _, err := l.loadAdvertisement(ctx, c); err != nil {
+			continue
+		}
+		h.datastoreLogger.Warnw("stored head does not resolve; rolling back to the latest checkpoint that does", "head", head, "checkpoint", c)
+		if err := h.ds.Put(ctx, headCheckpointsKey, encodeHeadCheckpoints(checkpoints[i:])); err != nil {
+			return err
+		}
+		return h.ds.Put(ctx, headKey, c.Bytes())
+	}
+	return fmt.Errorf("stored head %s does not resolve to an advertisement, nor does any checkpoint", head)
+}
@@ -4,20 +4,46 @@ import (
 	"context"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-log/v2"
+	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	logger = log.Logger("herald")
+	_ Publisher = (*Herald)(nil)
 
 	ErrCatalogIteratorDone = errors.New("no more items")
+
+	// ErrAdTooLarge, ErrEntryChunkTooLarge and ErrEntriesTooDeep signal that a
+	// publish would produce blocks exceeding the limits imposed by indexers.
+	// See WithMaxAdSize, WithMaxEntryChunkSize and WithMaxEntriesDepth.
+	ErrAdTooLarge         = errors.New("advertisement is too large")
+	ErrEntryChunkTooLarge = errors.New("entry chunk is too large")
+	ErrEntriesTooDeep     = errors.New("entries chain is too deep")
+	// ErrInvalidAdvertisement signals that a generated advertisement violates
+	// the constraints of the advertisement schema, such as the maximum context
+	// ID or metadata length.
+	ErrInvalidAdvertisement = errors.New("invalid advertisement")
+	// ErrHeadMoved signals that the head is not the one expected by a publish.
+	// See WithExpectedHead.
+	ErrHeadMoved = errors.New("head has moved")
+	// ErrClosed signals that Herald is shut down and no longer accepts
+	// publishes.
+	ErrClosed = errors.New("herald is closed")
 )
 
 type (
-	CatalogID       []byte
+	CatalogID []byte
+	// CatalogIterator iterates over the multihashes of a catalog. Herald does
+	// not modify the multihashes returned by Next, and does not retain them
+	// beyond the next call to Next, such that iterators may reuse the memory
+	// of the multihashes they return. Herald copies the multihashes it needs
+	// to keep, e.g. until their entry chunk is stored.
 	CatalogIterator interface {
 		Next() (multihash.Multihash, error)
 		Done() bool
@@ -34,16 +60,41 @@ type (
 		Retract(context.Context, CatalogID) (cid.Cid, error)
 		GetContent(context.Context, cid.Cid) (io.ReadCloser, error)
 		GetHead(context.Context) (cid.Cid, error)
+		UpdateAddresses(context.Context, []multiaddr.Multiaddr) (cid.Cid, error)
 		// TODO:
-		//  - Update address
-		//  - AddProvider
-		//  - RemoveProvider
-		//  - UpdateProvider
 		//  - Transport et. al.
 	}
 	Herald struct {
 		*options
 		publisher *httpPublisher
+		// p2pPublisher is set when the chain is published over libp2p.
+		p2pPublisher *libp2pPublisher
+		// blobPublishers write the chain to blob stores, if any.
+		blobPublishers []*blobPublisher
+		limiter        *publishLimiter
+		metrics        *metrics
+		tracer         trace.Tracer
+		announcers     []Announcer
+		// headAnnouncer is set when there are announcers, which head
+		// updates are announced to in the background.
+		headAnnouncer *headAnnouncer
+		// instrumented holds the instrumented datastores, if any.
+		instrumented []*instrumentedDatastore
+		// queue is set when publishes are queued. See WithPublishQueue.
+		queue *publishQueue
+		// admin is set when the admin API is served. See WithAdminServer.
+		admin *adminServer
+		// batcher is set when publishes are coalesced. See
+		// WithPublishInterval.
+		batcher *publishBatcher
+		// providers are the providers registered in addition to the
+		// default one. See AddProvider.
+		providers providerRegistry
+		// reannouncer is set when the head is periodically re-announced.
+		// See WithReannounceInterval.
+		reannouncer *reannouncer
+		// events delivers emitted events to subscriptions. See Subscribe.
+		events eventBus
 	}
 )
 
@@ -52,7 +103,55 @@ func New(o ...Option) (*Herald, error) {
 	if err != nil {
 		return nil, err
 	}
-	h := &Herald{options: opts}
+	for subsystem, level := range opts.logLevels {
+		if err := log.SetLogLevel(subsystemLoggers[subsystem], level); err != nil {
+			return nil, err
+		}
+	}
+	var instrumented []*instrumentedDatastore
+	if opts.instrumentDatastore {
+		var ids *instrumentedDatastore
+		opts.ds, ids = newInstrumentedDatastore("main", opts.ds)
+		instrumented = append(instrumented, ids)
+		if opts.entriesDs != nil {
+			opts.entriesDs, ids = newInstrumentedDatastore("entries", opts.entriesDs)
+			instrumented = append(instrumented, ids)
+		}
+	}
+	if err := migrateDatastore(context.Background(), opts.ds, opts.datastoreLogger); err != nil {
+		return nil, err
+	}
+	if opts.entriesDs != nil {
+		if err := migrateDatastore(context.Background(), opts.entriesDs, opts.datastoreLogger); err != nil {
+			return nil, err
+		}
+	}
+	h := &Herald{
+		options:      opts,
+		limiter:      newPublishLimiter(opts.maxPendingPublishes, opts.blockWhenBusy, opts.publisherLogger),
+		instrumented: instrumented,
+		events:       eventBus{logger: opts.logger},
+	}
+	for _, p := range opts.providers {
+		rp, err := opts.newRegisteredProvider(p)
+		if err != nil {
+			return nil, err
+		}
+		h.providers.put(p.ID, rp)
+	}
+	h.tracer = opts.tracerProvider.Tracer(tracerName)
+	if opts.metricsRegisterer != nil {
+		if h.metrics, err = newMetrics(opts.metricsRegisterer); err != nil {
+			return nil, err
+		}
+	}
+	if h.announcers, err = newAnnouncers(h); err != nil {
+		return nil, err
+	}
+	if len(h.announcers) != 0 {
+		h.headAnnouncer = &headAnnouncer{}
+		h.headAnnouncer.start(context.Background(), h)
+	}
 	dspub, err := newDsPublisher(h)
 	if err != nil {
 		return nil, err
@@ -61,13 +160,287 @@ func New(o ...Option) (*Herald, error) {
 	if err != nil {
 		return nil, err
 	}
+	if opts.localPublisherDir != "" {
+		store := &dirBlobStore{dir: opts.localPublisherDir, sync: opts.syncBeforeHead}
+		h.blobPublishers = append(h.blobPublishers, newBlobPublisher(h, dspub, store))
+	}
+	if opts.blobStore != nil {
+		h.blobPublishers = append(h.blobPublishers, newBlobPublisher(h, dspub, opts.blobStore))
+	}
+	if opts.libp2pHost != nil {
+		h.p2pPublisher = newLibp2pPublisher(opts.libp2pHost, h.publisher.server.Handler, opts.httpLogger)
+	}
+	if h.badbits != nil {
+		h.badbits.h = h
+	}
+	if opts.publishQueue {
+		if h.queue, err = newPublishQueue(h, opts.publishQueueDs); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.recoverHead(context.Background()); err != nil {
+		return nil, err
+	}
+	if err := h.recoverInterruptedPublishes(context.Background()); err != nil {
+		return nil, err
+	}
+	if h.queue != nil {
+		h.queue.start()
+	}
+	if h.adminListenAddr != "" {
+		h.admin = newAdminServer(h)
+	}
+	if h.publishInterval > 0 {
+		h.batcher = newPublishBatcher(h, h.publishInterval)
+	}
 	return h, err
 }
 
 func (h *Herald) Start(ctx context.Context) error {
-	return h.publisher.Start(ctx)
+	if h.httpTransport {
+		if err := h.publisher.Start(ctx); err != nil {
+			return err
+		}
+	}
+	if h.p2pPublisher != nil {
+		if err := h.p2pPublisher.Start(ctx); err != nil {
+			return err
+		}
+	}
+	for _, p := range h.blobPublishers {
+		if err := p.Start(ctx); err != nil {
+			return err
+		}
+	}
+	if h.admin != nil {
+		if err := h.admin.Start(ctx); err != nil {
+			return err
+		}
+	}
+	if h.badbits != nil {
+		h.badbits.start(context.Background())
+	}
+	if h.maintenance != nil && h.maintenance.interval > 0 {
+		h.maintenance.start(context.Background(), h)
+	}
+	if h.announceOnStart {
+		// Start succeeds regardless; indexers catch up on the next
+		// announcement.
+		_ = h.Announce(ctx)
+	}
+	if h.reannounceInterval > 0 {
+		h.reannouncer = &reannouncer{interval: h.reannounceInterval}
+		h.reannouncer.start(context.Background(), h)
+	}
+	return nil
 }
 
+// Shutdown stops Herald such that it can be resumed cleanly from its
+// datastores: publishes are no longer accepted and fail with ErrClosed,
+// in-flight publishes are waited for, the publisher stops serving, the latest
+// head update is announced and announcers are closed, and the datastores are
+// synced. Waiting stops once the context is done, in which case the remaining
+// steps are still carried out. Errors of every step are returned joined.
 func (h *Herald) Shutdown(ctx context.Context) error {
-	return h.publisher.Shutdown(ctx)
+	if h.badbits != nil {
+		h.badbits.stop()
+	}
+	if h.maintenance != nil {
+		h.maintenance.stop()
+	}
+	if h.reannouncer != nil {
+		h.reannouncer.stop()
+	}
+	var errs []error
+	if h.admin != nil {
+		if err := h.admin.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if h.batcher != nil {
+		if err := h.batcher.stop(ctx); err != nil {
+			h.logger.Errorw("failed to complete coalesced publishes on shutdown", "err", err)
+			errs = append(errs, err)
+		}
+	}
+	if h.queue != nil {
+		if err := h.queue.stop(ctx); err != nil {
+			h.logger.Errorw("failed to complete queued publish on shutdown", "err", err)
+			errs = append(errs, err)
+		}
+	}
+	if err := h.publisher.dsPublisher.close(ctx); err != nil {
+		h.logger.Errorw("failed to drain publishes on shutdown", "err", err)
+		errs = append(errs, err)
+	}
+	if err := h.publisher.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if h.p2pPublisher != nil {
+		if err := h.p2pPublisher.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if h.headAnnouncer != nil {
+		if err := h.headAnnouncer.stop(ctx); err != nil {
+			h.logger.Errorw("failed to announce head on shutdown", "err", err)
+			errs = append(errs, err)
+		}
+	}
+	if err := h.closeAnnouncers(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, ds := range append(h.datastores(), h.recorderDs) {
+		if ds == nil {
+			continue
+		}
+		if err := ds.Sync(ctx, datastore.NewKey("/")); err != nil {
+			h.logger.Errorw("failed to sync datastore on shutdown", "err", err)
+			errs = append(errs, err)
+		}
+	}
+	h.events.close()
+	return errors.Join(errs...)
+}
+
+func (h *Herald) Publish(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	if h.batcher != nil {
+		return h.batcher.publish(ctx, catalog)
+	}
+	return h.publish(ctx, catalog)
+}
+
+func (h *Herald) publish(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	if h.queue != nil {
+		receipt, err := h.publishQueued(ctx, catalog, catalog.ID(), false, &publishOptions{})
+		if err != nil {
+			return cid.Undef, err
+		}
+		return receipt.Advertisement, nil
+	}
+	if err := h.limiter.acquire(ctx); err != nil {
+		return cid.Undef, err
+	}
+	defer h.limiter.release()
+	return h.publisher.Publish(ctx, catalog)
+}
+
+// PublishWithOptions publishes the given catalog with the given options, and
+// returns a receipt describing the published advertisement.
+func (h *Herald) PublishWithOptions(ctx context.Context, catalog Catalog, o ...PublishOption) (*PublishReceipt, error) {
+	opts, err := h.newPublishOptions(o...)
+	if err != nil {
+		return nil, err
+	}
+	if h.queue != nil {
+		return h.publishQueued(ctx, catalog, catalog.ID(), false, opts)
+	}
+	if err := h.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer h.limiter.release()
+	return h.publisher.PublishWithOptions(ctx, catalog, opts)
+}
+
+// PublishAppend publishes an advertisement for the catalog whose entries
+// consist of the multihashes in the given catalog followed by the entries of
+// the latest advertisement previously published for the same catalog ID. Only
+// the given multihashes are chunked; the existing entry chunks are linked to
+// as is. If the catalog has not been published before, or has been retracted,
+// PublishAppend behaves like Publish.
+//
+// Concurrent appends to the same catalog must be serialized by the caller.
+func (h *Herald) PublishAppend(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	if h.queue != nil {
+		receipt, err := h.publishQueued(ctx, catalog, catalog.ID(), false, &publishOptions{appendEntries: true})
+		if err != nil {
+			return cid.Undef, err
+		}
+		return receipt.Advertisement, nil
+	}
+	if err := h.limiter.acquire(ctx); err != nil {
+		return cid.Undef, err
+	}
+	defer h.limiter.release()
+	return h.publisher.PublishAppend(ctx, catalog)
+}
+
+func (h *Herald) Retract(ctx context.Context, id CatalogID) (cid.Cid, error) {
+	if h.queue != nil {
+		receipt, err := h.publishQueued(ctx, nil, id, true, &publishOptions{})
+		if err != nil {
+			return cid.Undef, err
+		}
+		return receipt.Advertisement, nil
+	}
+	if err := h.limiter.acquire(ctx); err != nil {
+		return cid.Undef, err
+	}
+	defer h.limiter.release()
+	return h.retract(ctx, id, &publishOptions{})
+}
+
+func (h *Herald) retract(ctx context.Context, id CatalogID, opts *publishOptions) (cid.Cid, error) {
+	head, err := h.publisher.dsPublisher.retract(ctx, id, opts)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if h.retractedEntriesRetention == 0 {
+		// The retraction succeeded regardless; entries left behind are
+		// deleted by the next collection.
+		if err := h.publisher.dsPublisher.collectRetractedEntries(ctx, time.Now().Add(time.Nanosecond)); err != nil {
+			h.logger.Errorw("failed to delete entries of retracted catalog", "id", id, "err", err)
+		}
+	}
+	return head, nil
+}
+
+func (h *Herald) GetContent(ctx context.Context, id cid.Cid) (io.ReadCloser, error) {
+	return h.publisher.GetContent(ctx, id)
+}
+
+// GetContentByMultihash returns the content of the block with the given
+// multihash, regardless of the codec of the CID used to refer to it.
+func (h *Herald) GetContentByMultihash(ctx context.Context, mh multihash.Multihash) (io.ReadCloser, error) {
+	return h.publisher.dsPublisher.GetContentByMultihash(ctx, mh)
+}
+
+func (h *Herald) GetHead(ctx context.Context) (cid.Cid, error) {
+	return h.publisher.GetHead(ctx)
+}
+
+// RetractAll publishes a removal advertisement for every catalog of the
+// default provider that is currently advertised, and returns the resulting
+// head, e.g. to decommission the provider. Catalogs published for providers
+// registered via WithProvider or AddProvider are left advertised. IPNI has no
+// advertisement removing all the content of a provider at once, so each
+// catalog is retracted in turn, from the most recently published. The head is
+// returned unchanged if there is nothing to retract.
+func (h *Herald) RetractAll(ctx context.Context) (cid.Cid, error) {
+	statuses, err := h.publisher.dsPublisher.liveCatalogs(ctx, h.providerID)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(statuses) == 0 {
+		return h.GetHead(ctx)
+	}
+	var head cid.Cid
+	for _, status := range statuses {
+		if head, err = h.Retract(ctx, status.ID); err != nil {
+			h.logger.Errorw("failed to retract catalog", "id", status.ID, "err", err)
+			return cid.Undef, err
+		}
+	}
+	h.logger.Infow("Retracted all catalogs", "provider", h.providerID, "count", len(statuses), "head", head)
+	return head, nil
+}
+
+// DatastoreStats returns the statistics of every kind of operation performed
+// on the datastores, or nil unless WithDatastoreInstrumentation is set.
+func (h *Herald) DatastoreStats() []DatastoreOpStats {
+	var stats []DatastoreOpStats
+	for _, ids := range h.instrumented {
+		stats = append(stats, ids.stats()...)
+	}
+	return stats
 }
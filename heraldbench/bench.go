@@ -0,0 +1,41 @@
+package heraldbench
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipni/herald"
+)
+
+// Benchmark runs the workload b.N times and reports throughput, allocations
+// and datastore operations per run as custom benchmark metrics.
+func Benchmark(b *testing.B, w Workload, o ...herald.Option) {
+	b.Helper()
+	benchmark(b, w, o...)
+}
+
+func benchmark(b *testing.B, w Workload, o ...herald.Option) Result {
+	b.Helper()
+	var total Result
+	for i := 0; i < b.N; i++ {
+		r, err := Run(context.Background(), w, o...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total.Duration += r.Duration
+		total.Multihashes += r.Multihashes
+		total.Allocs += r.Allocs
+		total.AllocBytes += r.AllocBytes
+		total.Datastore.Puts += r.Datastore.Puts
+		total.Datastore.PutBytes += r.Datastore.PutBytes
+		total.Datastore.Gets += r.Datastore.Gets
+	}
+	n := float64(b.N)
+	b.ReportMetric(total.MultihashesPerSecond(), "mh/s")
+	b.ReportMetric(float64(total.Allocs)/n, "publish-allocs/op")
+	b.ReportMetric(float64(total.AllocBytes)/n, "publish-B/op")
+	b.ReportMetric(float64(total.Datastore.Puts)/n, "ds-puts/op")
+	b.ReportMetric(float64(total.Datastore.PutBytes)/n, "ds-put-B/op")
+	b.ReportMetric(float64(total.Datastore.Gets)/n, "ds-gets/op")
+	return total
+}
@@ -0,0 +1,107 @@
+package heraldbench
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/ipfs/go-datastore"
+)
+
+var (
+	_ datastore.Batching = (*countingDatastore)(nil)
+	_ datastore.Batch    = (*countingBatch)(nil)
+)
+
+type (
+	// DatastoreOps counts the datastore operations performed during a run.
+	DatastoreOps struct {
+		Gets     int64
+		Has      int64
+		Puts     int64
+		PutBytes int64
+		Deletes  int64
+		Queries  int64
+		Commits  int64
+	}
+	countingDatastore struct {
+		datastore.Batching
+		gets, has, puts, putBytes, deletes, queries, commits atomic.Int64
+	}
+	countingBatch struct {
+		datastore.Batch
+		ds *countingDatastore
+	}
+)
+
+func (o DatastoreOps) String() string {
+	return fmt.Sprintf("gets=%d has=%d puts=%d putBytes=%d deletes=%d queries=%d commits=%d",
+		o.Gets, o.Has, o.Puts, o.PutBytes, o.Deletes, o.Queries, o.Commits)
+}
+
+func newCountingDatastore(ds datastore.Batching) *countingDatastore {
+	return &countingDatastore{Batching: ds}
+}
+
+func (c *countingDatastore) reset() {
+	for _, v := range []*atomic.Int64{&c.gets, &c.has, &c.puts, &c.putBytes, &c.deletes, &c.queries, &c.commits} {
+		v.Store(0)
+	}
+}
+
+func (c *countingDatastore) ops() DatastoreOps {
+	return DatastoreOps{
+		Gets:     c.gets.Load(),
+		Has:      c.has.Load(),
+		Puts:     c.puts.Load(),
+		PutBytes: c.putBytes.Load(),
+		Deletes:  c.deletes.Load(),
+		Queries:  c.queries.Load(),
+		Commits:  c.commits.Load(),
+	}
+}
+
+func (c *countingDatastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	c.gets.Add(1)
+	return c.Batching.Get(ctx, key)
+}
+
+func (c *countingDatastore) Has(ctx context.Context, key datastore.Key) (bool, error) {
+	c.has.Add(1)
+	return c.Batching.Has(ctx, key)
+}
+
+func (c *countingDatastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	c.puts.Add(1)
+	c.putBytes.Add(int64(len(value)))
+	return c.Batching.Put(ctx, key, value)
+}
+
+func (c *countingDatastore) Delete(ctx context.Context, key datastore.Key) error {
+	c.deletes.Add(1)
+	return c.Batching.Delete(ctx, key)
+}
+
+func (c *countingDatastore) Batch(ctx context.Context) (datastore.Batch, error) {
+	b, err := c.Batching.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &countingBatch{Batch: b, ds: c}, nil
+}
+
+func (b *countingBatch) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	b.ds.puts.Add(1)
+	b.ds.putBytes.Add(int64(len(value)))
+	return b.Batch.Put(ctx, key, value)
+}
+
+func (b *countingBatch) Delete(ctx context.Context, key datastore.Key) error {
+	b.ds.deletes.Add(1)
+	return b.Batch.Delete(ctx, key)
+}
+
+func (b *countingBatch) Commit(ctx context.Context) error {
+	b.ds.commits.Add(1)
+	return b.Batch.Commit(ctx)
+}
@@ -0,0 +1,67 @@
+package heraldbench
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ipni/herald"
+)
+
+var (
+	// EntriesCatalogSizes and EntriesChunkSizes are the numbers of
+	// multihashes per catalog and per entry chunk benchmarked by
+	// BenchmarkEntries.
+	EntriesCatalogSizes = []int{1_000, 100_000}
+	EntriesChunkSizes   = []int{256, 4 << 10, 16 << 10}
+
+	// EntriesBudget is the budget of entry generation with the default
+	// chunker. Multihashes are generated into a reused buffer, so that it
+	// accounts for Herald alone.
+	EntriesBudget = Budget{MaxAllocsPerMultihash: 1, MaxAllocBytesPerMultihash: 512}
+)
+
+// Budget bounds the cost of publishing per multihash, beyond which benchmarks
+// fail. Zero fields are not checked.
+type Budget struct {
+	MaxAllocsPerMultihash     float64
+	MaxAllocBytesPerMultihash float64
+}
+
+// BenchmarkEntries benchmarks the generation of entries of a single catalog
+// across EntriesCatalogSizes and EntriesChunkSizes, as sub-benchmarks named
+// after both, and fails those that exceed the given budget. Options are
+// applied to every Herald instance, e.g. to benchmark another chunker:
+//
+//	func BenchmarkEntries(b *testing.B) {
+//		heraldbench.BenchmarkEntries(b, heraldbench.EntriesBudget)
+//	}
+func BenchmarkEntries(b *testing.B, budget Budget, o ...herald.Option) {
+	for _, mhs := range EntriesCatalogSizes {
+		for _, chunkSize := range EntriesChunkSizes {
+			w := Workload{Catalogs: 1, MultihashesPerCatalog: mhs, EntriesChunkSize: chunkSize}
+			b.Run(fmt.Sprintf("mhs=%d/chunk=%d", mhs, chunkSize), func(b *testing.B) {
+				b.Helper()
+				total := benchmark(b, w, o...)
+				budget.check(b, total)
+			})
+		}
+	}
+}
+
+// check fails the benchmark if the given total measurements exceed the
+// budget.
+func (budget Budget) check(b *testing.B, total Result) {
+	b.Helper()
+	if total.Multihashes == 0 {
+		return
+	}
+	mhs := float64(total.Multihashes)
+	b.ReportMetric(float64(total.Allocs)/mhs, "allocs/mh")
+	b.ReportMetric(float64(total.AllocBytes)/mhs, "B/mh")
+	if allocs := float64(total.Allocs) / mhs; budget.MaxAllocsPerMultihash > 0 && allocs > budget.MaxAllocsPerMultihash {
+		b.Errorf("%.2f allocations per multihash exceed the budget of %.2f", allocs, budget.MaxAllocsPerMultihash)
+	}
+	if bytes := float64(total.AllocBytes) / mhs; budget.MaxAllocBytesPerMultihash > 0 && bytes > budget.MaxAllocBytesPerMultihash {
+		b.Errorf("%.0f bytes allocated per multihash exceed the budget of %.0f", bytes, budget.MaxAllocBytesPerMultihash)
+	}
+}
@@ -0,0 +1,11 @@
+package heraldbench_test
+
+import (
+	"testing"
+
+	"github.com/ipni/herald/heraldbench"
+)
+
+func BenchmarkEntries(b *testing.B) {
+	heraldbench.BenchmarkEntries(b, heraldbench.EntriesBudget)
+}
@@ -0,0 +1,208 @@
+// Package heraldbench generates synthetic catalogs of configurable size and
+// shape, and measures the throughput, allocations and datastore operations of
+// publishing them with Herald.
+package heraldbench
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/herald"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ herald.Catalog = (*catalog)(nil)
+
+type (
+	// Workload describes the shape of the catalogs published during a run.
+	Workload struct {
+		// Catalogs is the number of catalogs published.
+		Catalogs int
+		// MultihashesPerCatalog is the number of multihashes in each catalog.
+		MultihashesPerCatalog int
+		// DigestSizes are the digest sizes, in bytes, of generated multihashes.
+		// Sizes are used in round-robin order. Defaults to 32.
+		DigestSizes []int
+		// MultihashCode is the multihash code of generated multihashes. Digests
+		// are random and are not computed using the hash function. Defaults to
+		// SHA2-256.
+		MultihashCode uint64
+		// EntriesChunkSize is the number of multihashes per entry chunk. Zero
+		// uses Herald's default.
+		EntriesChunkSize int
+		// Seed seeds the random generation of digests.
+		Seed int64
+		// Datastore backs the Herald instance. Defaults to an in-memory
+		// datastore.
+		Datastore datastore.Batching
+	}
+	// Result captures the measurements of a workload run.
+	Result struct {
+		Duration    time.Duration
+		Ads         int
+		Multihashes int
+		Allocs      uint64
+		AllocBytes  uint64
+		Datastore   DatastoreOps
+	}
+	catalog struct {
+		id    []byte
+		count int
+		sizes []int
+		code  uint64
+		seed  int64
+	}
+	catalogIterator struct {
+		*catalog
+		rng  *rand.Rand
+		next int
+		mh   multihash.Multihash
+	}
+)
+
+// MultihashesPerSecond returns the publish throughput of the run.
+func (r Result) MultihashesPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Multihashes) / r.Duration.Seconds()
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("ads=%d mhs=%d duration=%s mh/s=%.0f allocs=%d allocBytes=%d ds=[%s]",
+		r.Ads, r.Multihashes, r.Duration, r.MultihashesPerSecond(), r.Allocs, r.AllocBytes, r.Datastore)
+}
+
+func (w Workload) withDefaults() (Workload, error) {
+	if w.Catalogs <= 0 {
+		return w, errors.New("at least one catalog must be published")
+	}
+	if w.MultihashesPerCatalog <= 0 {
+		return w, errors.New("catalogs must have at least one multihash")
+	}
+	if len(w.DigestSizes) == 0 {
+		w.DigestSizes = []int{32}
+	}
+	for _, size := range w.DigestSizes {
+		if size <= 0 {
+			return w, fmt.Errorf("invalid digest size: %d", size)
+		}
+	}
+	if w.MultihashCode == 0 {
+		w.MultihashCode = multihash.SHA2_256
+	}
+	if w.Datastore == nil {
+		w.Datastore = sync.MutexWrap(datastore.NewMapDatastore())
+	}
+	return w, nil
+}
+
+// Run publishes the catalogs described by the workload using a new Herald
+// instance and returns the measurements. Options are applied after the
+// defaults set by the workload.
+func Run(ctx context.Context, w Workload, o ...herald.Option) (*Result, error) {
+	w, err := w.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	ds := newCountingDatastore(w.Datastore)
+	opts := []herald.Option{
+		herald.WithDatastore(ds),
+		herald.WithProviderAddress(multiaddr.StringCast("/ip4/127.0.0.1/tcp/40080/http")),
+		herald.WithPermissiveValidation(true),
+		herald.WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+	}
+	if w.EntriesChunkSize > 0 {
+		opts = append(opts, herald.WithAdEntriesChunkSize(w.EntriesChunkSize))
+	}
+	h, err := herald.New(append(opts, o...)...)
+	if err != nil {
+		return nil, err
+	}
+	catalogs := w.catalogs()
+	ds.reset()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for _, c := range catalogs {
+		if _, err := h.Publish(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return &Result{
+		Duration:    elapsed,
+		Ads:         len(catalogs),
+		Multihashes: len(catalogs) * w.MultihashesPerCatalog,
+		Allocs:      after.Mallocs - before.Mallocs,
+		AllocBytes:  after.TotalAlloc - before.TotalAlloc,
+		Datastore:   ds.ops(),
+	}, nil
+}
+
+// Generate returns the catalogs described by the workload. Multihashes are
+// generated lazily as catalogs are iterated.
+func (w Workload) Generate() ([]herald.Catalog, error) {
+	w, err := w.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return w.catalogs(), nil
+}
+
+func (w Workload) catalogs() []herald.Catalog {
+	catalogs := make([]herald.Catalog, 0, w.Catalogs)
+	for i := 0; i < w.Catalogs; i++ {
+		id := binary.BigEndian.AppendUint64([]byte("heraldbench/"), uint64(i))
+		catalogs = append(catalogs, &catalog{
+			id:    id,
+			count: w.MultihashesPerCatalog,
+			sizes: w.DigestSizes,
+			code:  w.MultihashCode,
+			seed:  w.Seed + int64(i),
+		})
+	}
+	return catalogs
+}
+
+func (c *catalog) ID() []byte { return c.id }
+
+func (c *catalog) Iterator() herald.CatalogIterator {
+	return &catalogIterator{
+		catalog: c,
+		rng:     rand.New(rand.NewSource(c.seed)),
+	}
+}
+
+func (c *catalog) Transport() interface{ Providers() any } { return nil }
+
+func (i *catalogIterator) Next() (multihash.Multihash, error) {
+	if i.Done() {
+		return nil, herald.ErrCatalogIteratorDone
+	}
+	size := i.sizes[i.next%len(i.sizes)]
+	// The multihash is encoded into the buffer of the previous one, which
+	// Herald no longer refers to. See herald.CatalogIterator.
+	i.mh = binary.AppendUvarint(i.mh[:0], i.code)
+	i.mh = binary.AppendUvarint(i.mh, uint64(size))
+	start := len(i.mh)
+	i.mh = append(i.mh, make([]byte, size)...)
+	_, _ = i.rng.Read(i.mh[start:])
+	i.next++
+	return i.mh, nil
+}
+
+func (i *catalogIterator) Done() bool { return i.next >= i.count }
@@ -0,0 +1,294 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: herald.proto
+
+package heraldgrpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Herald_Publish_FullMethodName      = "/herald.v1.Herald/Publish"
+	Herald_Retract_FullMethodName      = "/herald.v1.Herald/Retract"
+	Herald_GetHead_FullMethodName      = "/herald.v1.Herald/GetHead"
+	Herald_ListCatalogs_FullMethodName = "/herald.v1.Herald/ListCatalogs"
+)
+
+// HeraldClient is the client API for Herald service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type HeraldClient interface {
+	// Publish publishes the catalog whose multihashes are streamed by the
+	// client. The context ID and options are set by the first message, and
+	// the catalog is published once the client closes the stream.
+	Publish(ctx context.Context, opts ...grpc.CallOption) (Herald_PublishClient, error)
+	// Retract retracts a catalog.
+	Retract(ctx context.Context, in *RetractRequest, opts ...grpc.CallOption) (*RetractResponse, error)
+	// GetHead returns the head of the advertisement chain.
+	GetHead(ctx context.Context, in *GetHeadRequest, opts ...grpc.CallOption) (*GetHeadResponse, error)
+	// ListCatalogs streams the status of every catalog.
+	ListCatalogs(ctx context.Context, in *ListCatalogsRequest, opts ...grpc.CallOption) (Herald_ListCatalogsClient, error)
+}
+
+type heraldClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHeraldClient(cc grpc.ClientConnInterface) HeraldClient {
+	return &heraldClient{cc}
+}
+
+func (c *heraldClient) Publish(ctx context.Context, opts ...grpc.CallOption) (Herald_PublishClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Herald_ServiceDesc.Streams[0], Herald_Publish_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &heraldPublishClient{stream}
+	return x, nil
+}
+
+type Herald_PublishClient interface {
+	Send(*PublishRequest) error
+	CloseAndRecv() (*PublishResponse, error)
+	grpc.ClientStream
+}
+
+type heraldPublishClient struct {
+	grpc.ClientStream
+}
+
+func (x *heraldPublishClient) Send(m *PublishRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *heraldPublishClient) CloseAndRecv() (*PublishResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PublishResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *heraldClient) Retract(ctx context.Context, in *RetractRequest, opts ...grpc.CallOption) (*RetractResponse, error) {
+	out := new(RetractResponse)
+	err := c.cc.Invoke(ctx, Herald_Retract_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *heraldClient) GetHead(ctx context.Context, in *GetHeadRequest, opts ...grpc.CallOption) (*GetHeadResponse, error) {
+	out := new(GetHeadResponse)
+	err := c.cc.Invoke(ctx, Herald_GetHead_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *heraldClient) ListCatalogs(ctx context.Context, in *ListCatalogsRequest, opts ...grpc.CallOption) (Herald_ListCatalogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Herald_ServiceDesc.Streams[1], Herald_ListCatalogs_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &heraldListCatalogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Herald_ListCatalogsClient interface {
+	Recv() (*CatalogStatus, error)
+	grpc.ClientStream
+}
+
+type heraldListCatalogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *heraldListCatalogsClient) Recv() (*CatalogStatus, error) {
+	m := new(CatalogStatus)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// HeraldServer is the server API for Herald service.
+// All implementations must embed UnimplementedHeraldServer
+// for forward compatibility
+type HeraldServer interface {
+	// Publish publishes the catalog whose multihashes are streamed by the
+	// client. The context ID and options are set by the first message, and
+	// the catalog is published once the client closes the stream.
+	Publish(Herald_PublishServer) error
+	// Retract retracts a catalog.
+	Retract(context.Context, *RetractRequest) (*RetractResponse, error)
+	// GetHead returns the head of the advertisement chain.
+	GetHead(context.Context, *GetHeadRequest) (*GetHeadResponse, error)
+	// ListCatalogs streams the status of every catalog.
+	ListCatalogs(*ListCatalogsRequest, Herald_ListCatalogsServer) error
+	mustEmbedUnimplementedHeraldServer()
+}
+
+// UnimplementedHeraldServer must be embedded to have forward compatible implementations.
+type UnimplementedHeraldServer struct {
+}
+
+func (UnimplementedHeraldServer) Publish(Herald_PublishServer) error {
+	return status.Errorf(codes.Unimplemented, "method Publish not implemented")
+}
+func (UnimplementedHeraldServer) Retract(context.Context, *RetractRequest) (*RetractResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Retract not implemented")
+}
+func (UnimplementedHeraldServer) GetHead(context.Context, *GetHeadRequest) (*GetHeadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHead not implemented")
+}
+func (UnimplementedHeraldServer) ListCatalogs(*ListCatalogsRequest, Herald_ListCatalogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListCatalogs not implemented")
+}
+func (UnimplementedHeraldServer) mustEmbedUnimplementedHeraldServer() {}
+
+// UnsafeHeraldServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HeraldServer will
+// result in compilation errors.
+type UnsafeHeraldServer interface {
+	mustEmbedUnimplementedHeraldServer()
+}
+
+func RegisterHeraldServer(s grpc.ServiceRegistrar, srv HeraldServer) {
+	s.RegisterService(&Herald_ServiceDesc, srv)
+}
+
+func _Herald_Publish_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HeraldServer).Publish(&heraldPublishServer{stream})
+}
+
+type Herald_PublishServer interface {
+	SendAndClose(*PublishResponse) error
+	Recv() (*PublishRequest, error)
+	grpc.ServerStream
+}
+
+type heraldPublishServer struct {
+	grpc.ServerStream
+}
+
+func (x *heraldPublishServer) SendAndClose(m *PublishResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *heraldPublishServer) Recv() (*PublishRequest, error) {
+	m := new(PublishRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Herald_Retract_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetractRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeraldServer).Retract(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Herald_Retract_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeraldServer).Retract(ctx, req.(*RetractRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Herald_GetHead_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetHeadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeraldServer).GetHead(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Herald_GetHead_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeraldServer).GetHead(ctx, req.(*GetHeadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Herald_ListCatalogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListCatalogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HeraldServer).ListCatalogs(m, &heraldListCatalogsServer{stream})
+}
+
+type Herald_ListCatalogsServer interface {
+	Send(*CatalogStatus) error
+	grpc.ServerStream
+}
+
+type heraldListCatalogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *heraldListCatalogsServer) Send(m *CatalogStatus) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Herald_ServiceDesc is the grpc.ServiceDesc for Herald service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Herald_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "herald.v1.Herald",
+	HandlerType: (*HeraldServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Retract",
+			Handler:    _Herald_Retract_Handler,
+		},
+		{
+			MethodName: "GetHead",
+			Handler:    _Herald_GetHead_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Publish",
+			Handler:       _Herald_Publish_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ListCatalogs",
+			Handler:       _Herald_ListCatalogs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "herald.proto",
+}
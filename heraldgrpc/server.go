@@ -0,0 +1,194 @@
+// Package heraldgrpc serves Herald over gRPC, so that services not written in
+// Go can stream content into it efficiently.
+package heraldgrpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative herald.proto
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/herald"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var _ HeraldServer = (*server)(nil)
+
+type server struct {
+	UnimplementedHeraldServer
+	h      *herald.Herald
+	logger *zap.SugaredLogger
+}
+
+// NewServer returns the gRPC service backed by the given Herald instance.
+func NewServer(h *herald.Herald) HeraldServer {
+	return &server{h: h, logger: h.Logger("grpc").Sugar()}
+}
+
+// Register registers the gRPC service backed by the given Herald instance
+// with s.
+func Register(s grpc.ServiceRegistrar, h *herald.Herald) {
+	RegisterHeraldServer(s, NewServer(h))
+}
+
+func (s *server) Publish(stream Herald_PublishServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	first, err := stream.Recv()
+	switch {
+	case errors.Is(err, io.EOF):
+		return status.Error(codes.InvalidArgument, "no publish request received")
+	case err != nil:
+		return err
+	}
+	if len(first.ContextId) == 0 {
+		return status.Error(codes.InvalidArgument, "context ID must be specified")
+	}
+	var opts []herald.PublishOption
+	if first.Append {
+		opts = append(opts, herald.WithAppend())
+	}
+
+	// Multihashes are received while the catalog is being published, such
+	// that the client is held back by the chunker.
+	mhs := make(chan multihash.Multihash)
+	received := make(chan error, 1)
+	go func() {
+		err := receiveMultihashes(ctx, stream, first, mhs)
+		if err != nil {
+			// The channel is left open so that the catalog fails with the
+			// context error rather than ending early.
+			cancel()
+		} else {
+			close(mhs)
+		}
+		received <- err
+	}()
+	receipt, err := s.h.PublishWithOptions(ctx, herald.NewChannelCatalog(ctx, first.ContextId, mhs), opts...)
+	cancel()
+	if rerr := <-received; rerr != nil && !errors.Is(rerr, context.Canceled) {
+		s.logger.Warnw("failed to receive multihashes", "contextID", string(first.ContextId), "err", rerr)
+		return rerr
+	}
+	if err != nil {
+		s.logger.Warnw("failed to publish streamed multihashes", "contextID", string(first.ContextId), "err", err)
+		return statusError(err)
+	}
+	s.logger.Infow("Published streamed multihashes", "contextID", string(first.ContextId), "ad", receipt.Advertisement, "mhCount", receipt.MultihashCount)
+	return stream.SendAndClose(&PublishResponse{
+		Advertisement:  receipt.Advertisement.String(),
+		Entries:        cidString(receipt.Entries),
+		MultihashCount: int64(receipt.MultihashCount),
+		ChunkCount:     int64(receipt.ChunkCount),
+		FilteredCount:  int64(receipt.FilteredCount),
+		Unchanged:      receipt.Unchanged,
+	})
+}
+
+// receiveMultihashes sends the multihashes of req, followed by those of every
+// request received until the client closes the stream.
+func receiveMultihashes(ctx context.Context, stream Herald_PublishServer, req *PublishRequest, mhs chan<- multihash.Multihash) error {
+	for {
+		for _, b := range req.Multihashes {
+			mh, err := multihash.Cast(b)
+			if err != nil {
+				return status.Errorf(codes.InvalidArgument, "invalid multihash: %v", err)
+			}
+			select {
+			case mhs <- mh:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var err error
+		switch req, err = stream.Recv(); {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+}
+
+func (s *server) Retract(ctx context.Context, req *RetractRequest) (*RetractResponse, error) {
+	if len(req.ContextId) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "context ID must be specified")
+	}
+	ad, err := s.h.Retract(ctx, req.ContextId)
+	if err != nil {
+		s.logger.Warnw("failed to retract catalog", "contextID", string(req.ContextId), "err", err)
+		return nil, statusError(err)
+	}
+	return &RetractResponse{Advertisement: ad.String()}, nil
+}
+
+func (s *server) GetHead(ctx context.Context, _ *GetHeadRequest) (*GetHeadResponse, error) {
+	head, err := s.h.GetHead(ctx)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &GetHeadResponse{Head: cidString(head)}, nil
+}
+
+func (s *server) ListCatalogs(_ *ListCatalogsRequest, stream Herald_ListCatalogsServer) error {
+	statuses, err := s.h.ListCatalogs(stream.Context())
+	if err != nil {
+		return statusError(err)
+	}
+	for _, st := range statuses {
+		resp := &CatalogStatus{
+			ContextId:      st.ID,
+			Advertisement:  st.Advertisement.String(),
+			Entries:        cidString(st.Entries),
+			MultihashCount: int64(st.MultihashCount),
+			Retracted:      st.Retracted,
+		}
+		if !st.Published.IsZero() {
+			resp.Published = timestamppb.New(st.Published)
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusError maps the errors returned by Herald to gRPC status errors.
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, herald.ErrCatalogNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, herald.ErrBusy):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, herald.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, herald.ErrHeadMoved):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, herald.ErrAdTooLarge),
+		errors.Is(err, herald.ErrEntryChunkTooLarge),
+		errors.Is(err, herald.ErrEntriesTooDeep),
+		errors.Is(err, herald.ErrInvalidAdvertisement),
+		errors.Is(err, herald.ErrInvalidContextID):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func cidString(c cid.Cid) string {
+	if !c.Defined() {
+		return ""
+	}
+	return c.String()
+}
@@ -0,0 +1,178 @@
+// Package heraldtest provides deterministic advertisement fixtures generated by
+// Herald, along with golden file assertions to detect changes to their CIDs,
+// and a Syncer to verify publishes end to end as an indexer would see them.
+package heraldtest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// EntriesChunkSize is the number of multihashes per entry chunk used when
+	// generating fixtures.
+	EntriesChunkSize = 4
+)
+
+var (
+	identitySeed = sha256.Sum256([]byte("heraldtest identity"))
+
+	_ herald.Catalog = (*catalog)(nil)
+)
+
+type (
+	// Fixture describes an advertisement generated by Herald along with the
+	// steps that produced it.
+	Fixture struct {
+		Name      string `json:"name"`
+		ContextID string `json:"contextID"`
+		Ad        string `json:"ad"`
+		Entries   string `json:"entries,omitempty"`
+		IsRm      bool   `json:"isRm,omitempty"`
+	}
+	catalog struct {
+		id  []byte
+		mhs []multihash.Multihash
+	}
+	catalogIterator struct {
+		mhs []multihash.Multihash
+	}
+	step struct {
+		name      string
+		contextID string
+		mhCount   int
+		retract   bool
+	}
+)
+
+var steps = []step{
+	{name: "publish-multi-chunk", contextID: "fixture-a", mhCount: 10},
+	{name: "publish-single-chunk", contextID: "fixture-b", mhCount: 1},
+	{name: "publish-exact-chunk", contextID: "fixture-c", mhCount: EntriesChunkSize},
+	{name: "retract", contextID: "fixture-a", retract: true},
+}
+
+// Identity returns the fixed identity used to sign fixture advertisements.
+func Identity() crypto.PrivKey {
+	key, err := crypto.UnmarshalEd25519PrivateKey(ed25519.NewKeyFromSeed(identitySeed[:]))
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Options returns the Herald options used to generate fixtures, backed by an
+// in-memory datastore. Additional options are applied after the defaults.
+func Options(o ...herald.Option) []herald.Option {
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/40080/http")
+	return append([]herald.Option{
+		herald.WithIdentity(Identity()),
+		herald.WithProviderAddress(addr),
+		herald.WithPermissiveValidation(true),
+		herald.WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+		herald.WithAdEntriesChunkSize(EntriesChunkSize),
+		herald.WithDatastore(sync.MutexWrap(datastore.NewMapDatastore())),
+	}, o...)
+}
+
+// Catalog returns a deterministic catalog with the given context ID and number
+// of multihashes.
+func Catalog(contextID string, mhCount int) herald.Catalog {
+	c := &catalog{
+		id:  []byte(contextID),
+		mhs: make([]multihash.Multihash, 0, mhCount),
+	}
+	for i := 0; i < mhCount; i++ {
+		mh, err := multihash.Sum([]byte(fmt.Sprintf("%s/%d", contextID, i)), multihash.SHA2_256, -1)
+		if err != nil {
+			panic(err)
+		}
+		c.mhs = append(c.mhs, mh)
+	}
+	return c
+}
+
+// GenerateFixtures publishes a fixed sequence of catalogs and retractions
+// using a new Herald instance configured with Options, and returns the
+// resulting advertisements in the order they were published.
+func GenerateFixtures(ctx context.Context, o ...herald.Option) ([]Fixture, error) {
+	h, err := herald.New(Options(o...)...)
+	if err != nil {
+		return nil, err
+	}
+	fixtures := make([]Fixture, 0, len(steps))
+	for _, s := range steps {
+		var ad cid.Cid
+		if s.retract {
+			ad, err = h.Retract(ctx, herald.CatalogID(s.contextID))
+		} else {
+			ad, err = h.Publish(ctx, Catalog(s.contextID, s.mhCount))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate fixture %s: %w", s.name, err)
+		}
+		f := Fixture{
+			Name:      s.name,
+			ContextID: s.contextID,
+			Ad:        ad.String(),
+			IsRm:      s.retract,
+		}
+		if !s.retract {
+			if f.Entries, err = entriesOf(ctx, h, ad); err != nil {
+				return nil, err
+			}
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+func entriesOf(ctx context.Context, h *herald.Herald, ad cid.Cid) (string, error) {
+	r, err := h.GetContent(ctx, ad)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	nb := schema.AdvertisementPrototype.NewBuilder()
+	if err := dagjson.Decode(nb, r); err != nil {
+		return "", err
+	}
+	decoded, err := schema.UnwrapAdvertisement(nb.Build())
+	if err != nil {
+		return "", err
+	}
+	return decoded.Entries.(cidlink.Link).Cid.String(), nil
+}
+
+func (c *catalog) ID() []byte { return c.id }
+
+func (c *catalog) Iterator() herald.CatalogIterator {
+	return &catalogIterator{mhs: c.mhs}
+}
+
+func (c *catalog) Transport() interface{ Providers() any } { return nil }
+
+func (i *catalogIterator) Next() (multihash.Multihash, error) {
+	if len(i.mhs) == 0 {
+		return nil, herald.ErrCatalogIteratorDone
+	}
+	next := i.mhs[0]
+	i.mhs = i.mhs[1:]
+	return next, nil
+}
+
+func (i *catalogIterator) Done() bool { return len(i.mhs) == 0 }
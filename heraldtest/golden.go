@@ -0,0 +1,69 @@
+package heraldtest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipni/herald"
+)
+
+// GoldenFile is the path, relative to this package, of the golden file
+// containing the expected fixtures.
+const GoldenFile = "testdata/fixtures.golden.json"
+
+// AssertGoldenFixtures generates fixtures and asserts them against the golden
+// file at the given path.
+func AssertGoldenFixtures(t testing.TB, path string, o ...herald.Option) {
+	t.Helper()
+	got, err := GenerateFixtures(context.Background(), o...)
+	if err != nil {
+		t.Fatalf("failed to generate fixtures: %v", err)
+	}
+	AssertGolden(t, path, got)
+}
+
+// AssertGolden asserts that the given fixtures match the ones stored in the
+// golden file at path. Golden files are regenerated via WriteGolden.
+func AssertGolden(t testing.TB, path string, got []Fixture) {
+	t.Helper()
+	want, err := ReadGolden(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file %s: %v", path, err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d fixtures but got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("fixture %q does not match golden file\n  want: %+v\n   got: %+v", want[i].Name, want[i], got[i])
+		}
+	}
+}
+
+// ReadGolden reads fixtures from the golden file at path.
+func ReadGolden(path string) ([]Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+// WriteGolden writes fixtures to the golden file at path.
+func WriteGolden(path string, fixtures []Fixture) error {
+	data, err := json.MarshalIndent(fixtures, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,28 @@
+package heraldtest_test
+
+import (
+	"context"
+	"flag"
+	"testing"
+
+	"github.com/ipni/herald/heraldtest"
+)
+
+var update = flag.Bool("update", false, "Regenerate the golden fixtures instead of asserting them.")
+
+// TestGoldenFixtures catches changes to the encoding of advertisements and
+// entries. Run with -update to regenerate the golden file once a change is
+// intended.
+func TestGoldenFixtures(t *testing.T) {
+	if *update {
+		fixtures, err := heraldtest.GenerateFixtures(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := heraldtest.WriteGolden(heraldtest.GoldenFile, fixtures); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	heraldtest.AssertGoldenFixtures(t, heraldtest.GoldenFile)
+}
@@ -0,0 +1,227 @@
+package heraldtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+type (
+	// Syncer syncs the advertisement chain from a herald.ChainSource, such as
+	// a Herald instance in process or a client.Client, the way an indexer
+	// would, so that publishes can be verified end to end without setting up
+	// an indexer. It is safe for concurrent use.
+	Syncer struct {
+		src       herald.ChainSource
+		publisher peer.ID
+		ls        ipld.LinkSystem
+		mu        sync.Mutex
+		latest    cid.Cid
+		// index holds the multihashes of each catalog, keyed by provider and
+		// context ID.
+		index map[catalogKey]map[string]struct{}
+	}
+	// SyncedAd is an advertisement synced by Syncer.
+	SyncedAd struct {
+		CID cid.Cid
+		Ad  *schema.Advertisement
+		// Multihashes are the entries of the advertisement, or nil if it has
+		// none or its context is retracted later in the synced chain, in
+		// which case they are not loaded, like indexers do.
+		Multihashes []multihash.Multihash
+	}
+	catalogKey struct {
+		provider  string
+		contextID string
+	}
+)
+
+// NewSyncer instantiates a syncer of the chain from the given source. If
+// publisher is set, the advertisements must be signed by it.
+func NewSyncer(src herald.ChainSource, publisher peer.ID) *Syncer {
+	s := &Syncer{
+		src:       src,
+		publisher: publisher,
+		index:     make(map[catalogKey]map[string]struct{}),
+	}
+	s.ls = cidlink.DefaultLinkSystem()
+	s.ls.StorageReadOpener = func(lctx linking.LinkContext, l ipld.Link) (io.Reader, error) {
+		return src.GetContent(lctx.Ctx, l.(cidlink.Link).Cid)
+	}
+	return s
+}
+
+// Sync syncs the advertisements published since the last sync, if any, up to
+// the current head of the source, and returns them from the oldest. Each is
+// validated and its signature verified, and its entries are applied to the
+// synced multihashes. Nothing is applied if syncing fails.
+func (s *Syncer) Sync(ctx context.Context) ([]SyncedAd, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	head, err := s.src.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var synced []SyncedAd
+	retracted := make(map[catalogKey]struct{})
+	for next := head; next.Defined() && !next.Equals(s.latest); {
+		ad, err := s.loadAdvertisement(ctx, next)
+		if err != nil {
+			return nil, fmt.Errorf("advertisement %s: %w", next, err)
+		}
+		key := catalogKey{provider: ad.Provider, contextID: string(ad.ContextID)}
+		sa := SyncedAd{CID: next, Ad: ad}
+		if _, skip := retracted[key]; !skip && hasEntries(ad) {
+			if sa.Multihashes, err = s.loadEntries(ctx, ad.Entries.(cidlink.Link).Cid); err != nil {
+				return nil, fmt.Errorf("entries of advertisement %s: %w", next, err)
+			}
+		}
+		if ad.IsRm && !hasEntries(ad) {
+			retracted[key] = struct{}{}
+		}
+		synced = append(synced, sa)
+		if ad.PreviousID == nil {
+			break
+		}
+		next = ad.PreviousID.(cidlink.Link).Cid
+	}
+	for i, j := 0, len(synced)-1; i < j; i, j = i+1, j-1 {
+		synced[i], synced[j] = synced[j], synced[i]
+	}
+	for _, sa := range synced {
+		s.apply(&sa)
+	}
+	if head.Defined() {
+		s.latest = head
+	}
+	return synced, nil
+}
+
+// Multihashes returns the multihashes synced for the catalog with the given
+// context ID published on behalf of the given provider, or nil if it is not
+// advertised.
+func (s *Syncer) Multihashes(provider peer.ID, contextID []byte) []multihash.Multihash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mhs := s.index[catalogKey{provider: provider.String(), contextID: string(contextID)}]
+	if len(mhs) == 0 {
+		return nil
+	}
+	result := make([]multihash.Multihash, 0, len(mhs))
+	for mh := range mhs {
+		result = append(result, multihash.Multihash(mh))
+	}
+	return result
+}
+
+// Latest returns the latest advertisement synced, or cid.Undef if none.
+func (s *Syncer) Latest() cid.Cid {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest
+}
+
+func (s *Syncer) loadAdvertisement(ctx context.Context, c cid.Cid) (*schema.Advertisement, error) {
+	n, err := s.ls.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: c}, schema.AdvertisementPrototype)
+	if err != nil {
+		return nil, err
+	}
+	ad, err := schema.UnwrapAdvertisement(n)
+	if err != nil {
+		return nil, err
+	}
+	if err := ad.Validate(); err != nil {
+		return nil, err
+	}
+	signer, err := ad.VerifySignature()
+	if err != nil {
+		return nil, err
+	}
+	if s.publisher != "" && signer != s.publisher {
+		return nil, fmt.Errorf("signed by %s instead of publisher %s", signer, s.publisher)
+	}
+	return ad, nil
+}
+
+// loadEntries loads the multihashes in the entries with the given root, laid
+// out either as a chain of entry chunks or as a HAMT.
+func (s *Syncer) loadEntries(ctx context.Context, root cid.Cid) ([]multihash.Multihash, error) {
+	var mhs []multihash.Multihash
+	if n, err := s.ls.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root}, hamt.HashMapRootPrototype.Representation()); err == nil {
+		hamtRoot, ok := bindnode.Unwrap(n).(*hamt.HashMapRoot)
+		if !ok {
+			return nil, errors.New("entries HAMT has unexpected root")
+		}
+		node := hamt.Node{HashMapRoot: *hamtRoot}
+		for it := node.WithLinking(s.ls, cidlink.LinkPrototype{Prefix: root.Prefix()}).MapIterator(); !it.Done(); {
+			k, _, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return nil, err
+			}
+			mhs = append(mhs, multihash.Multihash(key))
+		}
+		return mhs, nil
+	}
+	for next := root; next.Defined(); {
+		n, err := s.ls.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: next}, schema.EntryChunkPrototype)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := schema.UnwrapEntryChunk(n)
+		if err != nil {
+			return nil, err
+		}
+		mhs = append(mhs, chunk.Entries...)
+		if chunk.Next == nil {
+			break
+		}
+		next = chunk.Next.(cidlink.Link).Cid
+	}
+	return mhs, nil
+}
+
+// apply updates the synced multihashes with the given advertisement: its
+// entries are added to its catalog, or removed if it is a removal, and a
+// removal without entries retracts the catalog.
+func (s *Syncer) apply(sa *SyncedAd) {
+	key := catalogKey{provider: sa.Ad.Provider, contextID: string(sa.Ad.ContextID)}
+	if sa.Ad.IsRm && !hasEntries(sa.Ad) {
+		delete(s.index, key)
+		return
+	}
+	mhs := s.index[key]
+	if mhs == nil {
+		mhs = make(map[string]struct{})
+		s.index[key] = mhs
+	}
+	for _, mh := range sa.Multihashes {
+		if sa.Ad.IsRm {
+			delete(mhs, string(mh))
+		} else {
+			mhs[string(mh)] = struct{}{}
+		}
+	}
+}
+
+func hasEntries(ad *schema.Advertisement) bool {
+	return ad.Entries != nil && ad.Entries != schema.NoEntries
+}
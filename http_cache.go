@@ -0,0 +1,27 @@
+package herald
+
+import (
+	"net/http"
+	"strings"
+)
+
+// etag returns the strong entity tag of an immutable resource identified by
+// the given string, e.g. a CID.
+func etag(id string) string {
+	return `"` + id + `"`
+}
+
+// notModified reports whether the request is conditional on an entity tag
+// matching the given one, per the weak comparison that RFC 9110 specifies for
+// If-None-Match, in which case a 304 Not Modified is due.
+func notModified(r *http.Request, etag string) bool {
+	for _, value := range r.Header.Values("If-None-Match") {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
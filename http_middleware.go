@@ -0,0 +1,122 @@
+package herald
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// corsMaxAge is how long browsers may cache the outcome of preflight
+	// requests.
+	corsMaxAge = 24 * time.Hour
+	// corsAllowedMethods are those served by the HTTP publisher.
+	corsAllowedMethods = "GET, HEAD, OPTIONS"
+	// corsExposedHeaders are the response headers, beyond those safelisted,
+	// that browser-based clients need to sync the chain efficiently.
+	corsExposedHeaders = "Content-Encoding, Content-Length, Content-Range, Accept-Ranges, ETag"
+)
+
+// WithHttpMiddleware wraps the handler of the HTTP publisher with the given
+// middleware, e.g. to authenticate, log or otherwise inspect requests, where
+// the first middleware is the outermost. It wraps every endpoint, including
+// those served under the prefix set via WithHttpPublisherPathPrefix and over
+// libp2p, and only CORS handling, see WithCors, precedes it. Successive calls
+// add middleware innermost.
+func WithHttpMiddleware(m ...func(http.Handler) http.Handler) Option {
+	return func(o *options) error {
+		for _, mw := range m {
+			if mw == nil {
+				return errors.New("HTTP middleware must not be nil")
+			}
+		}
+		o.httpMiddleware = append(o.httpMiddleware, m...)
+		return nil
+	}
+}
+
+// WithCors allows browser-based tools served from the given origins, e.g.
+// "https://example.com", to fetch the chain from the HTTP publisher via
+// cross-origin requests. "*" allows any origin. Preflight requests are
+// answered before any middleware set via WithHttpMiddleware runs, since
+// browsers send them without credentials. Disabled by default.
+func WithCors(origins ...string) Option {
+	return func(o *options) error {
+		for _, origin := range origins {
+			if origin == "*" {
+				continue
+			}
+			u, err := url.Parse(origin)
+			if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+				return fmt.Errorf("invalid CORS origin %q: must be \"*\" or a scheme and host, e.g. https://example.com", origin)
+			}
+		}
+		o.corsOrigins = make([]string, 0, len(origins))
+		for _, origin := range origins {
+			o.corsOrigins = append(o.corsOrigins, strings.TrimSuffix(origin, "/"))
+		}
+		return nil
+	}
+}
+
+// withMiddleware wraps the given handler with the middleware set via
+// WithHttpMiddleware, and then with CORS handling.
+func (p *httpPublisher) withMiddleware(next http.Handler) http.Handler {
+	for i := len(p.h.httpMiddleware) - 1; i >= 0; i-- {
+		next = p.h.httpMiddleware[i](next)
+	}
+	return p.cors(next)
+}
+
+// cors wraps the given handler to allow cross-origin requests from the origins
+// set via WithCors, and to answer their preflight requests.
+func (p *httpPublisher) cors(next http.Handler) http.Handler {
+	if len(p.h.corsOrigins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed, ok := p.allowedOrigin(origin)
+		if !ok {
+			// Without CORS headers, browsers withhold the response from the
+			// requesting page.
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", strconv.FormatInt(int64(corsMaxAge/time.Second), 10))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin returns the value of the Access-Control-Allow-Origin header for
+// the given origin, and whether it is allowed at all.
+func (p *httpPublisher) allowedOrigin(origin string) (string, bool) {
+	for _, allowed := range p.h.corsOrigins {
+		switch {
+		case allowed == "*":
+			return "*", true
+		case strings.EqualFold(allowed, origin):
+			return origin, true
+		}
+	}
+	return "", false
+}
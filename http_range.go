@@ -0,0 +1,73 @@
+package herald
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errRangeNotSatisfiable signals that the requested byte range starts beyond
+// the end of the content.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// requestedRange returns the byte range of content of the given size and ETag
+// requested via the Range header, if any. Only single ranges are supported:
+// requests for multiple ranges, for units other than bytes, with malformed
+// ranges or with an If-Range precondition that does not match etag are served
+// the entire content, as permitted by RFC 9110.
+func requestedRange(r *http.Request, size int64, etag string) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" {
+		// A suffix range selects the last bytes of the content.
+		n, err := strconv.ParseInt(last, 10, 64)
+		switch {
+		case err != nil || n < 0:
+			return 0, 0, false, nil
+		case n == 0 || size == 0:
+			return 0, 0, false, errRangeNotSatisfiable
+		case n > size:
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, true, nil
+}
+
+// skip discards the first n bytes read from the given reader, seeking past
+// them if it is an io.Seeker.
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
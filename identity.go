@@ -0,0 +1,167 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
+)
+
+// identityKey is the key under which the identity generated when none is
+// specified is persisted.
+var identityKey = datastore.NewKey("identity")
+
+// WithIdentityFromFile loads the identity from the private key in the given
+// file, as decoded by DecodeIdentity. If the file does not exist, an Ed25519
+// identity is generated and written to it as a libp2p protobuf-encoded key,
+// such that it survives restarts.
+func WithIdentityFromFile(path string) Option {
+	return func(o *options) error {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			key, err := generateIdentityFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to generate identity file: %w", err)
+			}
+			o.logger.Infow("Generated identity", "path", path)
+			return WithIdentity(key)(o)
+		case err != nil:
+			return err
+		}
+		key, err := DecodeIdentity(data)
+		if err != nil {
+			return fmt.Errorf("invalid identity in %s: %w", path, err)
+		}
+		return WithIdentity(key)(o)
+	}
+}
+
+// WithIdentityFromEnv loads the identity from the private key held by the
+// given environment variable, as decoded by DecodeIdentity. It fails if the
+// variable is not set.
+func WithIdentityFromEnv(name string) Option {
+	return func(o *options) error {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return fmt.Errorf("identity environment variable %s is not set", name)
+		}
+		key, err := DecodeIdentity([]byte(value))
+		if err != nil {
+			return fmt.Errorf("invalid identity in environment variable %s: %w", name, err)
+		}
+		return WithIdentity(key)(o)
+	}
+}
+
+// DecodeIdentity decodes a libp2p private key encoded as protobuf, either raw
+// or in base64, or as PEM. PEM blocks may hold a PKCS #8, PKCS #1 or SEC 1
+// key, or a protobuf-encoded libp2p key.
+func DecodeIdentity(data []byte) (crypto.PrivKey, error) {
+	if key, err := crypto.UnmarshalPrivateKey(data); err == nil {
+		return key, nil
+	}
+	data = bytes.TrimSpace(data)
+	if block, _ := pem.Decode(data); block != nil {
+		return decodePEMIdentity(block)
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := enc.DecodeString(string(data)); err == nil {
+			return crypto.UnmarshalPrivateKey(decoded)
+		}
+	}
+	return nil, errors.New("unrecognized private key encoding")
+}
+
+func decodePEMIdentity(block *pem.Block) (crypto.PrivKey, error) {
+	var std any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		std, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		std, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		std, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return crypto.UnmarshalPrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Ed25519 keys are parsed by value, whereas libp2p expects a pointer.
+	if k, ok := std.(ed25519.PrivateKey); ok {
+		std = &k
+	}
+	key, _, err := crypto.KeyPairFromStdKey(std)
+	return key, err
+}
+
+// persistedIdentity returns the identity persisted in the given datastore,
+// generating and persisting an Ed25519 identity if there is none.
+func persistedIdentity(ctx context.Context, ds datastore.Datastore, logger *zap.SugaredLogger) (crypto.PrivKey, error) {
+	switch data, err := ds.Get(ctx, identityKey); {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		return crypto.UnmarshalPrivateKey(data)
+	}
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ds.Put(ctx, identityKey, data); err != nil {
+		return nil, err
+	}
+	if err := ds.Sync(ctx, identityKey); err != nil {
+		return nil, err
+	}
+	logger.Warnw("no identity is specified; generated one and persisted it in the datastore")
+	return key, nil
+}
+
+// generateIdentityFile generates an Ed25519 identity and writes it to the
+// given path atomically, readable only by the owner.
+func generateIdentityFile(path string) (crypto.PrivKey, error) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
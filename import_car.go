@@ -0,0 +1,72 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	carv2 "github.com/ipld/go-car/v2"
+)
+
+// ImportCAR loads the advertisements and entries contained in a CARv1 or
+// CARv2 stream into Herald's datastores and sets the head to the first root
+// of the CAR, e.g. to migrate from another publisher implementation. The
+// chain is walked from the root backwards, and every advertisement and entry
+// chunk it links to must be present in the CAR; other blocks are ignored.
+// The blocks are held in memory until the import completes.
+//
+// The import fails with ErrHeadExists if Herald has already published an
+// advertisement, and with ErrReadOnly once its chain is handed over.
+// Publishes are blocked while the chain is imported.
+func (h *Herald) ImportCAR(ctx context.Context, r io.Reader) (cid.Cid, error) {
+	dst := h.publisher.dsPublisher
+	dst.gcLocker.RLock()
+	defer dst.gcLocker.RUnlock()
+	dst.locker.Lock()
+	defer dst.locker.Unlock()
+	if dst.readOnly {
+		return cid.Undef, ErrReadOnly
+	}
+	if head, err := dst.GetHead(ctx); err != nil {
+		return cid.Undef, err
+	} else if !cid.Undef.Equals(head) {
+		return cid.Undef, ErrHeadExists
+	}
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to read CAR: %w", err)
+	}
+	if len(br.Roots) == 0 {
+		return cid.Undef, errors.New("CAR has no roots")
+	}
+	head := br.Roots[0]
+	blocks := make(map[string][]byte)
+	for {
+		blk, err := br.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return cid.Undef, fmt.Errorf("failed to read CAR block: %w", err)
+		}
+		blocks[string(blk.Cid().Hash())] = blk.RawData()
+	}
+	imp := newChainImporter(dst, func(_ context.Context, c cid.Cid) ([]byte, error) {
+		data, ok := blocks[string(c.Hash())]
+		if !ok {
+			return nil, datastore.ErrNotFound
+		}
+		return data, nil
+	})
+	if _, err := imp.importChain(ctx, head); err != nil {
+		return cid.Undef, err
+	}
+	if err := dst.setHead(ctx, head); err != nil {
+		return cid.Undef, err
+	}
+	h.logger.Infow("Imported advertisement chain from CAR", "head", head, "ads", imp.ads, "chunks", imp.chunks, "ignoredBlocks", len(blocks)-imp.ads-imp.chunks)
+	return head, nil
+}
@@ -0,0 +1,232 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+var (
+	// indexProviderHeadKey is the key at which ipni/index-provider engine stores
+	// the CID of its latest advertisement.
+	indexProviderHeadKey = datastore.NewKey("sync/adv")
+
+	ErrHeadExists = errors.New("head already exists")
+)
+
+type (
+	IndexProviderImportOption  func(*indexProviderImportOptions)
+	indexProviderImportOptions struct {
+		headKey             datastore.Key
+		allowMissingEntries bool
+	}
+	// chainImporter copies an advertisement chain, along with its entries,
+	// from a source of blocks into Herald's datastores.
+	chainImporter struct {
+		get                 func(context.Context, cid.Cid) ([]byte, error)
+		dst                 *dsPublisher
+		allowMissingEntries bool
+		// stopAt is the CID of an advertisement already present in Herald's
+		// datastore at which walking the chain stops.
+		stopAt cid.Cid
+		// verify, when set, is called with every imported advertisement.
+		verify    func(cid.Cid, *schema.Advertisement) error
+		ads       int
+		chunks    int
+		missing   int
+		skipped   int
+		seenChunk map[cid.Cid]struct{}
+	}
+)
+
+// WithIndexProviderHeadKey overrides the key at which the latest advertisement
+// CID is looked up in the index-provider datastore. Defaults to "/sync/adv".
+func WithIndexProviderHeadKey(k datastore.Key) IndexProviderImportOption {
+	return func(o *indexProviderImportOptions) {
+		o.headKey = k
+	}
+}
+
+// WithIndexProviderAllowMissingEntries skips entry chunks that are not present
+// in the index-provider datastore instead of failing the import. The engine
+// may generate entries on demand from its multihash lister, in which case they
+// are never persisted.
+func WithIndexProviderAllowMissingEntries(v bool) IndexProviderImportOption {
+	return func(o *indexProviderImportOptions) {
+		o.allowMissingEntries = v
+	}
+}
+
+// ImportIndexProvider copies the advertisement chain stored in an
+// ipni/index-provider engine datastore into Herald's datastore and sets the
+// head to the imported chain head. The chain is walked from the head backwards
+// and each block is verified against its CID before being stored.
+//
+// The import fails with ErrHeadExists if Herald has already published an
+// advertisement, and with ErrReadOnly once its chain is handed over.
+// Publishes are blocked while the chain is imported.
+func (h *Herald) ImportIndexProvider(ctx context.Context, src datastore.Datastore, o ...IndexProviderImportOption) (cid.Cid, error) {
+	opts := indexProviderImportOptions{
+		headKey: indexProviderHeadKey,
+	}
+	for _, apply := range o {
+		apply(&opts)
+	}
+	dst := h.publisher.dsPublisher
+	dst.gcLocker.RLock()
+	defer dst.gcLocker.RUnlock()
+	dst.locker.Lock()
+	defer dst.locker.Unlock()
+	if dst.readOnly {
+		return cid.Undef, ErrReadOnly
+	}
+	if head, err := dst.GetHead(ctx); err != nil {
+		return cid.Undef, err
+	} else if !cid.Undef.Equals(head) {
+		return cid.Undef, ErrHeadExists
+	}
+	value, err := src.Get(ctx, opts.headKey)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to get index-provider head: %w", err)
+	}
+	_, head, err := cid.CidFromBytes(value)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to decode index-provider head: %w", err)
+	}
+	imp := newChainImporter(dst, func(ctx context.Context, c cid.Cid) ([]byte, error) {
+		return src.Get(ctx, datastore.NewKey(c.String()))
+	})
+	imp.allowMissingEntries = opts.allowMissingEntries
+	if _, err := imp.importChain(ctx, head); err != nil {
+		return cid.Undef, err
+	}
+	if err := dst.setHead(ctx, head); err != nil {
+		return cid.Undef, err
+	}
+	h.logger.Infow("Imported index-provider advertisement chain", "head", head, "ads", imp.ads, "chunks", imp.chunks, "missingChunks", imp.missing, "skippedChunks", imp.skipped)
+	return head, nil
+}
+
+func newChainImporter(dst *dsPublisher, get func(context.Context, cid.Cid) ([]byte, error)) *chainImporter {
+	return &chainImporter{
+		get:       get,
+		dst:       dst,
+		seenChunk: make(map[cid.Cid]struct{}),
+	}
+}
+
+// importChain walks the chain from the given head backwards, copying each
+// advertisement and its entries, until the chain ends or stopAt is reached.
+// It reports whether stopAt was reached.
+func (i *chainImporter) importChain(ctx context.Context, head cid.Cid) (bool, error) {
+	for next := head; !cid.Undef.Equals(next); {
+		if next.Equals(i.stopAt) {
+			return true, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		data, err := i.copyBlock(ctx, next, i.dst.h.ds)
+		if err != nil {
+			return false, fmt.Errorf("failed to import advertisement %s: %w", next, err)
+		}
+		ad, err := decodeAdvertisement(next, data)
+		if err != nil {
+			return false, fmt.Errorf("failed to decode advertisement %s: %w", next, err)
+		}
+		if i.verify != nil {
+			if err := i.verify(next, ad); err != nil {
+				return false, err
+			}
+		}
+		if hasEntries(ad.Entries) {
+			if err := i.importEntries(ctx, ad.Entries.(cidlink.Link).Cid); err != nil {
+				return false, err
+			}
+		}
+		i.ads++
+		if ad.PreviousID == nil {
+			break
+		}
+		next = ad.PreviousID.(cidlink.Link).Cid
+	}
+	return false, nil
+}
+
+func (i *chainImporter) importEntries(ctx context.Context, root cid.Cid) error {
+	for queue := []cid.Cid{root}; len(queue) != 0; queue = queue[1:] {
+		next := queue[0]
+		if _, seen := i.seenChunk[next]; seen {
+			i.skipped++
+			continue
+		}
+		i.seenChunk[next] = struct{}{}
+		data, err := i.copyBlock(ctx, next, i.dst.entriesDs)
+		switch {
+		case errors.Is(err, datastore.ErrNotFound) && i.allowMissingEntries:
+			i.dst.h.logger.Warnw("Entry chunk is missing from import source; skipping", "cid", next)
+			i.missing++
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to import entry chunk %s: %w", next, err)
+		}
+		links, err := blockLinks(next, data)
+		if err != nil {
+			return fmt.Errorf("failed to decode entry chunk %s: %w", next, err)
+		}
+		i.chunks++
+		queue = append(queue, links...)
+	}
+	return nil
+}
+
+func (i *chainImporter) copyBlock(ctx context.Context, c cid.Cid, dst datastore.Datastore) ([]byte, error) {
+	data, err := i.get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if sum, err := c.Prefix().Sum(data); err != nil {
+		return nil, err
+	} else if !sum.Equals(c) {
+		return nil, fmt.Errorf("block data does not match CID; computed %s", sum)
+	}
+	if err := dst.Put(ctx, dsKey(cidlink.Link{Cid: c}), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func decodeAdvertisement(c cid.Cid, data []byte) (*schema.Advertisement, error) {
+	n, err := decodeBlock(c, data, schema.AdvertisementPrototype)
+	if err != nil {
+		return nil, err
+	}
+	return schema.UnwrapAdvertisement(n)
+}
+
+func decodeEntryChunk(c cid.Cid, data []byte) (*schema.EntryChunk, error) {
+	n, err := decodeBlock(c, data, schema.EntryChunkPrototype)
+	if err != nil {
+		return nil, err
+	}
+	return schema.UnwrapEntryChunk(n)
+}
+
+func decodeBlock(c cid.Cid, data []byte, np ipld.NodePrototype) (ipld.Node, error) {
+	decoder, err := cidlink.DefaultLinkSystem().DecoderChooser(cidlink.Link{Cid: c})
+	if err != nil {
+		return nil, err
+	}
+	nb := np.NewBuilder()
+	if err := decoder(nb, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
@@ -0,0 +1,26 @@
+package herald_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+)
+
+func TestImportIndexProviderAfterHandover(t *testing.T) {
+	ctx := context.Background()
+	h, err := herald.New(heraldtest.Options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if _, err := h.BeginHandover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.ImportIndexProvider(ctx, datastore.NewMapDatastore()); !errors.Is(err, herald.ErrReadOnly) {
+		t.Fatalf("expected import to fail as read-only, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package herald
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// ingestBufferSize is the number of multihashes read ahead of the chunker
+// while ingesting a stream.
+const ingestBufferSize = 1024
+
+type (
+	// IngestResult describes an advertisement published by the ingest
+	// endpoint. See IngestHandler.
+	IngestResult struct {
+		Advertisement  string `json:"advertisement,omitempty"`
+		MultihashCount int    `json:"multihashCount"`
+		ChunkCount     int    `json:"chunkCount"`
+		FilteredCount  int    `json:"filteredCount"`
+		Error          string `json:"error,omitempty"`
+	}
+
+	ingestOutcome struct {
+		receipt *PublishReceipt
+		err     error
+	}
+)
+
+// IngestHandler returns a handler through which an external service streams
+// multihashes for a context ID to be published. It is not served by the
+// publisher and should be mounted behind appropriate access control.
+//
+// Requests are POST requests to "/{contextID}" whose body is a stream of
+// newline-delimited multihashes, each encoded as base58 or as a CID. The
+// multihashes are chunked as they arrive, and an advertisement is published
+// whenever an empty line, acting as a flush marker, is received and when the
+// stream ends. Advertisements published after a flush append to the entries
+// of the previous one, as do all advertisements if the "append" query
+// parameter is "true". An IngestResult is streamed back as a line of JSON for
+// every published advertisement.
+func (h *Herald) IngestHandler() http.Handler {
+	return http.HandlerFunc(h.handleIngest)
+}
+
+func (h *Herald) handleIngest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := CatalogID(strings.TrimPrefix(r.URL.Path, "/"))
+	if len(id) == 0 {
+		http.Error(w, "context ID must be specified", http.StatusBadRequest)
+		return
+	}
+	appendEntries := r.URL.Query().Get("append") == "true"
+
+	// Results are streamed back as they come only if the request body can
+	// still be read once the response is written; otherwise they are written
+	// once the stream ends.
+	duplex := enableFullDuplex(w, r)
+	var pending []IngestResult
+	var responded bool
+	write := func(result IngestResult) {
+		if !responded {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			responded = true
+		}
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			h.httpLogger.Debugw("failed to write ingest result", "client", clientAddr(r), "err", err)
+		}
+	}
+	respond := func(result IngestResult) {
+		if !duplex {
+			pending = append(pending, result)
+			return
+		}
+		write(result)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	flushPending := func() {
+		for _, result := range pending {
+			write(result)
+		}
+	}
+	fail := func(status int, err error) {
+		if responded || len(pending) != 0 {
+			flushPending()
+			write(IngestResult{Error: err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), status)
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	for more := true; more; appendEntries = true {
+		var result IngestResult
+		var status int
+		var err error
+		if more, result, status, err = h.ingestSegment(r.Context(), id, scanner, appendEntries); err != nil {
+			h.httpLogger.Warnw("failed to ingest multihashes", "contextID", string(id), "client", clientAddr(r), "err", err)
+			fail(status, err)
+			return
+		}
+		if result.Advertisement != "" {
+			h.httpLogger.Infow("Published ingested multihashes", "contextID", string(id), "ad", result.Advertisement, "mhCount", result.MultihashCount, "client", clientAddr(r))
+			respond(result)
+		}
+	}
+	flushPending()
+	if !responded {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// enableFullDuplex allows the request body to be read after the response is
+// written, and reports whether it is allowed. HTTP/1 servers only allow it
+// when built with Go 1.21 or later.
+func enableFullDuplex(w http.ResponseWriter, r *http.Request) bool {
+	if r.ProtoMajor >= 2 {
+		return true
+	}
+	d, ok := w.(interface{ EnableFullDuplex() error })
+	return ok && d.EnableFullDuplex() == nil
+}
+
+// ingestSegment reads multihashes from the scanner until a flush marker or the
+// end of the stream, and publishes them if any. It reports whether more
+// segments may follow, along with the HTTP status to respond with on error.
+func (h *Herald) ingestSegment(ctx context.Context, id CatalogID, scanner *bufio.Scanner, appendEntries bool) (bool, IngestResult, int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mhs chan multihash.Multihash
+	var outcome chan ingestOutcome
+	abort := func(status int, err error) (bool, IngestResult, int, error) {
+		if outcome != nil {
+			cancel()
+			<-outcome
+		}
+		return false, IngestResult{}, status, err
+	}
+
+	var more bool
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			more = true
+			break
+		}
+		mh, err := parseIngestedMultihash(line)
+		if err != nil {
+			return abort(http.StatusBadRequest, err)
+		}
+		if mhs == nil {
+			mhs = make(chan multihash.Multihash, ingestBufferSize)
+			outcome = make(chan ingestOutcome, 1)
+			go func() {
+				receipt, err := h.publishIngested(ctx, NewChannelCatalog(ctx, id, mhs), appendEntries)
+				outcome <- ingestOutcome{receipt: receipt, err: err}
+			}()
+		}
+		select {
+		case mhs <- mh:
+		case o := <-outcome:
+			// The publish can only end before its multihashes are all sent
+			// if it failed.
+			outcome = nil
+			return abort(ingestStatus(o.err), o.err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return abort(http.StatusBadRequest, err)
+	}
+	if mhs == nil {
+		return more, IngestResult{}, 0, nil
+	}
+	close(mhs)
+	o := <-outcome
+	if o.err != nil {
+		return false, IngestResult{}, ingestStatus(o.err), o.err
+	}
+	return more, IngestResult{
+		Advertisement:  o.receipt.Advertisement.String(),
+		MultihashCount: o.receipt.MultihashCount,
+		ChunkCount:     o.receipt.ChunkCount,
+		FilteredCount:  o.receipt.FilteredCount,
+	}, 0, nil
+}
+
+func (h *Herald) publishIngested(ctx context.Context, catalog Catalog, appendEntries bool) (*PublishReceipt, error) {
+	if h.queue != nil {
+		return h.publishQueued(ctx, catalog, catalog.ID(), false, &publishOptions{appendEntries: appendEntries})
+	}
+	if err := h.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer h.limiter.release()
+	return h.publisher.PublishWithOptions(ctx, catalog, &publishOptions{appendEntries: appendEntries})
+}
+
+func parseIngestedMultihash(s string) (multihash.Multihash, error) {
+	if mh, err := multihash.FromB58String(s); err == nil {
+		return mh, nil
+	}
+	c, err := cid.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multihash or CID: %q", s)
+	}
+	return c.Hash(), nil
+}
+
+func ingestStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidAdvertisement),
+		errors.Is(err, ErrInvalidContextID),
+		errors.Is(err, ErrEntriesTooDeep),
+		errors.Is(err, ErrEntryChunkTooLarge),
+		errors.Is(err, ErrAdTooLarge):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
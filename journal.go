@@ -0,0 +1,84 @@
+package herald
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// journalPrefix is the namespace under which publishes in progress are
+// journaled, until they complete or fail.
+var journalPrefix = datastore.NewKey("journal")
+
+// journalEntry describes a publish in progress. Entries left over once Herald
+// is created anew are those of publishes interrupted by a crash, which may
+// have left entry chunks behind.
+type journalEntry struct {
+	ContextID []byte    `json:"contextID"`
+	Started   time.Time `json:"started"`
+	// QueueID is the ID of the queued publish being processed, if any.
+	QueueID string `json:"queueID,omitempty"`
+}
+
+// beginJournal journals the start of a publish, and returns the key of its
+// entry to be deleted once the publish ends.
+func (l *dsPublisher) beginJournal(ctx context.Context, id CatalogID, queueID string) (datastore.Key, error) {
+	var jid [16]byte
+	_, _ = rand.Read(jid[:])
+	key := journalPrefix.ChildString(hex.EncodeToString(jid[:]))
+	value, err := json.Marshal(&journalEntry{ContextID: id, Started: time.Now(), QueueID: queueID})
+	if err != nil {
+		return datastore.Key{}, err
+	}
+	return key, l.h.ds.Put(ctx, key, value)
+}
+
+// endJournal deletes the journal entry of a publish that completed or failed.
+// Chunks left behind by failed publishes are collected by OrphanBlocksGC.
+func (l *dsPublisher) endJournal(key datastore.Key) {
+	if err := l.h.ds.Delete(context.Background(), key); err != nil {
+		l.h.publisherLogger.Errorw("failed to delete publish journal entry", "key", key, "err", err)
+	}
+}
+
+// recoverInterruptedPublishes handles the publishes that were in progress when
+// a previous instance stopped abruptly. Those that are queued persistently
+// are resumed by the queue, reusing the chunks they already stored. Unless all
+// are, the chunks left behind are deleted by collecting orphan blocks, before
+// any publish is made.
+func (h *Herald) recoverInterruptedPublishes(ctx context.Context) error {
+	results, err := h.ds.Query(ctx, query.Query{Prefix: journalPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	resumed := true
+	for _, e := range entries {
+		var entry journalEntry
+		if err := json.Unmarshal(e.Value, &entry); err != nil {
+			return err
+		}
+		queued := entry.QueueID != "" && h.queue != nil && h.queue.isPending(entry.QueueID)
+		resumed = resumed && queued
+		h.logger.Warnw("Found publish interrupted by a previous instance", "contextID", catalogKeyString(entry.ContextID), "started", entry.Started, "resumed", queued)
+	}
+	if !resumed {
+		if err := collectOrphanBlocks(ctx, h); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := h.ds.Delete(ctx, datastore.RawKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
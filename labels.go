@@ -0,0 +1,85 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+var labelsPrefix = datastore.NewKey("labels")
+
+// WithLabels attaches the given key/value labels to the published
+// advertisement, e.g. to tag publishes by team, dataset or ticket. Labels are
+// kept in Herald's datastore for bookkeeping, and are not part of the
+// advertisement.
+func WithLabels(labels map[string]string) PublishOption {
+	return func(o *publishOptions) error {
+		for k := range labels {
+			if k == "" {
+				return errors.New("label keys must not be empty")
+			}
+		}
+		o.labels = labels
+		return nil
+	}
+}
+
+func labelsKey(ad cid.Cid) datastore.Key {
+	return labelsPrefix.ChildString(ad.String())
+}
+
+func (l *dsPublisher) putLabels(ctx context.Context, w datastore.Write, ad cid.Cid, labels map[string]string) error {
+	value, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	return w.Put(ctx, labelsKey(ad), value)
+}
+
+// Labels returns the labels attached to the given advertisement when it was
+// published, or nil if it has none.
+func (h *Herald) Labels(ctx context.Context, ad cid.Cid) (map[string]string, error) {
+	value, err := h.ds.Get(ctx, labelsKey(ad))
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	var labels map[string]string
+	if err := json.Unmarshal(value, &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// FindByLabels walks the chain from the head and returns the advertisements
+// that have all the given labels, most recent first.
+func (h *Herald) FindByLabels(ctx context.Context, selector map[string]string) ([]cid.Cid, error) {
+	p := h.publisher.dsPublisher
+	head, err := p.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found []cid.Cid
+	if err := p.walkChain(ctx, head, func(c cid.Cid, _ *schema.Advertisement) (bool, error) {
+		labels, err := h.Labels(ctx, c)
+		if err != nil {
+			return false, err
+		}
+		for k, v := range selector {
+			if actual, ok := labels[k]; !ok || actual != v {
+				return true, nil
+			}
+		}
+		found = append(found, c)
+		return true, nil
+	}); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
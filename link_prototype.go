@@ -0,0 +1,55 @@
+package herald
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	_ "github.com/ipld/go-ipld-prime/codec/dagjson"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multicodec"
+)
+
+type linkPrototypeKey struct{}
+
+// LinkPrototypeFromContext returns the link prototype with which entry
+// chunkers must store blocks, as configured via WithBlockCodec and
+// WithBlockHash, given the context passed to their EntryChunkerFactory.
+// Defaults to schema.Linkproto, i.e. dag-json blocks hashed with sha2-256.
+func LinkPrototypeFromContext(ctx context.Context) ipld.LinkPrototype {
+	if lp, ok := ctx.Value(linkPrototypeKey{}).(ipld.LinkPrototype); ok {
+		return lp
+	}
+	return schema.Linkproto
+}
+
+func withLinkPrototype(ctx context.Context, lp ipld.LinkPrototype) context.Context {
+	return context.WithValue(ctx, linkPrototypeKey{}, lp)
+}
+
+func newLinkPrototype(codec, hash multicodec.Code) (ipld.LinkPrototype, error) {
+	switch codec {
+	case multicodec.DagJson, multicodec.DagCbor:
+	default:
+		return nil, fmt.Errorf("unsupported block codec: %s", codec)
+	}
+	switch hash {
+	case multicodec.Sha2_256, multicodec.Blake3:
+	default:
+		return nil, fmt.Errorf("unsupported block hash function: %s", hash)
+	}
+	if codec == multicodec.DagJson && hash == multicodec.Sha2_256 {
+		return schema.Linkproto, nil
+	}
+	return cidlink.LinkPrototype{
+		Prefix: cid.Prefix{
+			Version:  1,
+			Codec:    uint64(codec),
+			MhType:   uint64(hash),
+			MhLength: -1,
+		},
+	}, nil
+}
@@ -0,0 +1,104 @@
+package herald
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+)
+
+var (
+	// defaultLoggers log via go-log, unless WithLogger or WithLogHandler is
+	// set. They are also used by catalogs, and by denylists and entry
+	// chunkers used outside of Herald, which are not tied to an instance.
+	defaultLoggers = newGoLogLoggers()
+
+	// subsystemLoggers maps the subsystems accepted by WithLogLevels to the
+	// names of their go-log loggers.
+	subsystemLoggers = map[string]string{
+		"herald":    "herald",
+		"publisher": "herald/publisher",
+		"announce":  "herald/announce",
+		"datastore": "herald/datastore",
+		"http":      "herald/http",
+	}
+)
+
+type (
+	// loggers are the loggers of each subsystem of a Herald instance.
+	loggers struct {
+		logger          *zap.SugaredLogger
+		publisherLogger *zap.SugaredLogger
+		announceLogger  *zap.SugaredLogger
+		datastoreLogger *zap.SugaredLogger
+		httpLogger      *zap.SugaredLogger
+		// base is the logger set via WithLogger or WithLogHandler, if any.
+		base *zap.Logger
+	}
+	loggersKey struct{}
+)
+
+// WithLogger routes the logs of Herald through the given logger rather than
+// go-log, such that their fields, levels and outputs are controlled by it.
+// Subsystems other than "herald" log through loggers named after them, e.g.
+// "publisher"; see WithLogLevels, which cannot be combined with it. It should
+// be given before options that log as they are applied, e.g.
+// WithIdentityFromFile.
+func WithLogger(l *zap.Logger) Option {
+	return func(o *options) error {
+		if l == nil {
+			return errors.New("logger must not be nil")
+		}
+		o.loggers = newZapLoggers(l)
+		return nil
+	}
+}
+
+// Logger returns the logger through which the given subsystem logs, e.g. for
+// packages extending Herald such as heraldgrpc to log alongside it. It is the
+// go-log logger named "herald/" followed by the subsystem, unless WithLogger
+// or WithLogHandler is set.
+func (h *Herald) Logger(subsystem string) *zap.Logger {
+	if h.loggers.base != nil {
+		return h.loggers.base.Named(subsystem)
+	}
+	return log.Logger("herald/" + subsystem).Desugar()
+}
+
+// loggersFromContext returns the loggers of the Herald instance by which the
+// given context is passed to an EntryChunkerFactory, or defaultLoggers.
+func loggersFromContext(ctx context.Context) loggers {
+	if l, ok := ctx.Value(loggersKey{}).(loggers); ok {
+		return l
+	}
+	return defaultLoggers
+}
+
+func withLoggers(ctx context.Context, l loggers) context.Context {
+	return context.WithValue(ctx, loggersKey{}, l)
+}
+
+func newGoLogLoggers() loggers {
+	named := func(subsystem string) *zap.SugaredLogger {
+		return &log.Logger(subsystemLoggers[subsystem]).SugaredLogger
+	}
+	return loggers{
+		logger:          named("herald"),
+		publisherLogger: named("publisher"),
+		announceLogger:  named("announce"),
+		datastoreLogger: named("datastore"),
+		httpLogger:      named("http"),
+	}
+}
+
+func newZapLoggers(l *zap.Logger) loggers {
+	return loggers{
+		logger:          l.Sugar(),
+		publisherLogger: l.Named("publisher").Sugar(),
+		announceLogger:  l.Named("announce").Sugar(),
+		datastoreLogger: l.Named("datastore").Sugar(),
+		httpLogger:      l.Named("http").Sugar(),
+		base:            l,
+	}
+}
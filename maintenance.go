@@ -0,0 +1,190 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+var (
+	// DatastoreGC runs the garbage collection of datastores that support
+	// it, such as badger value log GC.
+	DatastoreGC = MaintenanceTask{Name: "datastore-gc", Run: collectDatastoreGarbage}
+	// OrphanBlocksGC deletes the blocks that are not reachable from the head,
+	// such as those left behind by failed publishes or pruned by rollbacks.
+	// Publishes wait for it to complete.
+	OrphanBlocksGC = MaintenanceTask{Name: "orphan-blocks-gc", Run: collectOrphanBlocks}
+)
+
+type (
+	// MaintenanceTask is a unit of datastore upkeep run periodically by
+	// Herald. See WithMaintenance.
+	MaintenanceTask struct {
+		Name string
+		Run  func(context.Context, *Herald) error
+	}
+
+	maintenance struct {
+		interval time.Duration
+		tasks    []MaintenanceTask
+		// windowStart and windowEnd are offsets from local midnight that
+		// bound the quiet hours in which tasks run, where an end before the
+		// start spans midnight. Tasks run at any time if both are zero.
+		windowStart, windowEnd time.Duration
+		cancel                 context.CancelFunc
+		done                   chan struct{}
+	}
+)
+
+// RunMaintenance runs the maintenance tasks set by WithMaintenance once, in
+// order, regardless of quiet hours.
+func (h *Herald) RunMaintenance(ctx context.Context) error {
+	if h.maintenance == nil {
+		return nil
+	}
+	var errs []error
+	for _, task := range h.maintenance.tasks {
+		start := time.Now()
+		if err := task.Run(ctx, h); err != nil {
+			h.datastoreLogger.Errorw("maintenance task failed", "task", task.Name, "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		h.datastoreLogger.Infow("Completed maintenance task", "task", task.Name, "took", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *maintenance) inWindow(now time.Time) bool {
+	if m.windowStart == 0 && m.windowEnd == 0 {
+		return true
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	if m.windowStart <= m.windowEnd {
+		return offset >= m.windowStart && offset < m.windowEnd
+	}
+	return offset >= m.windowStart || offset < m.windowEnd
+}
+
+func (m *maintenance) start(ctx context.Context, h *Herald) {
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if !m.inWindow(now) {
+					h.datastoreLogger.Debugw("skipping maintenance outside of quiet hours")
+					continue
+				}
+				_ = h.RunMaintenance(ctx)
+			}
+		}
+	}()
+}
+
+func (m *maintenance) stop() {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+	}
+}
+
+func collectDatastoreGarbage(ctx context.Context, h *Herald) error {
+	for _, ds := range h.datastores() {
+		if gcds, ok := ds.(datastore.GCDatastore); ok {
+			if err := gcds.CollectGarbage(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func collectOrphanBlocks(ctx context.Context, h *Herald) error {
+	p := h.publisher.dsPublisher
+	p.gcLocker.Lock()
+	defer p.gcLocker.Unlock()
+
+	head, err := p.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	reachable := make(map[string]struct{})
+	if err := p.walkChain(ctx, head, func(c cid.Cid, ad *schema.Advertisement) (bool, error) {
+		reachable[dsKey(cidlink.Link{Cid: c}).String()] = struct{}{}
+		if !hasEntries(ad.Entries) {
+			return true, nil
+		}
+		for queue := []cid.Cid{ad.Entries.(cidlink.Link).Cid}; len(queue) != 0; queue = queue[1:] {
+			next := queue[0]
+			key := dsKey(cidlink.Link{Cid: next})
+			if _, seen := reachable[key.String()]; seen {
+				continue
+			}
+			reachable[key.String()] = struct{}{}
+			data, err := p.entriesDs.Get(ctx, key)
+			switch {
+			case errors.Is(err, datastore.ErrNotFound):
+				// Entries of retracted catalogs may have been deleted.
+				continue
+			case err != nil:
+				return false, err
+			}
+			links, err := blockLinks(next, data)
+			if err != nil {
+				return false, err
+			}
+			queue = append(queue, links...)
+		}
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	var deleted int
+	for _, ds := range h.datastores() {
+		results, err := ds.Query(ctx, query.Query{Prefix: blocksPrefix.String(), KeysOnly: true})
+		if err != nil {
+			return err
+		}
+		var orphans []datastore.Key
+		for r := range results.Next() {
+			if r.Error != nil {
+				_ = results.Close()
+				return r.Error
+			}
+			if _, ok := reachable[r.Key]; !ok {
+				orphans = append(orphans, datastore.RawKey(r.Key))
+			}
+		}
+		_ = results.Close()
+		for _, key := range orphans {
+			if err := ds.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		deleted += len(orphans)
+	}
+	h.datastoreLogger.Infow("Collected orphan blocks", "reachable", len(reachable), "deleted", deleted)
+	return nil
+}
+
+// datastores returns the distinct datastores used by Herald.
+func (h *Herald) datastores() []datastore.Datastore {
+	if h.entriesDs != nil {
+		return []datastore.Datastore{h.ds, h.entriesDs}
+	}
+	return []datastore.Datastore{h.ds}
+}
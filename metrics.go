@@ -0,0 +1,135 @@
+package herald
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors registered via WithMetrics. Its
+// methods are no-ops on a nil receiver, i.e. when metrics are disabled.
+type metrics struct {
+	adsPublished    *prometheus.CounterVec
+	adMultihashes   prometheus.Histogram
+	entriesDuration prometheus.Histogram
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+	httpThrottled   *prometheus.CounterVec
+	announcements   *prometheus.CounterVec
+	signedHeads     *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) (*metrics, error) {
+	m := &metrics{
+		adsPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "herald",
+			Name:      "ads_published_total",
+			Help:      "Number of advertisements published, by kind: publish or retract.",
+		}, []string{"kind"}),
+		adMultihashes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "herald",
+			Name:      "ad_multihashes",
+			Help:      "Number of multihashes chunked as the entries of a published advertisement.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 12),
+		}),
+		entriesDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "herald",
+			Name:      "entries_generation_duration_seconds",
+			Help:      "Time taken to generate the entries of a published advertisement.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "herald",
+			Name:      "http_requests_total",
+			Help:      "Number of requests served by the publisher, by endpoint and status code.",
+		}, []string{"endpoint", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "herald",
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve requests to the publisher, by endpoint.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+		httpThrottled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "herald",
+			Name:      "http_requests_throttled_total",
+			Help:      "Number of requests to the publisher rejected by rate limits, by endpoint and scope: global or client.",
+		}, []string{"endpoint", "scope"}),
+		announcements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "herald",
+			Name:      "announcements_total",
+			Help:      "Number of head announcements, by announcer and result: success or failure.",
+		}, []string{"announcer", "result"}),
+		signedHeads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "herald",
+			Name:      "signed_heads_served_total",
+			Help:      "Number of signed heads served by the publisher, by cache result: hit or miss.",
+		}, []string{"cache"}),
+	}
+	for _, c := range []prometheus.Collector{m.adsPublished, m.adMultihashes, m.entriesDuration, m.httpRequests, m.httpDuration, m.httpThrottled, m.announcements, m.signedHeads} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *metrics) observePublished(isRm bool) {
+	if m == nil {
+		return
+	}
+	kind := "publish"
+	if isRm {
+		kind = "retract"
+	}
+	m.adsPublished.WithLabelValues(kind).Inc()
+}
+
+func (m *metrics) observeEntries(receipt *PublishReceipt, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.adMultihashes.Observe(float64(receipt.MultihashCount))
+	m.entriesDuration.Observe(took.Seconds())
+}
+
+func (m *metrics) observeThrottled(endpoint, scope string) {
+	if m == nil {
+		return
+	}
+	m.httpThrottled.WithLabelValues(endpoint, scope).Inc()
+}
+
+func (m *metrics) observeAnnounced(announcer string, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.announcements.WithLabelValues(announcer, result).Inc()
+}
+
+func (m *metrics) observeSignedHeadCache(hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.signedHeads.WithLabelValues(result).Inc()
+}
+
+// instrument wraps the handler of the given publisher endpoint to count its
+// requests by status code and observe their latency.
+func (m *metrics) instrument(endpoint string, h http.HandlerFunc) http.Handler {
+	if m == nil {
+		return h
+	}
+	labels := prometheus.Labels{"endpoint": endpoint}
+	return promhttp.InstrumentHandlerDuration(m.httpDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerCounter(m.httpRequests.MustCurryWith(labels), h))
+}
@@ -0,0 +1,63 @@
+package herald
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// validateProviderAddrs checks that the given provider addresses are valid
+// multiaddrs, at least one of which is routable, i.e. neither loopback,
+// unspecified nor link-local, unless permissive.
+func validateProviderAddrs(addrs []string, permissive bool) error {
+	if len(addrs) == 0 {
+		return errors.New("at least one provider address must be set")
+	}
+	var routable bool
+	for _, s := range addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return fmt.Errorf("invalid provider address %q: %w", s, err)
+		}
+		routable = routable || isRoutable(addr)
+	}
+	if !routable && !permissive {
+		return fmt.Errorf("no provider address is routable: %v; set a reachable address, or WithPermissiveValidation for testing", addrs)
+	}
+	return nil
+}
+
+func isRoutable(addr multiaddr.Multiaddr) bool {
+	if manet.IsIPLoopback(addr) || manet.IsIPUnspecified(addr) || manet.IsIP6LinkLocal(addr) {
+		return false
+	}
+	// Link-local IPv4 addresses are not checked by manet.
+	if ip, err := manet.ToIP(addr); err == nil && ip.IsLinkLocalUnicast() {
+		return false
+	}
+	return true
+}
+
+// validateMetadata checks that the given metadata decodes to at least one
+// transport protocol, all of which are known unless permissive.
+func validateMetadata(data []byte, permissive bool) error {
+	md := metadata.Default.New()
+	if err := md.UnmarshalBinary(data); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	if md.Len() == 0 {
+		return errors.New("metadata has no transport protocol")
+	}
+	if permissive {
+		return nil
+	}
+	for _, code := range md.Protocols() {
+		if _, ok := md.Get(code).(*metadata.Unknown); ok {
+			return fmt.Errorf("metadata has unknown transport protocol %s; use WithPermissiveValidation to allow it", code)
+		}
+	}
+	return nil
+}
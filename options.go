@@ -1,21 +1,41 @@
 package herald
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-log/v2"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/ingest/schema"
 	"github.com/ipni/go-libipni/metadata"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
 	Option  func(*options) error
 	options struct {
 		httpPublisherListenAddr string
+		httpPublisherPathPrefix string
 		topic                   string
 		id                      peer.ID
 		identity                crypto.PrivKey
@@ -23,30 +43,192 @@ type (
 		localPublisherDir       string
 		adEntriesChunkSize      int
 		ds                      datastore.Datastore
+		entriesDs               datastore.Datastore
 		metadata                []byte
+		contentFilter           func(multihash.Multihash) bool
+		badbits                 *Badbits
+		maxAdSize               int
+		maxEntryChunkSize       int
+		maxEntriesDepth         int
+		entriesBatchSize        int
+		maxPendingPublishes     int
+		blockWhenBusy           bool
+		instrumentDatastore     bool
+		trustedProxies          []netip.Prefix
+		logLevels               map[string]string
+		entryChunker            EntryChunkerFactory
+		syncBeforeHead          bool
+		recorderDs              datastore.Datastore
+		maintenance             *maintenance
+		validateOnPublish       bool
+		contentCacheMaxAge      time.Duration
+		listener                net.Listener
+		maxChunkAge             time.Duration
+		// httpTransport and libp2pHost select the transports over which the
+		// chain is published. See WithPublisherTransport.
+		httpTransport bool
+		libp2pHost    host.Host
+		blobStore     BlobStore
+		// metricsRegisterer, when set, enables Prometheus metrics.
+		metricsRegisterer prometheus.Registerer
+		tracerProvider    trace.TracerProvider
+		// gossipsubHost, when set, enables announcing head updates over
+		// gossipsub, optionally via pubsub.
+		gossipsubHost  host.Host
+		pubsub         *pubsub.PubSub
+		publisherAddrs []multiaddr.Multiaddr
+		// retractedEntriesRetention is negative when the entries of retracted
+		// catalogs are kept.
+		retractedEntriesRetention time.Duration
+		// chainMaxLength and chainMaxAge limit the advertisement chain, where
+		// zero means no limit. See WithChainPruning.
+		chainMaxLength int
+		chainMaxAge    time.Duration
+		// publishQueue enables the publish queue, which is persisted in
+		// publishQueueDs when set. See WithPublishQueue.
+		publishQueue   bool
+		publishQueueDs datastore.Datastore
+		// adminListenAddr, when set, enables the admin server, which requires
+		// adminToken. See WithAdminServer.
+		adminListenAddr string
+		adminToken      string
+		// providerID is the provider of advertisements, which defaults to id.
+		// See WithProviderID.
+		providerID peer.ID
+		// permissiveValidation relaxes the validation of provider addresses
+		// and metadata. See WithPermissiveValidation.
+		permissiveValidation bool
+		// httpReadTimeout, httpWriteTimeout, httpIdleTimeout,
+		// httpMaxHeaderBytes and httpMaxConns configure the HTTP publisher
+		// server, where zero means no limit. See WithHttpServerTimeouts.
+		httpReadTimeout    time.Duration
+		httpWriteTimeout   time.Duration
+		httpIdleTimeout    time.Duration
+		httpMaxHeaderBytes int
+		httpMaxConns       int
+		// rateLimit and clientRateLimit are the requests per second allowed
+		// to the head and content endpoints in total and per client, where
+		// zero means no limit. See WithRateLimit.
+		rateLimit       float64
+		rateBurst       int
+		clientRateLimit float64
+		clientRateBurst int
+		headCacheMaxAge time.Duration
+		compression     bool
+		// blockCodec and blockHash make up linkPrototype, with which ads
+		// and entry chunks are stored. See WithBlockCodec.
+		blockCodec    multicodec.Code
+		blockHash     multicodec.Code
+		linkPrototype ipld.LinkPrototype
+		// publishInterval, when positive, is the window within which
+		// publishes of the same catalog are coalesced.
+		publishInterval time.Duration
+		// providers are registered in addition to providerID. See
+		// WithProvider.
+		providers []Provider
+		// httpAnnounceURLs and extraAnnouncers are announced to in addition
+		// to gossipsub. See WithHttpAnnouncer and WithAnnouncer.
+		httpAnnounceURLs []*url.URL
+		extraAnnouncers  []Announcer
+		announceOnStart  bool
+		// reannounceInterval, when positive, is the interval at which the
+		// current head is re-announced.
+		reannounceInterval time.Duration
+		// announceTimeout, when positive, bounds each announcer when
+		// announcing a head. See WithAnnounceTimeout.
+		announceTimeout time.Duration
+		// maxContextIDLen and contextIDNamespace constrain the IDs of
+		// catalogs. See validateContextID.
+		maxContextIDLen    int
+		contextIDNamespace []byte
+		dryRun             bool
+		// loggers are those of each subsystem. See WithLogger.
+		loggers
+		// httpMiddleware and corsOrigins wrap the handler of the HTTP
+		// publisher. See WithHttpMiddleware and WithCors.
+		httpMiddleware []func(http.Handler) http.Handler
+		corsOrigins    []string
+		// httpPublisherListenMultiaddr, when set, takes precedence over
+		// httpPublisherListenAddr. See WithHttpPublisherListenMultiaddr.
+		httpPublisherListenMultiaddr multiaddr.Multiaddr
+		// httpHandlerTransport is set when the chain is served via
+		// PublisherHandler only. See HttpHandlerTransport.
+		httpHandlerTransport bool
 	}
+	// PublisherTransport is a transport over which the advertisement chain is
+	// served to indexers. See WithPublisherTransport.
+	PublisherTransport func(*options) error
 )
 
 func newOptions(o ...Option) (*options, error) {
 	opts := options{
-		httpPublisherListenAddr: "0.0.0.0:40080",
-		topic:                   "/indexer/ingest/mainnet",
-		providerAddrs:           nil,
-		adEntriesChunkSize:      16 << 10,
+		httpPublisherListenAddr:   "0.0.0.0:40080",
+		topic:                     "/indexer/ingest/mainnet",
+		providerAddrs:             nil,
+		adEntriesChunkSize:        16 << 10,
+		maxAdSize:                 1 << 20,
+		maxEntryChunkSize:         4 << 20,
+		maxEntriesDepth:           64 << 10,
+		entriesBatchSize:          16,
+		contentCacheMaxAge:        365 * 24 * time.Hour,
+		httpTransport:             true,
+		retractedEntriesRetention: -1,
+		tracerProvider:            otel.GetTracerProvider(),
+		httpReadTimeout:           30 * time.Second,
+		httpWriteTimeout:          time.Minute,
+		httpIdleTimeout:           2 * time.Minute,
+		httpMaxHeaderBytes:        16 << 10,
+		headCacheMaxAge:           5 * time.Second,
+		announceTimeout:           30 * time.Second,
+		compression:               true,
+		blockCodec:                multicodec.DagJson,
+		blockHash:                 multicodec.Sha2_256,
+		maxContextIDLen:           schema.MaxContextIDLen,
+		loggers:                   defaultLoggers,
 	}
 	for _, apply := range o {
 		if err := apply(&opts); err != nil {
 			return nil, err
 		}
 	}
+	if opts.dryRun {
+		opts.applyDryRun()
+	}
+	if opts.httpHandlerTransport && !opts.httpTransport && opts.libp2pHost == nil && len(opts.publisherAddrs) == 0 &&
+		(opts.gossipsubHost != nil || len(opts.httpAnnounceURLs) != 0) {
+		return nil, errors.New("publisher addresses must be set via WithPublisherAddrs to announce a chain served only via PublisherHandler")
+	}
+	if opts.httpTransport && opts.httpPublisherPathPrefix != "" && len(opts.publisherAddrs) == 0 &&
+		(opts.gossipsubHost != nil || len(opts.httpAnnounceURLs) != 0) {
+		return nil, errors.New("publisher addresses must be set via WithPublisherAddrs to announce a chain served under an HTTP path prefix")
+	}
+	if opts.logLevels != nil && opts.loggers.base != nil {
+		return nil, errors.New("log levels cannot be set along with a logger")
+	}
 	if opts.metadata == nil {
 		return nil, errors.New("metadata must be set")
 	}
-	if opts.providerAddrs == nil {
-		return nil, errors.New("at least one provider address must be set")
+	if err := validateMetadata(opts.metadata, opts.permissiveValidation); err != nil {
+		return nil, err
+	}
+	if err := validateProviderAddrs(opts.providerAddrs, opts.permissiveValidation); err != nil {
+		return nil, err
 	}
-	if opts.identity == nil {
-		logger.Warnw("no identity is specified; generating one at random...")
+	switch {
+	case opts.identity != nil:
+	case opts.ds != nil:
+		// The identity is persisted in the datastore, such that the chain
+		// keeps being signed by the same key across restarts.
+		var err error
+		if opts.identity, err = persistedIdentity(context.Background(), opts.ds, opts.logger); err != nil {
+			return nil, fmt.Errorf("failed to load identity from datastore: %w", err)
+		}
+		if opts.id, err = peer.IDFromPrivateKey(opts.identity); err != nil {
+			return nil, err
+		}
+		opts.logger.Infow("using identity persisted in datastore", "peerID", opts.id)
+	default:
+		opts.logger.Warnw("no identity is specified; generating one at random...")
 		var err error
 		opts.identity, _, err = crypto.GenerateEd25519Key(rand.Reader)
 		if err != nil {
@@ -56,10 +238,25 @@ func newOptions(o ...Option) (*options, error) {
 		if err != nil {
 			return nil, err
 		}
-		logger.Infow("using randomly generated identity", "peerID", opts.id)
+		opts.logger.Infow("using randomly generated identity", "peerID", opts.id)
+	}
+	if opts.providerID == "" {
+		opts.providerID = opts.id
+	}
+	for _, h := range []host.Host{opts.libp2pHost, opts.gossipsubHost} {
+		if h != nil && h.ID() != opts.id {
+			return nil, fmt.Errorf("libp2p host ID %s does not match identity %s", h.ID(), opts.id)
+		}
+	}
+	var err error
+	if opts.linkPrototype, err = newLinkPrototype(opts.blockCodec, opts.blockHash); err != nil {
+		return nil, err
+	}
+	if opts.entryChunker == nil {
+		opts.entryChunker = LinkedEntryChunkerWithMaxAge(opts.adEntriesChunkSize, opts.maxEntriesDepth, opts.maxChunkAge)
 	}
 	if opts.ds == nil {
-		logger.Warnw("using in-memory datastore")
+		opts.logger.Warnw("using in-memory datastore")
 		opts.ds = sync.MutexWrap(datastore.NewMapDatastore())
 	}
 	return &opts, nil
@@ -72,6 +269,103 @@ func WithHttpPublisherListenAddr(v string) Option {
 	}
 }
 
+// WithHttpServerTimeouts sets the maximum durations for reading an entire
+// request, writing its response and keeping an idle connection open on the
+// HTTP publisher, so that slow clients cannot hold on to connections
+// indefinitely. Zero disables a timeout. Defaults to 30s, 1m and 2m.
+func WithHttpServerTimeouts(read, write, idle time.Duration) Option {
+	return func(o *options) error {
+		if read < 0 || write < 0 || idle < 0 {
+			return errors.New("HTTP server timeouts must not be negative")
+		}
+		o.httpReadTimeout = read
+		o.httpWriteTimeout = write
+		o.httpIdleTimeout = idle
+		return nil
+	}
+}
+
+// WithHttpServerMaxHeaderBytes sets the maximum size of request headers read
+// by the HTTP publisher. Zero uses http.DefaultMaxHeaderBytes. Defaults to
+// 16 KiB.
+func WithHttpServerMaxHeaderBytes(v int) Option {
+	return func(o *options) error {
+		if v < 0 {
+			return errors.New("HTTP server max header bytes must not be negative")
+		}
+		o.httpMaxHeaderBytes = v
+		return nil
+	}
+}
+
+// WithHttpServerMaxConns limits the number of connections served concurrently
+// by the HTTP publisher, beyond which new connections wait to be accepted.
+// Zero, the default, means no limit.
+func WithHttpServerMaxConns(v int) Option {
+	return func(o *options) error {
+		if v < 0 {
+			return errors.New("HTTP server max connections must not be negative")
+		}
+		o.httpMaxConns = v
+		return nil
+	}
+}
+
+// WithRateLimit limits the requests to the head and content endpoints of the
+// publisher to rps per second in total, with bursts of up to burst requests,
+// so that syncers cannot saturate the datastore. Requests beyond the limit
+// are rejected with 429 Too Many Requests. Zero rps, the default, means no
+// limit. See WithClientRateLimit.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(o *options) error {
+		if rps < 0 {
+			return errors.New("rate limit must not be negative")
+		}
+		if rps > 0 && burst < 1 {
+			return errors.New("rate limit burst must be at least 1")
+		}
+		o.rateLimit = rps
+		o.rateBurst = burst
+		return nil
+	}
+}
+
+// WithClientRateLimit limits the requests to the head and content endpoints of
+// the publisher to rps per second per client address, as resolved according
+// to WithTrustedProxies, with bursts of up to burst requests. Zero rps, the
+// default, means no limit.
+func WithClientRateLimit(rps float64, burst int) Option {
+	return func(o *options) error {
+		if rps < 0 {
+			return errors.New("client rate limit must not be negative")
+		}
+		if rps > 0 && burst < 1 {
+			return errors.New("client rate limit burst must be at least 1")
+		}
+		o.clientRateLimit = rps
+		o.clientRateBurst = burst
+		return nil
+	}
+}
+
+// WithHttpPublisherPathPrefix mounts the routes of the HTTP publisher under
+// the given URL path prefix, e.g. "/ipni/", so that it can be served behind
+// path-based routing on a shared ingress. Defaults to no prefix.
+//
+// The listen addresses of the HTTP publisher cannot express the prefix, and
+// so are neither announced nor returned by AddrInfo while it is set. The
+// addresses at which the prefixed chain is reachable must instead be set via
+// WithPublisherAddrs in order to announce it.
+func WithHttpPublisherPathPrefix(v string) Option {
+	return func(o *options) error {
+		if v != "" && !strings.HasPrefix(v, "/") {
+			return fmt.Errorf("HTTP publisher path prefix must start with a slash: %q", v)
+		}
+		o.httpPublisherPathPrefix = strings.TrimSuffix(v, "/")
+		return nil
+	}
+}
+
 func WithTopic(v string) Option {
 	return func(o *options) error {
 		o.topic = v
@@ -100,6 +394,43 @@ func WithProviderAddress(a ...multiaddr.Multiaddr) Option {
 	}
 }
 
+// WithProviderID sets the provider of advertisements to the given peer ID,
+// when content is provided by a peer other than the publisher. Advertisements
+// are still signed by the publisher identity, which indexers must then allow
+// to publish on behalf of the provider. Defaults to the publisher identity.
+func WithProviderID(id peer.ID) Option {
+	return func(o *options) error {
+		if err := id.Validate(); err != nil {
+			return fmt.Errorf("invalid provider ID: %w", err)
+		}
+		o.providerID = id
+		return nil
+	}
+}
+
+// WithProvider registers providers on whose behalf catalogs are published in
+// addition to the default provider. See AddProvider.
+func WithProvider(p ...Provider) Option {
+	return func(o *options) error {
+		o.providers = append(o.providers, p...)
+		return nil
+	}
+}
+
+// WithPermissiveValidation accepts provider addresses none of which is
+// routable, such as loopback addresses, and metadata with unknown transport
+// protocols, e.g. for testing. Addresses and metadata must still decode.
+// Disabled by default.
+func WithPermissiveValidation(v bool) Option {
+	return func(o *options) error {
+		o.permissiveValidation = v
+		return nil
+	}
+}
+
+// WithLocalPublisherDir writes the advertisement chain as flat files under
+// the given directory, in addition to the transports set via
+// WithPublisherTransport. See FilesystemTransport.
 func WithLocalPublisherDir(v string) Option {
 	return func(o *options) error {
 		o.localPublisherDir = v
@@ -121,6 +452,16 @@ func WithDatastore(v datastore.Datastore) Option {
 	}
 }
 
+// WithEntriesDatastore stores advertisement entry chunks in the given
+// datastore, separately from advertisements and the head which are stored in
+// the datastore set by WithDatastore. Defaults to the same datastore.
+func WithEntriesDatastore(v datastore.Datastore) Option {
+	return func(o *options) error {
+		o.entriesDs = v
+		return nil
+	}
+}
+
 func WithMetadata(v metadata.Metadata) Option {
 	return func(o *options) error {
 		var err error
@@ -128,3 +469,596 @@ func WithMetadata(v metadata.Metadata) Option {
 		return err
 	}
 }
+
+// WithContentFilter excludes multihashes for which f returns false from the
+// entries of every publish. The multihashes passed to f must not be retained.
+// See Denylist.
+func WithContentFilter(f func(multihash.Multihash) bool) Option {
+	return func(o *options) error {
+		o.contentFilter = f
+		return nil
+	}
+}
+
+// WithBadbits excludes content denied by the given badbits denylist from
+// publishes. The denylist is refreshed periodically while Herald is started.
+func WithBadbits(v *Badbits) Option {
+	return func(o *options) error {
+		o.badbits = v
+		return nil
+	}
+}
+
+// WithMaxAdSize sets the maximum encoded size of advertisements in bytes.
+// Publishes that would exceed it fail with ErrAdTooLarge. A non-positive value
+// disables the limit. Defaults to 1 MiB.
+func WithMaxAdSize(v int) Option {
+	return func(o *options) error {
+		o.maxAdSize = v
+		return nil
+	}
+}
+
+// WithMaxEntryChunkSize sets the maximum encoded size of entry chunks in bytes.
+// Publishes that would exceed it fail with ErrEntryChunkTooLarge. A
+// non-positive value disables the limit. Defaults to 4 MiB.
+func WithMaxEntryChunkSize(v int) Option {
+	return func(o *options) error {
+		o.maxEntryChunkSize = v
+		return nil
+	}
+}
+
+// WithEntriesBatchSize sets the number of entry chunks written to the entries
+// datastore per batch, when it implements datastore.Batching, such that
+// backends like leveldb and badger write chunks in bulk rather than one by one.
+// Chunks pending in a batch are held in memory, and all are committed before
+// the advertisement linking to them is stored. A value less than 2 disables
+// batching. Defaults to 16.
+func WithEntriesBatchSize(v int) Option {
+	return func(o *options) error {
+		o.entriesBatchSize = v
+		return nil
+	}
+}
+
+// WithMaxEntriesDepth sets the maximum number of chunks in the entries of an
+// advertisement. Publishes that would exceed it fail with ErrEntriesTooDeep. A
+// non-positive value disables the limit. Defaults to 65536.
+func WithMaxEntriesDepth(v int) Option {
+	return func(o *options) error {
+		o.maxEntriesDepth = v
+		return nil
+	}
+}
+
+// WithMaxPendingPublishes limits the number of publishes and retractions that
+// may be in progress at once. Once reached, further calls fail with ErrBusy,
+// or block if WithBlockWhenBusy is set. A non-positive value disables the
+// limit, which is the default.
+func WithMaxPendingPublishes(v int) Option {
+	return func(o *options) error {
+		o.maxPendingPublishes = v
+		return nil
+	}
+}
+
+// WithBlockWhenBusy makes publishes wait for a pending one to complete instead
+// of failing with ErrBusy when the limit set by WithMaxPendingPublishes is
+// reached. Waiting is bound by the publish context.
+func WithBlockWhenBusy(v bool) Option {
+	return func(o *options) error {
+		o.blockWhenBusy = v
+		return nil
+	}
+}
+
+// WithDatastoreInstrumentation records the count, latency and errors of every
+// operation performed on the datastores, retrievable via
+// Herald.DatastoreStats. Disabled by default.
+func WithDatastoreInstrumentation(v bool) Option {
+	return func(o *options) error {
+		o.instrumentDatastore = v
+		return nil
+	}
+}
+
+// WithTrustedProxies sets the addresses, in CIDR notation, of reverse proxies
+// whose Forwarded and X-Forwarded-For headers are trusted to identify the
+// clients of the HTTP publisher. Bare IP addresses are accepted as single
+// address prefixes. By default no proxy is trusted, and clients are identified
+// by the remote address of their connection.
+func WithTrustedProxies(cidrs ...string) Option {
+	return func(o *options) error {
+		o.trustedProxies = make([]netip.Prefix, 0, len(cidrs))
+		for _, v := range cidrs {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				addr, aerr := netip.ParseAddr(v)
+				if aerr != nil {
+					return fmt.Errorf("invalid trusted proxy %q: %w", v, err)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			o.trustedProxies = append(o.trustedProxies, prefix.Masked())
+		}
+		return nil
+	}
+}
+
+// WithLogLevels sets the log level of individual subsystems, keyed by
+// subsystem: "publisher" for catalog publishing and entries chunking,
+// "announce" for announcements, "datastore" for datastore management, "http"
+// for the HTTP publisher, and "herald" for everything else. Levels are those
+// accepted by go-log, e.g. "debug" or "warn". Since go-log loggers are global,
+// the levels apply to every Herald instance in the process that does not set
+// WithLogger or WithLogHandler.
+func WithLogLevels(levels map[string]string) Option {
+	return func(o *options) error {
+		for subsystem, level := range levels {
+			if _, ok := subsystemLoggers[subsystem]; !ok {
+				return fmt.Errorf("unknown log subsystem: %q", subsystem)
+			}
+			if _, err := log.LevelFromString(level); err != nil {
+				return fmt.Errorf("invalid log level for subsystem %s: %w", subsystem, err)
+			}
+		}
+		o.logLevels = levels
+		return nil
+	}
+}
+
+// WithEntryChunker sets the strategy by which the multihashes of catalogs are
+// laid out as advertisement entries. Defaults to LinkedEntryChunkerWithMaxAge
+// with the chunk size set by WithAdEntriesChunkSize, the depth limit set by
+// WithMaxEntriesDepth and the age set by WithMaxChunkAge, which are otherwise
+// ignored.
+func WithEntryChunker(f EntryChunkerFactory) Option {
+	return func(o *options) error {
+		o.entryChunker = f
+		return nil
+	}
+}
+
+// WithSyncBeforeHead syncs the advertisement and entries blocks to durable
+// storage before every head update, so that the head is never served, or
+// announced, before the blocks it links to can be. This prevents indexers from
+// failing to fetch entries after a crash, at the cost of a sync per publish.
+// Disabled by default.
+func WithSyncBeforeHead(v bool) Option {
+	return func(o *options) error {
+		o.syncBeforeHead = v
+		return nil
+	}
+}
+
+// WithPublishRecorder records every publish and retraction in the given
+// datastore, including the multihashes chunked as entries, so that the chain
+// can be rebuilt identically using Herald.Replay if Herald's datastore is
+// lost. The datastore should not be shared with Herald's. Disabled by default.
+func WithPublishRecorder(ds datastore.Datastore) Option {
+	return func(o *options) error {
+		o.recorderDs = ds
+		return nil
+	}
+}
+
+// WithPublishQueue processes publishes and retractions one at a time, in the
+// order they are made, via a queue to which EnqueuePublish and EnqueueRetract
+// add without waiting. Publish, PublishWithOptions, PublishAppend, Retract and
+// the ingest endpoint then queue as well, and wait for the outcome. Queued
+// publishes count towards WithMaxPendingPublishes until processed.
+//
+// When ds is not nil, queued publishes are persisted in it along with their
+// multihashes, so that those interrupted by a restart are processed once
+// Herald is created anew; a publish is then dropped once processed, even if
+// it failed. The datastore should not be shared with Herald's. Disabled by
+// default.
+func WithPublishQueue(ds datastore.Datastore) Option {
+	return func(o *options) error {
+		o.publishQueue = true
+		o.publishQueueDs = ds
+		return nil
+	}
+}
+
+// WithPublishInterval coalesces the catalogs passed to Publish with the same
+// ID within d of the first into a single advertisement of the union of their
+// multihashes, which is published once d has elapsed, so that chatty upstream
+// systems do not grow the chain with an advertisement per update. Publish
+// then blocks until the merged catalog is published. Pending multihashes are
+// held in memory, and published right away on Shutdown. Zero, the default,
+// publishes every catalog on its own.
+func WithPublishInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("publish interval must not be negative")
+		}
+		o.publishInterval = d
+		return nil
+	}
+}
+
+// WithAdminServer serves the admin API on a separate listener at the given
+// address, authenticating requests by the given bearer token. See
+// AdminHandler.
+func WithAdminServer(listenAddr, token string) Option {
+	return func(o *options) error {
+		if token == "" {
+			return errors.New("admin token must not be empty")
+		}
+		o.adminListenAddr = listenAddr
+		o.adminToken = token
+		return nil
+	}
+}
+
+// WithMaintenance runs the given maintenance tasks, e.g. DatastoreGC and
+// OrphanBlocksGC, in order at the given interval once Herald is started.
+// Disabled by default.
+func WithMaintenance(interval time.Duration, tasks ...MaintenanceTask) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return errors.New("maintenance interval must be positive")
+		}
+		if o.maintenance == nil {
+			o.maintenance = &maintenance{}
+		}
+		o.maintenance.interval, o.maintenance.tasks = interval, tasks
+		return nil
+	}
+}
+
+// WithMaintenanceWindow restricts scheduled maintenance to quiet hours,
+// starting and ending at the given offsets from local midnight. A window
+// whose end is before its start spans midnight.
+func WithMaintenanceWindow(start, end time.Duration) Option {
+	return func(o *options) error {
+		const day = 24 * time.Hour
+		if start < 0 || start >= day || end < 0 || end >= day || start == end {
+			return fmt.Errorf("invalid maintenance window: %s to %s", start, end)
+		}
+		if o.maintenance == nil {
+			o.maintenance = &maintenance{}
+		}
+		o.maintenance.windowStart, o.maintenance.windowEnd = start, end
+		return nil
+	}
+}
+
+// WithIngestValidation sets whether every generated advertisement, including
+// all of its entries, is checked against the validation rules applied by
+// indexers on ingestion before it is made the head. See ValidateAdvertisement.
+// Disabled by default.
+func WithIngestValidation(v bool) Option {
+	return func(o *options) error {
+		o.validateOnPublish = v
+		return nil
+	}
+}
+
+// WithContentCacheMaxAge sets the max-age with which content responses are
+// marked as publicly cacheable and immutable, since blocks are content
+// addressed. Zero disables caching headers. Defaults to one year.
+func WithContentCacheMaxAge(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("content cache max age must not be negative")
+		}
+		o.contentCacheMaxAge = d
+		return nil
+	}
+}
+
+// WithHeadCacheMaxAge sets the max-age with which head responses are marked
+// as publicly cacheable, so that a CDN fronting the publisher can absorb
+// polling by indexers at the cost of them seeing a new head up to d later.
+// Zero marks head responses as not cacheable without revalidation. Defaults
+// to 5 seconds.
+func WithHeadCacheMaxAge(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("head cache max age must not be negative")
+		}
+		o.headCacheMaxAge = d
+		return nil
+	}
+}
+
+// WithCompression sets whether head and content responses are compressed with
+// zstd or gzip when clients accept either, which reduces egress at the cost
+// of CPU. Enabled by default.
+func WithCompression(v bool) Option {
+	return func(o *options) error {
+		o.compression = v
+		return nil
+	}
+}
+
+// WithBlockCodec sets the codec with which advertisements and entry chunks
+// are encoded: multicodec.DagJson, the default, or multicodec.DagCbor, which
+// is more compact. Blocks published before keep their codec.
+func WithBlockCodec(c multicodec.Code) Option {
+	return func(o *options) error {
+		o.blockCodec = c
+		return nil
+	}
+}
+
+// WithBlockHash sets the hash function of the CIDs of advertisements and
+// entry chunks: multicodec.Sha2_256, the default, or multicodec.Blake3.
+func WithBlockHash(h multicodec.Code) Option {
+	return func(o *options) error {
+		o.blockHash = h
+		return nil
+	}
+}
+
+// WithListener sets the listener on which the HTTP publisher serves once
+// started, in place of listening on the address set via
+// WithHttpPublisherListenAddr. The listener is closed when Herald shuts down.
+func WithListener(l net.Listener) Option {
+	return func(o *options) error {
+		if l == nil {
+			return errors.New("listener must not be nil")
+		}
+		o.listener = l
+		return nil
+	}
+}
+
+// WithHttpPublisherListenMultiaddr sets the multiaddr on which the HTTP
+// publisher listens once started, in place of WithHttpPublisherListenAddr,
+// e.g. "/ip6/::/tcp/40080" or "/unix/run/herald.sock" to be served behind a
+// reverse proxy on the same host. A trailing "/http" is ignored, such that the
+// publisher addresses can be used as is. See WithListener to share a listener
+// with the host application instead.
+func WithHttpPublisherListenMultiaddr(ma multiaddr.Multiaddr) Option {
+	return func(o *options) error {
+		if ma == nil {
+			return errors.New("HTTP publisher listen multiaddr must not be nil")
+		}
+		if rest, last := multiaddr.SplitLast(ma); last != nil && last.Protocol().Code == multiaddr.P_HTTP {
+			ma = rest
+		}
+		addr, err := manet.ToNetAddr(ma)
+		if err != nil {
+			return fmt.Errorf("invalid HTTP publisher listen multiaddr %s: %w", ma, err)
+		}
+		switch addr.Network() {
+		case "tcp", "tcp4", "tcp6", "unix":
+		default:
+			return fmt.Errorf("HTTP publisher listen multiaddr %s is neither TCP nor a unix socket", ma)
+		}
+		o.httpPublisherListenMultiaddr = ma
+		return nil
+	}
+}
+
+// WithMaxChunkAge sets the time after which a partially filled entry chunk is
+// stored and linked, so that publishes of catalogs sourced from slow streams
+// keep moving instead of waiting for WithAdEntriesChunkSize multihashes.
+// Zero, the default, waits for full chunks.
+func WithMaxChunkAge(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("max chunk age must not be negative")
+		}
+		o.maxChunkAge = d
+		return nil
+	}
+}
+
+// HttpTransport serves the chain over HTTP, on the address set via
+// WithHttpPublisherListenAddr or WithHttpPublisherListenMultiaddr, or the
+// listener set via WithListener.
+func HttpTransport() PublisherTransport {
+	return func(o *options) error {
+		o.httpTransport = true
+		return nil
+	}
+}
+
+// HttpHandlerTransport serves the chain over HTTP via PublisherHandler only,
+// which the application mounts into its own server, such that Herald does not
+// listen on any address itself. Since Herald cannot tell where the handler is
+// reachable, the addresses announced to indexers must be set via
+// WithPublisherAddrs.
+func HttpHandlerTransport() PublisherTransport {
+	return func(o *options) error {
+		o.httpHandlerTransport = true
+		return nil
+	}
+}
+
+// Libp2pTransport serves the chain to the peers of the given libp2p host, as
+// HTTP over streams of Libp2pProtocolID. The host ID must match the identity
+// of Herald.
+func Libp2pTransport(h host.Host) PublisherTransport {
+	return func(o *options) error {
+		if h == nil {
+			return errors.New("libp2p host must not be nil")
+		}
+		o.libp2pHost = h
+		return nil
+	}
+}
+
+// WithPublisherTransport sets the transports over which the advertisement
+// chain is served. Defaults to HttpTransport only.
+func WithPublisherTransport(t ...PublisherTransport) Option {
+	return func(o *options) error {
+		if len(t) == 0 {
+			return errors.New("at least one publisher transport must be set")
+		}
+		o.httpTransport, o.httpHandlerTransport, o.libp2pHost, o.blobStore = false, false, nil, nil
+		for _, apply := range t {
+			if err := apply(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithGossipsubAnnouncer announces every head update on the topic set via
+// WithTopic over gossipsub, using the given libp2p host whose ID must match
+// the identity of Herald. The topic is joined via the given pubsub instance,
+// or via one instantiated by Herald if nil. Disabled by default.
+func WithGossipsubAnnouncer(h host.Host, ps *pubsub.PubSub) Option {
+	return func(o *options) error {
+		if h == nil {
+			return errors.New("gossipsub host must not be nil")
+		}
+		o.gossipsubHost, o.pubsub = h, ps
+		return nil
+	}
+}
+
+// WithHttpAnnouncer announces every head update to the given indexer URLs
+// over HTTP, e.g. https://cid.contact. Disabled by default.
+func WithHttpAnnouncer(urls ...*url.URL) Option {
+	return func(o *options) error {
+		if len(urls) == 0 {
+			return errors.New("at least one announce URL must be set")
+		}
+		o.httpAnnounceURLs = urls
+		return nil
+	}
+}
+
+// WithAnnouncer announces every head update via the given announcers, in
+// addition to those set via WithGossipsubAnnouncer and WithHttpAnnouncer. An
+// announcement fails if any announcer fails, with an AnnounceError for each.
+func WithAnnouncer(a ...Announcer) Option {
+	return func(o *options) error {
+		o.extraAnnouncers = append(o.extraAnnouncers, a...)
+		return nil
+	}
+}
+
+// WithAnnounceOnStart announces the current head, if any, once Herald is
+// started, so that indexers which missed announcements while it was down
+// catch up without waiting for the next publish. Disabled by default.
+func WithAnnounceOnStart(v bool) Option {
+	return func(o *options) error {
+		o.announceOnStart = v
+		return nil
+	}
+}
+
+// WithReannounceInterval periodically re-announces the current head, if any,
+// via every announcer while Herald is started, as index-provider does, so
+// that indexers which dropped the publisher from their schedule pick it up
+// again. Disabled by default, or if zero.
+func WithReannounceInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("reannounce interval must not be negative")
+		}
+		o.reannounceInterval = d
+		return nil
+	}
+}
+
+// WithAnnounceTimeout sets the maximum duration for which each announcer
+// announces a head, after which its announcement fails. Head updates are
+// announced in the background, coalesced to the latest head, such that
+// publishing does not wait for indexers. Defaults to 30 seconds, where zero
+// means no limit.
+func WithAnnounceTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("announce timeout must not be negative")
+		}
+		o.announceTimeout = d
+		return nil
+	}
+}
+
+// WithPublisherAddrs sets the addresses at which the publisher is reachable,
+// which are included in announcements. Defaults to the addresses on which the
+// publisher transports listen, which should be overridden when those are not
+// dialable by indexers, e.g. when listening on an unspecified IP.
+func WithPublisherAddrs(a ...multiaddr.Multiaddr) Option {
+	return func(o *options) error {
+		o.publisherAddrs = a
+		return nil
+	}
+}
+
+// WithRetractedEntriesRetention deletes the entries of retracted catalogs once
+// they have been retracted for the given duration, since indexers skip the
+// entries of catalogs retracted later in the chain. Entries are deleted upon
+// retraction if zero, and otherwise by the RetractedEntriesGC maintenance
+// task. Only entries published while this option is set are tracked, and
+// blocks shared with the entries of other tracked catalogs are kept.
+// Advertisements are kept, since the chain links them.
+//
+// Rolling the head back past a retraction whose entries are deleted leaves
+// advertisements without entries. Disabled by default.
+func WithRetractedEntriesRetention(d time.Duration) Option {
+	return func(o *options) error {
+		if d < 0 {
+			return errors.New("retracted entries retention must not be negative")
+		}
+		o.retractedEntriesRetention = d
+		return nil
+	}
+}
+
+// WithChainPruning limits the advertisement chain to the given number of most
+// recent advertisements, and to those published within the given age, where
+// zero disables either limit. Advertisements beyond the limits are deleted by
+// PruneChain or the ChainPruning maintenance task, along with their entries
+// unless linked by retained advertisements, except that the head and the
+// latest advertisement of every catalog that is not retracted, other than
+// partial removals, are always retained. Only advertisements published while
+// an age is set have their publish time recorded. Disabled by default.
+//
+// The retained advertisements are rewritten such that the oldest has no
+// previous advertisement, which changes their CIDs and the head, so that
+// indexers sync the chain from the new head without following links to pruned
+// advertisements. Indexers that have yet to sync pruned advertisements can no
+// longer do so; pruning should therefore only remove advertisements indexers
+// have long ingested. Publish records are updated with the rewritten CIDs,
+// and still replay the unpruned chain; see Herald.Replay.
+func WithChainPruning(maxLength int, maxAge time.Duration) Option {
+	return func(o *options) error {
+		if maxLength < 0 || maxAge < 0 {
+			return errors.New("chain pruning limits must not be negative")
+		}
+		o.chainMaxLength, o.chainMaxAge = maxLength, maxAge
+		return nil
+	}
+}
+
+// WithMetrics registers Prometheus metrics of the publish pipeline and of the
+// HTTP publisher with the given registerer, such as the number of published
+// advertisements, the multihashes and entries generation latency per
+// advertisement, the requests served by endpoint and status code, and the
+// signed heads served from cache. The metrics are exposed by serving the
+// corresponding gatherer, e.g. via promhttp. Disabled by default.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(o *options) error {
+		if reg == nil {
+			return errors.New("metrics registerer must not be nil")
+		}
+		o.metricsRegisterer = reg
+		return nil
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry provider of the tracer with which
+// publishes, retractions, datastore writes and publisher requests are traced.
+// Publisher requests continue the trace propagated by their headers, if any,
+// as extracted by the global propagator. Defaults to the global provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) error {
+		if tp == nil {
+			return errors.New("tracer provider must not be nil")
+		}
+		o.tracerProvider = tp
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync"
+)
+
+const modulePath = "github.com/ipni/herald"
+
+var (
+	version     string
+	versionOnce sync.Once
+)
+
+type (
+	// ProviderInfo describes the provider on whose behalf Herald publishes
+	// advertisements, and the state of its advertisement chain.
+	ProviderInfo struct {
+		ID        string   `json:"id"`
+		Addresses []string `json:"addresses"`
+		// Publisher is the peer ID of the publisher signing advertisements,
+		// when it differs from the provider. See WithProviderID.
+		Publisher string `json:"publisher,omitempty"`
+		Topic     string `json:"topic"`
+		// PathPrefix is the URL path prefix under which the HTTP publisher
+		// is mounted, which indexers must include in the publisher URL.
+		PathPrefix string   `json:"pathPrefix,omitempty"`
+		Head       string   `json:"head,omitempty"`
+		Protocols  []string `json:"protocols"`
+		Version    string   `json:"version"`
+	}
+)
+
+// Version returns the version of the Herald module linked into the running
+// binary, or "(devel)" if it cannot be determined.
+func Version() string {
+	versionOnce.Do(func() {
+		version = "(devel)"
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		if bi.Main.Path == modulePath {
+			if bi.Main.Version != "" {
+				version = bi.Main.Version
+			}
+			return
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				version = dep.Version
+				if dep.Replace != nil && dep.Replace.Version != "" {
+					version = dep.Replace.Version
+				}
+				return
+			}
+		}
+	})
+	return version
+}
+
+// ProviderInfo returns the identity, addresses and current head of the
+// provider published by Herald.
+func (h *Herald) ProviderInfo(ctx context.Context) (*ProviderInfo, error) {
+	head, err := h.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info := &ProviderInfo{
+		ID:         h.providerID.String(),
+		Addresses:  *h.publisher.dsPublisher.providerAddrs.Load(),
+		Topic:      h.topic,
+		PathPrefix: h.httpPublisherPathPrefix,
+		Protocols:  make([]string, 0, len(syncProtocols)),
+		Version:    Version(),
+	}
+	if h.providerID != h.id {
+		info.Publisher = h.id.String()
+	}
+	for _, p := range syncProtocols {
+		info.Protocols = append(info.Protocols, p.Name)
+	}
+	if head.Defined() {
+		info.Head = head.String()
+	}
+	return info, nil
+}
+
+func (p *httpPublisher) handleGetProviderInfo(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info, err := p.h.ProviderInfo(r.Context())
+	if err != nil {
+		p.h.httpLogger.Errorw("failed to get provider info", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		p.h.httpLogger.Errorw("failed to write provider info response", "err", err)
+	}
+}
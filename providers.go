@@ -0,0 +1,200 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ErrProviderNotFound signals that a publish is routed to a provider that is
+// not registered. See WithPublishProvider.
+var ErrProviderNotFound = errors.New("provider is not registered")
+
+type (
+	// Provider describes a provider of content on whose behalf Herald
+	// publishes advertisements on the same chain, in addition to the one set
+	// via WithProviderID. Advertisements are signed by the publisher
+	// identity, which indexers must allow to publish on behalf of the
+	// provider.
+	Provider struct {
+		ID    peer.ID
+		Addrs []multiaddr.Multiaddr
+		// Metadata, when set, overrides the metadata set via WithMetadata
+		// for the catalogs of the provider.
+		Metadata metadata.Metadata
+	}
+	// providerRegistry holds the registered providers, keyed by peer ID.
+	providerRegistry struct {
+		mu        sync.RWMutex
+		providers map[peer.ID]*registeredProvider
+	}
+	registeredProvider struct {
+		addrs    []string
+		metadata []byte
+	}
+)
+
+// newRegisteredProvider validates the given provider as configured on Herald.
+func (o *options) newRegisteredProvider(p Provider) (*registeredProvider, error) {
+	if err := p.ID.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid provider ID: %w", err)
+	}
+	if p.ID == o.providerID {
+		return nil, fmt.Errorf("provider %s is the default provider", p.ID)
+	}
+	rp := &registeredProvider{
+		addrs:    make([]string, 0, len(p.Addrs)),
+		metadata: o.metadata,
+	}
+	for _, addr := range p.Addrs {
+		rp.addrs = append(rp.addrs, addr.String())
+	}
+	if err := validateProviderAddrs(rp.addrs, o.permissiveValidation); err != nil {
+		return nil, fmt.Errorf("provider %s: %w", p.ID, err)
+	}
+	if p.Metadata.Len() != 0 {
+		var err error
+		if rp.metadata, err = p.Metadata.MarshalBinary(); err != nil {
+			return nil, err
+		}
+		if err := validateMetadata(rp.metadata, o.permissiveValidation); err != nil {
+			return nil, fmt.Errorf("provider %s: %w", p.ID, err)
+		}
+	}
+	return rp, nil
+}
+
+func (r *providerRegistry) get(id peer.ID) (*registeredProvider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.providers[id]
+	return p, ok
+}
+
+func (r *providerRegistry) put(id peer.ID, p *registeredProvider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.providers == nil {
+		r.providers = make(map[peer.ID]*registeredProvider)
+	}
+	r.providers[id] = p
+}
+
+func (r *providerRegistry) delete(id peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.providers, id)
+}
+
+func (r *providerRegistry) ids() []peer.ID {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]peer.ID, 0, len(r.providers))
+	for id := range r.providers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (r *providerRegistry) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.providers)
+}
+
+// AddProvider registers the given provider, or updates it if already
+// registered, so that catalogs can be published on its behalf via
+// WithPublishProvider. Updated addresses and metadata apply to subsequent
+// advertisements. Registrations are not persisted; WithProvider should be set
+// accordingly when Herald is restarted.
+func (h *Herald) AddProvider(p Provider) error {
+	rp, err := h.newRegisteredProvider(p)
+	if err != nil {
+		return err
+	}
+	h.providers.put(p.ID, rp)
+	h.logger.Infow("Registered provider", "provider", p.ID, "addrs", rp.addrs)
+	return nil
+}
+
+// RemoveProvider unregisters the provider with the given peer ID. Its catalogs
+// remain advertised until retracted, which Retract still does on its behalf.
+func (h *Herald) RemoveProvider(id peer.ID) {
+	h.providers.delete(id)
+	h.logger.Infow("Unregistered provider", "provider", id)
+}
+
+// Providers returns the peer IDs of the registered providers, excluding the
+// default provider.
+func (h *Herald) Providers() []peer.ID {
+	return h.providers.ids()
+}
+
+// WithPublishProvider publishes the catalog on behalf of the registered
+// provider with the given peer ID, with its addresses and metadata unless
+// overridden. Otherwise, catalogs are published on behalf of the provider they
+// were last published for, if registered, or else the default provider.
+func WithPublishProvider(id peer.ID) PublishOption {
+	return func(o *publishOptions) error {
+		o.provider = id
+		return nil
+	}
+}
+
+// resolveProvider sets the addresses and metadata of the registered provider of
+// the publish, unless overridden. It fails with ErrProviderNotFound if the
+// provider is not registered.
+func (h *Herald) resolveProvider(opts *publishOptions) error {
+	if opts.provider == "" || opts.provider == h.providerID {
+		opts.provider = ""
+		return nil
+	}
+	p, ok := h.providers.get(opts.provider)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrProviderNotFound, opts.provider)
+	}
+	if opts.providerAddrs == nil {
+		opts.providerAddrs = p.addrs
+	}
+	if opts.metadata == nil {
+		opts.metadata = p.metadata
+	}
+	return nil
+}
+
+// catalogProvider returns the provider that the given catalog was last
+// published for, if other than the default provider, and either registered or
+// the catalog is being removed. Its addresses and metadata are those of its
+// latest advertisement unless it is still registered. The provider is empty
+// otherwise, including if the catalog was never published. It is looked up
+// from the catalog status, unless no provider is registered and no catalog was
+// ever published for another provider. It is called with the publisher lock
+// held.
+func (l *dsPublisher) catalogProvider(ctx context.Context, id CatalogID, isRm bool) (peer.ID, *registeredProvider, error) {
+	if err := l.syncCatalogs(ctx); err != nil {
+		return "", nil, err
+	}
+	if l.h.providers.len() == 0 && !l.publishedForOtherProviders() {
+		return "", nil, nil
+	}
+	status, err := l.loadCatalogStatus(ctx, id)
+	if err != nil || status == nil || status.Provider == "" || status.Provider == l.h.providerID {
+		return "", nil, err
+	}
+	if p, ok := l.h.providers.get(status.Provider); ok {
+		return status.Provider, p, nil
+	}
+	if !isRm {
+		return "", nil, nil
+	}
+	latest, err := l.loadAdvertisement(ctx, status.Advertisement)
+	if err != nil {
+		return "", nil, err
+	}
+	return status.Provider, &registeredProvider{addrs: latest.Addresses, metadata: latest.Metadata}, nil
+}
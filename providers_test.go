@@ -0,0 +1,172 @@
+package herald_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestRetractOfUnregisteredProvider(t *testing.T) {
+	t.Run("tracked", func(t *testing.T) { testRetractOfUnregisteredProvider(t, false) })
+	t.Run("legacy", func(t *testing.T) { testRetractOfUnregisteredProvider(t, true) })
+}
+
+func testRetractOfUnregisteredProvider(t *testing.T, legacy bool) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	provider := herald.Provider{
+		ID:    test.RandPeerIDFatal(t),
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.2/tcp/40080/http")},
+	}
+
+	h, err := herald.New(heraldtest.Options(herald.WithDatastore(ds))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.AddProvider(provider); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.PublishWithOptions(ctx, heraldtest.Catalog("a", 3), herald.WithPublishProvider(provider.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Publish(ctx, heraldtest.Catalog("b", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if legacy {
+		untrackCatalogProviders(t, ds)
+	}
+
+	// Registrations are not persisted, yet catalogs are retracted for the
+	// provider they were published for.
+	h, err = herald.New(heraldtest.Options(herald.WithDatastore(ds))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	for id, want := range map[string]string{"a": provider.ID.String(), "b": h.ID().String()} {
+		if _, err := h.Retract(ctx, []byte(id)); err != nil {
+			t.Fatal(err)
+		}
+		ads, err := heraldtest.NewSyncer(h, h.ID()).Sync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ads[len(ads)-1].Ad.Provider; got != want {
+			t.Fatalf("expected retraction of %s for provider %s, got %s", id, want, got)
+		}
+		status, err := h.GetCatalogStatus(ctx, []byte(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.Provider.String(); got != want {
+			t.Fatalf("expected status of %s for provider %s, got %s", id, want, got)
+		}
+	}
+}
+
+// untrackCatalogProviders reverts the datastore to the schema in which catalog
+// statuses had no provider.
+func untrackCatalogProviders(t *testing.T, ds datastore.Datastore) {
+	ctx := context.Background()
+	results, err := ds.Query(ctx, query.Query{Prefix: "/catalog-providers", KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range providers {
+		if err := ds.Delete(ctx, datastore.RawKey(e.Key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if results, err = ds.Query(ctx, query.Query{Prefix: "/catalogs"}); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range statuses {
+		var status map[string]any
+		if err := json.Unmarshal(e.Value, &status); err != nil {
+			t.Fatal(err)
+		}
+		delete(status, "provider")
+		value, err := json.Marshal(status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ds.Put(ctx, datastore.RawKey(e.Key), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ds.Put(ctx, datastore.NewKey("version"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetractAllOfDefaultProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := herald.Provider{
+		ID:    test.RandPeerIDFatal(t),
+		Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.2/tcp/40080/http")},
+	}
+	h, err := herald.New(heraldtest.Options(herald.WithProvider(provider))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if _, err := h.PublishWithOptions(ctx, heraldtest.Catalog("a", 3), herald.WithPublishProvider(provider.ID)); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"b", "c"} {
+		if _, err := h.Publish(ctx, heraldtest.Catalog(id, 2)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := h.Retract(ctx, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Publish(ctx, heraldtest.Catalog("d", 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := h.RetractAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ads, err := heraldtest.NewSyncer(h, h.ID()).Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only c and d are retracted, from the most recently published.
+	if len(ads) != 7 || !ads[len(ads)-1].CID.Equals(head) {
+		t.Fatalf("expected 2 retractions up to head %s, got %d advertisements", head, len(ads))
+	}
+	for i, want := range []string{"d", "c"} {
+		if ad := ads[5+i].Ad; !ad.IsRm || string(ad.ContextID) != want {
+			t.Fatalf("expected retraction %d of %s, got %q (removal: %t)", i, want, ad.ContextID, ad.IsRm)
+		}
+	}
+	status, err := h.GetCatalogStatus(ctx, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Retracted {
+		t.Fatal("expected the catalog of the registered provider to stay advertised")
+	}
+}
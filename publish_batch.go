@@ -0,0 +1,167 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ Catalog         = (*sliceCatalog)(nil)
+	_ CatalogIterator = (*sliceCatalogIterator)(nil)
+)
+
+type (
+	// publishBatcher coalesces the publishes of each catalog submitted within
+	// the publish interval of the first into a single publish of the union of
+	// their multihashes. See WithPublishInterval.
+	publishBatcher struct {
+		h        *Herald
+		interval time.Duration
+		mu       sync.Mutex
+		pending  map[string]*pendingPublish
+		closed   bool
+		// flushing tracks the publishes of merged catalogs in progress.
+		flushing sync.WaitGroup
+	}
+	pendingPublish struct {
+		catalog *sliceCatalog
+		seen    map[string]struct{}
+		timer   *time.Timer
+		// done is closed once the merged catalog is published, with the
+		// outcome in ad and err.
+		done chan struct{}
+		ad   cid.Cid
+		err  error
+	}
+	// sliceCatalog is a catalog of multihashes held in memory, such as those
+	// of coalesced catalogs, served over the transport of the first.
+	sliceCatalog struct {
+		id        CatalogID
+		mhs       []multihash.Multihash
+		transport interface{ Providers() any }
+	}
+	sliceCatalogIterator struct {
+		mhs []multihash.Multihash
+	}
+)
+
+func newPublishBatcher(h *Herald, interval time.Duration) *publishBatcher {
+	return &publishBatcher{
+		h:        h,
+		interval: interval,
+		pending:  make(map[string]*pendingPublish),
+	}
+}
+
+// publish adds the multihashes of the given catalog to those pending for its
+// ID, and waits for them to be published. The multihashes are published even
+// if the context is done before then.
+func (b *publishBatcher) publish(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	// The catalog is read before locking, since it may be slow to iterate.
+	var mhs []multihash.Multihash
+	for iter := catalog.Iterator(); !iter.Done(); {
+		mh, err := iter.Next()
+		if err != nil {
+			return cid.Undef, err
+		}
+		mhs = append(mhs, bytes.Clone(mh))
+	}
+	key := string(catalog.ID())
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return cid.Undef, ErrClosed
+	}
+	p, ok := b.pending[key]
+	if !ok {
+		p = &pendingPublish{
+			catalog: &sliceCatalog{id: catalog.ID(), transport: catalog.Transport()},
+			seen:    make(map[string]struct{}),
+			done:    make(chan struct{}),
+		}
+		p.timer = time.AfterFunc(b.interval, func() { b.flush(key, p) })
+		b.pending[key] = p
+		b.flushing.Add(1)
+	}
+	for _, mh := range mhs {
+		if _, ok := p.seen[string(mh)]; !ok {
+			p.seen[string(mh)] = struct{}{}
+			p.catalog.mhs = append(p.catalog.mhs, mh)
+		}
+	}
+	b.mu.Unlock()
+	select {
+	case <-p.done:
+		return p.ad, p.err
+	case <-ctx.Done():
+		return cid.Undef, ctx.Err()
+	}
+}
+
+// flush publishes the merged catalog of the given pending publish, unless it
+// is no longer pending.
+func (b *publishBatcher) flush(key string, p *pendingPublish) {
+	b.mu.Lock()
+	if b.pending[key] != p {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, key)
+	b.mu.Unlock()
+	defer b.flushing.Done()
+	b.h.publisherLogger.Debugw("Publishing merged catalog", "id", p.catalog.id, "mhCount", len(p.catalog.mhs))
+	p.ad, p.err = b.h.publish(context.Background(), p.catalog)
+	close(p.done)
+}
+
+// stop publishes the pending catalogs right away, and waits for them to be
+// published. Publishes submitted afterwards fail with ErrClosed.
+func (b *publishBatcher) stop(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	pending := make(map[string]*pendingPublish, len(b.pending))
+	for key, p := range b.pending {
+		if p.timer.Stop() {
+			pending[key] = p
+		}
+	}
+	b.mu.Unlock()
+	for key, p := range pending {
+		go b.flush(key, p)
+	}
+	done := make(chan struct{})
+	go func() {
+		b.flushing.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *sliceCatalog) ID() []byte { return c.id }
+
+func (c *sliceCatalog) Iterator() CatalogIterator {
+	return &sliceCatalogIterator{mhs: c.mhs}
+}
+
+func (c *sliceCatalog) Transport() interface{ Providers() any } { return c.transport }
+
+func (i *sliceCatalogIterator) Next() (multihash.Multihash, error) {
+	if len(i.mhs) == 0 {
+		return nil, ErrCatalogIteratorDone
+	}
+	mh := i.mhs[0]
+	i.mhs = i.mhs[1:]
+	return mh, nil
+}
+
+func (i *sliceCatalogIterator) Done() bool { return len(i.mhs) == 0 }
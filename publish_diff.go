@@ -0,0 +1,386 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	// diffPrefix is the namespace under which the multihashes advertised for
+	// each catalog published by diff are tracked, keyed by catalog ID and
+	// multihash.
+	diffPrefix = datastore.NewKey("diff")
+	// diffAdsPrefix is the namespace under which the latest advertisement
+	// published by diff for each catalog is stored, so that the tracked
+	// multihashes are known to be stale once the catalog is published
+	// otherwise.
+	diffAdsPrefix = datastore.NewKey("diff-ads")
+)
+
+// DiffReceipt describes the advertisements published by PublishDiff.
+type DiffReceipt struct {
+	// Added is the CID of the advertisement of the added multihashes, or
+	// cid.Undef if none were added.
+	Added cid.Cid
+	// Removed is the CID of the removal advertisement of the removed
+	// multihashes, or cid.Undef if none were removed.
+	Removed cid.Cid
+	// AddedCount and RemovedCount are the numbers of multihashes added and
+	// removed.
+	AddedCount   int
+	RemovedCount int
+	// MultihashCount is the number of multihashes advertised for the catalog
+	// once the diff is published.
+	MultihashCount int
+}
+
+func diffCatalogKey(id CatalogID) datastore.Key {
+	return diffPrefix.ChildString(catalogKeyString(id))
+}
+
+func diffKey(id CatalogID, mh multihash.Multihash) datastore.Key {
+	return diffCatalogKey(id).ChildString(mh.B58String())
+}
+
+func diffAdKey(id CatalogID) datastore.Key {
+	return diffAdsPrefix.ChildString(catalogKeyString(id))
+}
+
+// PublishDiff compares the multihashes of the given catalog against those
+// previously advertised for it, and publishes an advertisement of the added
+// multihashes and a removal advertisement of the removed ones, instead of
+// advertising all of them again. Nothing is published if the catalog is
+// unchanged. The advertised multihashes are tracked in the datastore; if the
+// catalog was last published otherwise, they are taken to be the entries of
+// its latest advertisement, less those removed since by RemoveMultihashes.
+//
+// Since the entries of a catalog published by diff are spread across its
+// advertisements, such catalogs should not be used with chain pruning, which
+// only keeps the latest advertisement of each catalog. Concurrent diffs of the
+// same catalog must be serialized by the caller.
+func (h *Herald) PublishDiff(ctx context.Context, catalog Catalog) (*DiffReceipt, error) {
+	if err := h.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer h.limiter.release()
+	return h.publisher.dsPublisher.publishDiff(ctx, catalog)
+}
+
+func (l *dsPublisher) publishDiff(ctx context.Context, catalog Catalog) (*DiffReceipt, error) {
+	id := catalog.ID()
+	previous, tracked, err := l.diffBaseline(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	filter := l.publishFilter(&publishOptions{})
+	current := make(map[string]struct{}, len(previous))
+	var added []multihash.Multihash
+	for iter := catalog.Iterator(); !iter.Done(); {
+		mh, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil && !filter(mh) {
+			continue
+		}
+		if _, ok := current[string(mh)]; ok {
+			continue
+		}
+		current[string(mh)] = struct{}{}
+		if _, ok := previous[string(mh)]; !ok {
+			added = append(added, bytes.Clone(mh))
+		}
+	}
+	var removed []multihash.Multihash
+	for mh := range previous {
+		if _, ok := current[mh]; !ok {
+			removed = append(removed, multihash.Multihash(mh))
+		}
+	}
+	// Removed multihashes are sorted so that their entries are deterministic.
+	sort.Slice(removed, func(i, j int) bool { return bytes.Compare(removed[i], removed[j]) < 0 })
+	receipt := &DiffReceipt{
+		AddedCount:     len(added),
+		RemovedCount:   len(removed),
+		MultihashCount: len(current),
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		l.h.publisherLogger.Infow("Skipped publishing unchanged catalog diff", "id", id)
+		return receipt, nil
+	}
+	// Filters were applied above, and the advertisements of the diff are
+	// never the same as those preceding them.
+	newOpts := func() *publishOptions {
+		return &publishOptions{skipFilters: true, publishUnchanged: true, multihashCount: len(current)}
+	}
+	if len(removed) != 0 {
+		if receipt.Removed, err = l.remove(ctx, &sliceCatalog{id: id, mhs: removed}, newOpts()); err != nil {
+			return nil, err
+		}
+	}
+	latest := receipt.Removed
+	if len(added) != 0 {
+		published, err := l.publish(ctx, &sliceCatalog{id: id, mhs: added, transport: catalog.Transport()}, newOpts())
+		if err != nil {
+			return nil, err
+		}
+		receipt.Added, latest = published.Advertisement, published.Advertisement
+	}
+	if tracked {
+		err = l.updateDiff(ctx, id, latest, added, removed)
+	} else {
+		err = l.resetDiff(ctx, id, latest, current)
+	}
+	if err != nil {
+		// The diff was published regardless; the advertised multihashes are
+		// taken from the chain by the next diff.
+		l.h.publisherLogger.Errorw("failed to track advertised multihashes of catalog diff", "id", id, "err", err)
+	}
+	l.h.publisherLogger.Infow("Published catalog diff", "id", id, "added", receipt.Added, "addedCount", receipt.AddedCount, "removed", receipt.Removed, "removedCount", receipt.RemovedCount)
+	return receipt, nil
+}
+
+// diffBaseline returns the multihashes advertised for the given catalog, and
+// whether they are tracked from its latest publish by diff. Otherwise, they are
+// the entries of its latest advertisement less those removed since, if it is
+// not retracted.
+func (l *dsPublisher) diffBaseline(ctx context.Context, id CatalogID) (map[string]struct{}, bool, error) {
+	baseline := make(map[string]struct{})
+	status, err := l.getCatalogStatus(ctx, id)
+	switch {
+	case errors.Is(err, ErrCatalogNotFound):
+		return baseline, false, nil
+	case err != nil:
+		return nil, false, err
+	case status.Retracted:
+		return baseline, false, nil
+	}
+	if tracked, err := l.forEachDiffMultihash(ctx, id, status.Advertisement, func(mh multihash.Multihash) bool {
+		baseline[string(mh)] = struct{}{}
+		return true
+	}); err != nil || tracked {
+		return baseline, tracked, err
+	}
+	head, err := l.GetHead(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	// Multihashes removed since the latest advertisement, e.g. by
+	// RemoveMultihashes, are no longer advertised.
+	var latest *schema.Advertisement
+	var removals []cid.Cid
+	if err := l.walkChain(ctx, head, func(_ cid.Cid, ad *schema.Advertisement) (bool, error) {
+		switch {
+		case !bytes.Equal(ad.ContextID, id):
+			return true, nil
+		case isPartialRemoval(ad):
+			removals = append(removals, ad.Entries.(cidlink.Link).Cid)
+			return true, nil
+		}
+		latest = ad
+		return false, nil
+	}); err != nil {
+		return nil, false, err
+	}
+	if latest == nil || latest.IsRm || !hasEntries(latest.Entries) {
+		return baseline, false, nil
+	}
+	if err := l.forEachEntry(ctx, latest.Entries.(cidlink.Link).Cid, func(mh multihash.Multihash) bool {
+		baseline[string(mh)] = struct{}{}
+		return true
+	}); err != nil {
+		return nil, false, err
+	}
+	for _, removal := range removals {
+		if err := l.forEachEntry(ctx, removal, func(mh multihash.Multihash) bool {
+			delete(baseline, string(mh))
+			return true
+		}); err != nil {
+			return nil, false, err
+		}
+	}
+	l.h.publisherLogger.Debugw("Took catalog diff baseline from latest advertisement", "id", id, "mhCount", len(baseline))
+	return baseline, false, nil
+}
+
+// forEachDiffMultihash calls fn with each multihash tracked for the given
+// catalog, until it returns false, if the given latest advertisement of the
+// catalog was published by diff. It reports whether the multihashes are
+// tracked.
+func (l *dsPublisher) forEachDiffMultihash(ctx context.Context, id CatalogID, latest cid.Cid, fn func(multihash.Multihash) bool) (bool, error) {
+	switch ad, err := l.h.ds.Get(ctx, diffAdKey(id)); {
+	case errors.Is(err, datastore.ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	case !bytes.Equal(ad, latest.Bytes()):
+		return false, nil
+	}
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: diffCatalogKey(id).String(), KeysOnly: true})
+	if err != nil {
+		return false, err
+	}
+	defer results.Close()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return false, r.Error
+		}
+		mh, err := multihash.FromB58String(datastore.RawKey(r.Key).Name())
+		if err != nil {
+			l.h.datastoreLogger.Warnw("ignoring invalid diff multihash key", "key", r.Key, "err", err)
+			continue
+		}
+		if !fn(mh) {
+			break
+		}
+	}
+	return true, nil
+}
+
+// updateDiff tracks the given changes to the multihashes advertised for a
+// catalog by the given advertisement.
+func (l *dsPublisher) updateDiff(ctx context.Context, id CatalogID, ad cid.Cid, added, removed []multihash.Multihash) error {
+	w, commit, err := l.diffWriter(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mh := range removed {
+		if err := w.Delete(ctx, diffKey(id, mh)); err != nil {
+			return err
+		}
+	}
+	for _, mh := range added {
+		if err := w.Put(ctx, diffKey(id, mh), nil); err != nil {
+			return err
+		}
+	}
+	if err := w.Put(ctx, diffAdKey(id), ad.Bytes()); err != nil {
+		return err
+	}
+	return commit(ctx)
+}
+
+// resetDiff replaces the multihashes tracked for a catalog with those
+// advertised by the given advertisement.
+func (l *dsPublisher) resetDiff(ctx context.Context, id CatalogID, ad cid.Cid, advertised map[string]struct{}) error {
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: diffCatalogKey(id).String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	stale, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	w, commit, err := l.diffWriter(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range stale {
+		if err := w.Delete(ctx, datastore.RawKey(r.Key)); err != nil {
+			return err
+		}
+	}
+	for mh := range advertised {
+		if err := w.Put(ctx, diffKey(id, multihash.Multihash(mh)), nil); err != nil {
+			return err
+		}
+	}
+	if err := w.Put(ctx, diffAdKey(id), ad.Bytes()); err != nil {
+		return err
+	}
+	return commit(ctx)
+}
+
+// remapDiffs updates the latest advertisements published by diff once the
+// chain is rewritten.
+func (l *dsPublisher) remapDiffs(ctx context.Context, rewritten map[cid.Cid]cid.Cid) error {
+	results, err := l.h.ds.Query(ctx, query.Query{Prefix: diffAdsPrefix.String()})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		_, ad, err := cid.CidFromBytes(e.Value)
+		if err != nil {
+			return err
+		}
+		if c, ok := rewritten[ad]; ok {
+			if err := l.h.ds.Put(ctx, datastore.RawKey(e.Key), c.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// diffWriter returns a batch of the datastore if it supports batching, or
+// else the datastore itself, along with the function committing the writes.
+func (l *dsPublisher) diffWriter(ctx context.Context) (datastore.Write, func(context.Context) error, error) {
+	bds, ok := l.h.ds.(datastore.Batching)
+	if !ok {
+		return l.h.ds, func(context.Context) error { return nil }, nil
+	}
+	batch, err := bds.Batch(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return batch, batch.Commit, nil
+}
+
+// forEachEntry calls fn with each multihash in the entries with the given
+// root, laid out either as a chain of entry chunks or as a HAMT, until it
+// returns false.
+func (l *dsPublisher) forEachEntry(ctx context.Context, root cid.Cid, fn func(multihash.Multihash) bool) error {
+	if n, err := l.entriesLs.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root}, hamt.HashMapRootPrototype.Representation()); err == nil {
+		hamtRoot, ok := bindnode.Unwrap(n).(*hamt.HashMapRoot)
+		if !ok {
+			return errors.New("entries HAMT has unexpected root")
+		}
+		node := hamt.Node{HashMapRoot: *hamtRoot}
+		for it := node.WithLinking(l.entriesLs, l.h.linkPrototype).MapIterator(); !it.Done(); {
+			k, _, err := it.Next()
+			if err != nil {
+				return err
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return err
+			}
+			if !fn(multihash.Multihash(key)) {
+				return nil
+			}
+		}
+		return nil
+	}
+	for next := root; !cid.Undef.Equals(next); {
+		chunk, err := l.loadEntryChunk(ctx, next)
+		if err != nil {
+			return err
+		}
+		for _, mh := range chunk.Entries {
+			if !fn(mh) {
+				return nil
+			}
+		}
+		if chunk.Next == nil {
+			break
+		}
+		next = chunk.Next.(cidlink.Link).Cid
+	}
+	return nil
+}
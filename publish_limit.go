@@ -0,0 +1,63 @@
+package herald
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// ErrBusy signals that the maximum number of pending publishes has been
+// reached. See WithMaxPendingPublishes.
+var ErrBusy = errors.New("too many pending publishes")
+
+type (
+	// publishLimiter bounds the number of publishes and retractions that are
+	// in progress at once, so that work and memory do not accumulate without
+	// bound during bursts of publishes.
+	publishLimiter struct {
+		slots  chan struct{}
+		block  bool
+		logger *zap.SugaredLogger
+	}
+)
+
+func newPublishLimiter(max int, block bool, logger *zap.SugaredLogger) *publishLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &publishLimiter{
+		slots:  make(chan struct{}, max),
+		block:  block,
+		logger: logger,
+	}
+}
+
+// acquire reserves a slot for a publish, returning ErrBusy if none is free
+// unless configured to block until one is, or the context is done.
+func (l *publishLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if !l.block {
+		l.logger.Warnw("rejecting publish; too many pending publishes", "max", cap(l.slots))
+		return ErrBusy
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *publishLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,153 @@
+package herald
+
+import (
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+type (
+	PublishOption  func(*publishOptions) error
+	publishOptions struct {
+		filter        func(multihash.Multihash) bool
+		appendEntries bool
+		// metadata and providerAddrs override those configured on Herald
+		// when set.
+		metadata      []byte
+		providerAddrs []string
+		// updateProviderAddrs makes providerAddrs those of subsequent
+		// advertisements once published.
+		updateProviderAddrs bool
+		// skipFilters disables every filter, including those configured on
+		// Herald, e.g. to replay publishes whose multihashes were filtered.
+		skipFilters bool
+		// record, when set, is the record of the publish being made.
+		record *publishRecord
+		labels map[string]string
+		// expectHead is set when the publish must only be chained onto
+		// expectedHead.
+		expectHead   bool
+		expectedHead cid.Cid
+		// publishUnchanged disables skipping publishes that would not change
+		// the latest advertisement of the catalog. skipUnchanged is set when
+		// that is to be checked, and unchanged once the publish is skipped.
+		publishUnchanged bool
+		skipUnchanged    bool
+		unchanged        bool
+		// multihashCount is the number of multihashes chunked as the entries
+		// being published, as tracked by the catalog status, unless set
+		// beforehand to the number of multihashes advertised for the catalog
+		// when publishing by diff.
+		multihashCount int
+		// queueID is the ID of the persisted queued publish being processed,
+		// if any.
+		queueID string
+		// provider is the registered provider of the publish, if other than
+		// the default provider. See WithPublishProvider.
+		provider peer.ID
+	}
+
+	// PublishReceipt describes the outcome of a publish.
+	PublishReceipt struct {
+		// Advertisement is the CID of the published advertisement.
+		Advertisement cid.Cid
+		// Entries is the CID of the root entry chunk, or cid.Undef if the
+		// advertisement has no entries.
+		Entries cid.Cid
+		// MultihashCount is the number of multihashes chunked as entries.
+		MultihashCount int
+		// ChunkCount is the number of entry chunks generated.
+		ChunkCount int
+		// FilteredCount is the number of multihashes excluded by filters.
+		FilteredCount int
+		// ReusedChunkCount is the number of generated entry chunks that were
+		// already stored, and therefore not written again.
+		ReusedChunkCount int
+		// Unchanged is set when no advertisement was published, because the
+		// latest advertisement of the catalog already has the same entries,
+		// metadata and addresses. Advertisement is then that advertisement,
+		// to which labels, if any, are not attached.
+		Unchanged bool
+	}
+)
+
+// newPublishOptions applies the given options, validating the metadata they
+// set, if any, as configured on Herald.
+func (h *Herald) newPublishOptions(o ...PublishOption) (*publishOptions, error) {
+	opts, err := newPublishOptions(o...)
+	if err != nil {
+		return nil, err
+	}
+	if opts.metadata != nil {
+		if err := validateMetadata(opts.metadata, h.permissiveValidation); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.resolveProvider(opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func newPublishOptions(o ...PublishOption) (*publishOptions, error) {
+	var opts publishOptions
+	for _, apply := range o {
+		if err := apply(&opts); err != nil {
+			return nil, err
+		}
+	}
+	return &opts, nil
+}
+
+// WithPublishFilter excludes multihashes for which f returns false from the
+// published entries, in addition to any filter set via WithContentFilter. The
+// multihashes passed to f must not be retained.
+func WithPublishFilter(f func(multihash.Multihash) bool) PublishOption {
+	return func(o *publishOptions) error {
+		o.filter = f
+		return nil
+	}
+}
+
+// WithPublishMetadata sets the transport metadata of the published
+// advertisement, overriding the one set via WithMetadata, e.g. to advertise
+// different retrieval protocols per dataset.
+func WithPublishMetadata(v metadata.Metadata) PublishOption {
+	return func(o *publishOptions) error {
+		var err error
+		o.metadata, err = v.MarshalBinary()
+		return err
+	}
+}
+
+// WithAppend appends the multihashes of the published catalog to the entries
+// of its latest advertisement, as done by PublishAppend.
+func WithAppend() PublishOption {
+	return func(o *publishOptions) error {
+		o.appendEntries = true
+		return nil
+	}
+}
+
+// WithPublishUnchanged publishes an advertisement even if the latest
+// advertisement of the catalog already has the same entries, metadata and
+// addresses, which is otherwise skipped. See PublishReceipt.Unchanged.
+func WithPublishUnchanged() PublishOption {
+	return func(o *publishOptions) error {
+		o.publishUnchanged = true
+		return nil
+	}
+}
+
+// WithExpectedHead makes the publish fail with ErrHeadMoved unless the current
+// head is the given CID when the advertisement is chained, so that multiple
+// writers orchestrated externally never chain onto an unexpected parent. Pass
+// cid.Undef to expect that nothing has been published yet.
+func WithExpectedHead(head cid.Cid) PublishOption {
+	return func(o *publishOptions) error {
+		o.expectHead = true
+		o.expectedHead = head
+		return nil
+	}
+}
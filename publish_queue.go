@@ -0,0 +1,539 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	queuePrefix = datastore.NewKey("queue")
+	// queuePartsPrefix is the namespace under which the multihashes of queued
+	// publishes are stored, apart from those of publish records, since both
+	// may share a datastore.
+	queuePartsPrefix = datastore.NewKey("queue-multihashes")
+
+	// ErrNoPublishQueue signals that publishes cannot be queued, since the
+	// publish queue is not enabled. See WithPublishQueue.
+	ErrNoPublishQueue = errors.New("publish queue is not enabled")
+)
+
+type (
+	// PublishHandle tracks a publish or retraction queued via EnqueuePublish
+	// or EnqueueRetract.
+	PublishHandle struct {
+		done    chan struct{}
+		receipt *PublishReceipt
+		err     error
+	}
+
+	// QueuedPublish describes a publish or retraction waiting in the publish
+	// queue. See QueuedPublishes.
+	QueuedPublish struct {
+		ID      CatalogID `json:"id"`
+		Retract bool      `json:"retract,omitempty"`
+		// Processing is set for the publish being processed.
+		Processing bool `json:"processing,omitempty"`
+	}
+
+	// publishQueue processes publishes and retractions one at a time, in the
+	// order they are queued. When persistent, queued publishes are stored
+	// along with their multihashes until processed, and those left over by a
+	// previous instance are processed first.
+	publishQueue struct {
+		h        *Herald
+		ds       datastore.Datastore
+		recorder *publishRecorder
+		ctx      context.Context
+		cancel   context.CancelFunc
+		wake     chan struct{}
+		stopping chan struct{}
+		stopped  chan struct{}
+
+		mu      sync.Mutex
+		pending []*queuedPublish
+		next    uint64
+		closed  bool
+	}
+	queuedPublish struct {
+		handle  *PublishHandle
+		catalog Catalog
+		id      CatalogID
+		retract bool
+		opts    *publishOptions
+		// limited is set when the publish holds a slot of the limiter.
+		limited bool
+		// stored is the persisted publish, if the queue is persistent.
+		stored *queuedRecord
+	}
+	// queuedRecord is a queued publish as persisted. Its multihashes are
+	// stored in parts as those of publish records, after filtering.
+	queuedRecord struct {
+		publishRecord
+		Labels           map[string]string `json:"labels,omitempty"`
+		SkipFilters      bool              `json:"skipFilters,omitempty"`
+		PublishUnchanged bool              `json:"publishUnchanged,omitempty"`
+		ExpectHead       bool              `json:"expectHead,omitempty"`
+		ExpectedHead     cid.Cid           `json:"expectedHead"`
+	}
+)
+
+func queueKey(seq uint64) datastore.Key {
+	return queuePrefix.ChildString(fmt.Sprintf("%020d", seq))
+}
+
+func newPublishQueue(h *Herald, ds datastore.Datastore) (*publishQueue, error) {
+	q := &publishQueue{
+		h:        h,
+		ds:       ds,
+		wake:     make(chan struct{}, 1),
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	if ds != nil {
+		q.recorder = &publishRecorder{ds: ds, partsPrefix: queuePartsPrefix}
+		if err := q.load(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// start starts processing queued publishes.
+func (q *publishQueue) start() {
+	go q.run()
+}
+
+// isPending reports whether the persisted publish with the given ID is queued.
+func (q *publishQueue) isPending(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, item := range q.pending {
+		if item.stored != nil && item.stored.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// load queues the publishes persisted by a previous instance, and deletes the
+// multihashes left behind by publishes that failed to be queued.
+func (q *publishQueue) load(ctx context.Context) error {
+	results, err := q.ds.Query(ctx, query.Query{Prefix: queuePrefix.String(), Orders: []query.Order{query.OrderByKey{}}})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	ids := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		seq, err := strconv.ParseUint(datastore.RawKey(e.Key).Name(), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid queued publish key %q: %w", e.Key, err)
+		}
+		var stored queuedRecord
+		if err := json.Unmarshal(e.Value, &stored); err != nil {
+			return fmt.Errorf("failed to decode queued publish %d: %w", seq, err)
+		}
+		stored.seq = seq
+		ids[stored.ID] = struct{}{}
+		q.pending = append(q.pending, q.storedPublish(&stored))
+		q.next = seq + 1
+	}
+	if err := q.moveLegacyParts(ctx, ids); err != nil {
+		return err
+	}
+	results, err = q.ds.Query(ctx, query.Query{Prefix: queuePartsPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	parts, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		namespaces := datastore.RawKey(part.Key).Namespaces()
+		if _, ok := ids[namespaces[1]]; ok {
+			continue
+		}
+		if err := q.ds.Delete(ctx, datastore.RawKey(part.Key)); err != nil {
+			return err
+		}
+	}
+	if len(q.pending) != 0 {
+		q.h.publisherLogger.Infow("Resuming queued publishes", "count", len(q.pending))
+	}
+	return nil
+}
+
+// moveLegacyParts moves the multihashes of the given queued publishes stored
+// under recordPartsPrefix, as they were before queued publishes had their own
+// namespace, to queuePartsPrefix. Others are left to the publish recorder.
+func (q *publishQueue) moveLegacyParts(ctx context.Context, ids map[string]struct{}) error {
+	results, err := q.ds.Query(ctx, query.Query{Prefix: recordPartsPrefix.String()})
+	if err != nil {
+		return err
+	}
+	parts, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		key := datastore.RawKey(part.Key)
+		namespaces := key.Namespaces()
+		if _, ok := ids[namespaces[1]]; !ok {
+			continue
+		}
+		if err := q.ds.Put(ctx, queuePartsPrefix.Child(datastore.KeyWithNamespaces(namespaces[1:])), part.Value); err != nil {
+			return err
+		}
+		if err := q.ds.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedPublish returns the publish queued as the given record.
+func (q *publishQueue) storedPublish(stored *queuedRecord) *queuedPublish {
+	var provider peer.ID
+	if stored.Provider != "" {
+		var err error
+		if provider, err = peer.Decode(stored.Provider); err != nil {
+			q.h.publisherLogger.Warnw("ignoring invalid provider of queued publish", "id", stored.ID, "provider", stored.Provider, "err", err)
+		}
+	}
+	return &queuedPublish{
+		handle:  newPublishHandle(),
+		catalog: &recordCatalog{ctx: q.ctx, r: q.recorder, rec: &stored.publishRecord},
+		id:      stored.ContextID,
+		retract: stored.Op == recordOpRetract,
+		opts: &publishOptions{
+			appendEntries:    stored.Op == recordOpAppend,
+			metadata:         stored.Metadata,
+			providerAddrs:    stored.Addresses,
+			labels:           stored.Labels,
+			skipFilters:      stored.SkipFilters,
+			publishUnchanged: stored.PublishUnchanged,
+			expectHead:       stored.ExpectHead,
+			expectedHead:     stored.ExpectedHead,
+			queueID:          stored.ID,
+			provider:         provider,
+		},
+		stored: stored,
+	}
+}
+
+// enqueue queues the publish of the given catalog, or the retraction of the
+// catalog with the given ID.
+func (q *publishQueue) enqueue(ctx context.Context, catalog Catalog, id CatalogID, retract bool, opts *publishOptions) (*PublishHandle, error) {
+	if err := q.h.validateContextID(id); err != nil {
+		return nil, err
+	}
+	if err := q.h.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	item := &queuedPublish{
+		handle:  newPublishHandle(),
+		catalog: catalog,
+		id:      id,
+		retract: retract,
+		opts:    opts,
+		limited: true,
+	}
+	if q.ds != nil {
+		var err error
+		if item, err = q.store(ctx, item); err != nil {
+			q.h.limiter.release()
+			return nil, err
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		q.h.limiter.release()
+		return nil, ErrClosed
+	}
+	if item.stored != nil {
+		item.stored.seq = q.next
+		value, err := json.Marshal(item.stored)
+		if err == nil {
+			err = q.ds.Put(ctx, queueKey(item.stored.seq), value)
+		}
+		if err != nil {
+			q.h.limiter.release()
+			return nil, err
+		}
+		q.next++
+	}
+	q.pending = append(q.pending, item)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return item.handle, nil
+}
+
+// store writes the multihashes of the given publish to the queue datastore,
+// filtered by its publish filter, and returns the publish as read back from
+// the datastore.
+func (q *publishQueue) store(ctx context.Context, item *queuedPublish) (*queuedPublish, error) {
+	op := recordOpPublish
+	switch {
+	case item.retract:
+		op = recordOpRetract
+	case item.opts.appendEntries:
+		op = recordOpAppend
+	}
+	stored := &queuedRecord{
+		publishRecord:    *q.recorder.newRecord(op, item.id),
+		Labels:           item.opts.labels,
+		SkipFilters:      item.opts.skipFilters,
+		PublishUnchanged: item.opts.publishUnchanged,
+		ExpectHead:       item.opts.expectHead,
+		ExpectedHead:     item.opts.expectedHead,
+	}
+	stored.Metadata, stored.Addresses = item.opts.metadata, item.opts.providerAddrs
+	if item.opts.provider != "" {
+		stored.Provider = item.opts.provider.String()
+	}
+	if !item.retract {
+		var buf []byte
+		flush := func() error {
+			if err := q.ds.Put(ctx, q.recorder.partKey(stored.ID, stored.Parts), bytes.Clone(buf)); err != nil {
+				return err
+			}
+			stored.Parts++
+			buf = buf[:0]
+			return nil
+		}
+		for iter := item.catalog.Iterator(); !iter.Done(); {
+			mh, err := iter.Next()
+			if err != nil {
+				return nil, err
+			}
+			if filter := item.opts.filter; filter != nil && !item.opts.skipFilters && !filter(mh) {
+				continue
+			}
+			if buf = append(buf, mh...); len(buf) >= recordPartSize {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(buf) != 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	queued := q.storedPublish(stored)
+	queued.limited = item.limited
+	return queued, nil
+}
+
+func (q *publishQueue) run() {
+	defer close(q.stopped)
+	for {
+		q.mu.Lock()
+		var item *queuedPublish
+		if len(q.pending) != 0 {
+			item = q.pending[0]
+		}
+		q.mu.Unlock()
+		if item == nil {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.stopping:
+				return
+			}
+		}
+		select {
+		case <-q.stopping:
+			return
+		default:
+		}
+		q.process(item)
+		q.mu.Lock()
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+	}
+}
+
+func (q *publishQueue) process(item *queuedPublish) {
+	var receipt *PublishReceipt
+	var err error
+	if item.retract {
+		var ad cid.Cid
+		if ad, err = q.h.retract(q.ctx, item.id, item.opts); err == nil {
+			receipt = &PublishReceipt{Advertisement: ad}
+		}
+	} else {
+		receipt, err = q.h.publisher.dsPublisher.publish(q.ctx, item.catalog, item.opts)
+	}
+	interrupted := errors.Is(err, ErrClosed) || (err != nil && q.ctx.Err() != nil)
+	switch {
+	case interrupted:
+		q.h.publisherLogger.Warnw("queued publish interrupted by shutdown", "id", item.id, "err", err)
+	case err != nil:
+		q.h.publisherLogger.Errorw("failed to process queued publish", "id", item.id, "retract", item.retract, "err", err)
+	}
+	if item.stored != nil && !interrupted {
+		// Failed publishes are dropped rather than retried, since they would
+		// otherwise block the queue.
+		if err := q.remove(item.stored); err != nil {
+			q.h.publisherLogger.Errorw("failed to remove processed publish from queue", "seq", item.stored.seq, "err", err)
+		}
+	}
+	if item.limited {
+		q.h.limiter.release()
+	}
+	item.handle.complete(receipt, err)
+}
+
+func (q *publishQueue) remove(stored *queuedRecord) error {
+	ctx := context.Background()
+	if err := q.ds.Delete(ctx, queueKey(stored.seq)); err != nil {
+		return err
+	}
+	for part := 0; part < stored.Parts; part++ {
+		if err := q.ds.Delete(ctx, q.recorder.partKey(stored.ID, part)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop stops processing queued publishes once the one in progress, if any,
+// completes. It is interrupted if the context is done first. Publishes left in
+// the queue fail with ErrClosed, and persisted ones are processed once Herald
+// is created anew.
+func (q *publishQueue) stop(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+	close(q.stopping)
+	var err error
+	select {
+	case <-q.stopped:
+	case <-ctx.Done():
+		err = ctx.Err()
+		q.cancel()
+		<-q.stopped
+	}
+	q.cancel()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, item := range q.pending {
+		if item.limited {
+			q.h.limiter.release()
+		}
+		item.handle.complete(nil, ErrClosed)
+	}
+	q.pending = nil
+	return err
+}
+
+func newPublishHandle() *PublishHandle {
+	return &PublishHandle{done: make(chan struct{})}
+}
+
+func (p *PublishHandle) complete(receipt *PublishReceipt, err error) {
+	p.receipt, p.err = receipt, err
+	close(p.done)
+}
+
+// Done returns a channel that is closed once the publish is processed.
+func (p *PublishHandle) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait waits for the publish to be processed and returns its receipt, of
+// which only Advertisement is set for retractions. If the context is done
+// first, the context error is returned and the publish remains queued.
+func (p *PublishHandle) Wait(ctx context.Context) (*PublishReceipt, error) {
+	select {
+	case <-p.done:
+		return p.receipt, p.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// EnqueuePublish queues the publish of the given catalog, and returns a handle
+// through which its receipt is awaited. Queued publishes and retractions are
+// processed one at a time, in the order they are queued. When the queue is
+// persistent, the multihashes of the catalog are stored before EnqueuePublish
+// returns, and the catalog is not iterated again. It fails with
+// ErrNoPublishQueue unless enabled via WithPublishQueue.
+func (h *Herald) EnqueuePublish(ctx context.Context, catalog Catalog, o ...PublishOption) (*PublishHandle, error) {
+	if h.queue == nil {
+		return nil, ErrNoPublishQueue
+	}
+	opts, err := h.newPublishOptions(o...)
+	if err != nil {
+		return nil, err
+	}
+	return h.queue.enqueue(ctx, catalog, catalog.ID(), false, opts)
+}
+
+// EnqueueRetract queues the retraction of the catalog with the given ID, like
+// EnqueuePublish.
+func (h *Herald) EnqueueRetract(ctx context.Context, id CatalogID, o ...PublishOption) (*PublishHandle, error) {
+	if h.queue == nil {
+		return nil, ErrNoPublishQueue
+	}
+	opts, err := h.newPublishOptions(o...)
+	if err != nil {
+		return nil, err
+	}
+	return h.queue.enqueue(ctx, nil, id, true, opts)
+}
+
+// QueuedPublishes returns the publishes and retractions that are queued, in the
+// order they are processed, starting with the one being processed, if any. It
+// fails with ErrNoPublishQueue unless enabled via WithPublishQueue.
+func (h *Herald) QueuedPublishes() ([]QueuedPublish, error) {
+	if h.queue == nil {
+		return nil, ErrNoPublishQueue
+	}
+	return h.queue.list(), nil
+}
+
+func (q *publishQueue) list() []QueuedPublish {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := make([]QueuedPublish, 0, len(q.pending))
+	for i, item := range q.pending {
+		// The run loop processes the first pending publish.
+		queued = append(queued, QueuedPublish{ID: item.id, Retract: item.retract, Processing: i == 0})
+	}
+	return queued
+}
+
+// publishQueued publishes via the queue and waits for the receipt.
+func (h *Herald) publishQueued(ctx context.Context, catalog Catalog, id CatalogID, retract bool, opts *publishOptions) (*PublishReceipt, error) {
+	handle, err := h.queue.enqueue(ctx, catalog, id, retract, opts)
+	if err != nil {
+		return nil, err
+	}
+	return handle.Wait(ctx)
+}
@@ -0,0 +1,71 @@
+package herald_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestPublishQueueSharesDatastoreWithRecorder(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	shared := dssync.MutexWrap(datastore.NewMapDatastore())
+	start := func() *herald.Herald {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := herald.New(heraldtest.Options(
+			herald.WithDatastore(ds),
+			herald.WithPublishQueue(shared),
+			herald.WithPublishRecorder(shared),
+			herald.WithListener(l),
+		)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	h := start()
+	if _, err := h.Publish(ctx, heraldtest.Catalog("a", 3)); err != nil {
+		t.Fatal(err)
+	}
+	head, err := h.Publish(ctx, heraldtest.Catalog("b", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.EnqueueRetract(ctx, []byte("a"), herald.WithPublishProvider(peer.ID("unregistered"))); !errors.Is(err, herald.ErrProviderNotFound) {
+		t.Fatalf("expected ErrProviderNotFound, got %v", err)
+	}
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Restarting loads the queue, which must leave the recorded multihashes.
+	if err := start().Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, err := herald.New(heraldtest.Options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.Close()
+	got, err := replayed.Replay(ctx, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(head) {
+		t.Fatalf("expected replayed head %s, got %s", head, got)
+	}
+}
@@ -0,0 +1,161 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+type (
+	// BlobStore is an object store, such as an S3 or GCS bucket, to which
+	// the advertisement chain is written by BlobStoreTransport. Keys are
+	// slash-separated paths relative to the root at which the store is
+	// served.
+	BlobStore interface {
+		// Has reports whether a blob is stored under the given key.
+		Has(ctx context.Context, key string) (bool, error)
+		// Put stores the given blob under the given key, replacing any
+		// existing one. The blob must never be served partially written.
+		Put(ctx context.Context, key string, data []byte) error
+	}
+
+	// blobPublisher writes the advertisement chain to a BlobStore laid out as
+	// the ipnisync HTTP endpoints, so that it can be served by any static
+	// file server or CDN: blocks are written to "ipni/v1/ad/{cid}" and the
+	// signed head to "ipni/v1/ad/head".
+	//
+	// The blocks of an advertisement, including its entries and the
+	// advertisements before it, are written before the advertisement itself,
+	// which is in turn written before the head. An existing advertisement
+	// blob therefore implies that the chain it ends is complete.
+	blobPublisher struct {
+		h     *Herald
+		ds    *dsPublisher
+		store BlobStore
+		mu    sync.Mutex
+	}
+)
+
+// BlobStoreTransport writes the chain to the given BlobStore, e.g. to serve
+// it from a CDN without running the HTTP publisher. Blobs are written ahead
+// of every head update, and are never deleted. Announcements should carry the
+// URL at which the store is served, set via WithPublisherAddrs.
+func BlobStoreTransport(store BlobStore) PublisherTransport {
+	return func(o *options) error {
+		if store == nil {
+			return errors.New("blob store must not be nil")
+		}
+		o.blobStore = store
+		return nil
+	}
+}
+
+func newBlobPublisher(h *Herald, ds *dsPublisher, store BlobStore) *blobPublisher {
+	return &blobPublisher{h: h, ds: ds, store: store}
+}
+
+// Start writes the blobs of the current head, if any, such that a new store
+// catches up with the chain published so far.
+func (p *blobPublisher) Start(ctx context.Context) error {
+	current, err := p.ds.GetHead(ctx)
+	if err != nil || !current.Defined() {
+		return err
+	}
+	return p.publish(ctx, current)
+}
+
+func blobKey(name string) string {
+	return path.Join(strings.TrimPrefix(ipnisync.IpniPath, "/"), name)
+}
+
+// publish writes the blobs of the chain ending with the given head, followed
+// by the signed head.
+func (p *blobPublisher) publish(ctx context.Context, newHead cid.Cid) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var ads []cid.Cid
+	if err := p.ds.walkChain(ctx, newHead, func(c cid.Cid, _ *schema.Advertisement) (bool, error) {
+		if exists, err := p.store.Has(ctx, blobKey(c.String())); err != nil || exists {
+			return false, err
+		}
+		ads = append(ads, c)
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	for i := len(ads) - 1; i >= 0; i-- {
+		if err := p.writeAdvertisement(ctx, ads[i]); err != nil {
+			return err
+		}
+	}
+	signedHead, err := head.NewSignedHead(newHead, p.h.topic, p.h.identity)
+	if err != nil {
+		return err
+	}
+	data, err := signedHead.Encode()
+	if err != nil {
+		return err
+	}
+	if err := p.store.Put(ctx, blobKey("head"), data); err != nil {
+		return err
+	}
+	p.h.publisherLogger.Debugw("wrote advertisement chain blobs", "head", newHead, "ads", len(ads))
+	return nil
+}
+
+// writeAdvertisement writes the blocks of the entries of the given
+// advertisement that are not yet written, children first, then the
+// advertisement itself.
+func (p *blobPublisher) writeAdvertisement(ctx context.Context, c cid.Cid) error {
+	data, err := p.ds.h.ds.Get(ctx, dsKey(cidlink.Link{Cid: c}))
+	if err != nil {
+		return fmt.Errorf("failed to get advertisement %s: %w", c, err)
+	}
+	ad, err := decodeAdvertisement(c, data)
+	if err != nil {
+		return err
+	}
+	if hasEntries(ad.Entries) {
+		var blocks []cid.Cid
+		var values [][]byte
+		for queue := []cid.Cid{ad.Entries.(cidlink.Link).Cid}; len(queue) != 0; queue = queue[1:] {
+			next := queue[0]
+			if exists, err := p.store.Has(ctx, blobKey(next.String())); err != nil {
+				return err
+			} else if exists {
+				continue
+			}
+			value, err := p.ds.entriesDs.Get(ctx, dsKey(cidlink.Link{Cid: next}))
+			switch {
+			case errors.Is(err, datastore.ErrNotFound):
+				// Entries of retracted catalogs may have been deleted.
+				continue
+			case err != nil:
+				return err
+			}
+			links, err := blockLinks(next, value)
+			if err != nil {
+				return err
+			}
+			blocks, values = append(blocks, next), append(values, value)
+			queue = append(queue, links...)
+		}
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if err := p.store.Put(ctx, blobKey(blocks[i].String()), values[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return p.store.Put(ctx, blobKey(c.String()), data)
+}
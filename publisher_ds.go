@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
@@ -13,12 +17,18 @@ import (
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	_ Publisher     = (*dsPublisher)(nil)
-	_ io.ReadCloser = (*pooledBytesBufferCloser)(nil)
+	_ io.ReadCloser = bytesReadCloser{}
+	_ io.Seeker     = bytesReadCloser{}
+	_ sizer         = bytesReadCloser{}
+	_ sizer         = (*sizedReadCloser)(nil)
 
 	bytesBuffers = sync.Pool{
 		New: func() any { return new(bytes.Buffer) },
@@ -31,155 +41,778 @@ type (
 	dsPublisher struct {
 		h      *Herald
 		locker sync.RWMutex
-		ls     ipld.LinkSystem
+		// gcLocker is held for reading while blocks not yet reachable from
+		// the head are written, and for writing while collecting orphans.
+		gcLocker  sync.RWMutex
+		ls        ipld.LinkSystem
+		entriesDs datastore.Datastore
+		entriesLs ipld.LinkSystem
+		// recorder records publishes when WithPublishRecorder is set.
+		recorder *publishRecorder
+		// readOnly is set once the chain is handed over to another instance.
+		readOnly bool
+		// closed is set once Herald is shut down, and is guarded by gcLocker.
+		closed bool
+		// providerAddrs are the addresses of advertisements, as updated by
+		// UpdateAddresses.
+		providerAddrs atomic.Pointer[[]string]
+		// catalogProviders are the providers of tracked catalog statuses,
+		// guarded by locker.
+		catalogProviders map[peer.ID]struct{}
 	}
-	pooledBytesBufferCloser struct {
-		buf *bytes.Buffer
+	// bytesReadCloser reads a value that was loaded entirely, without
+	// copying it.
+	bytesReadCloser struct {
+		*bytes.Reader
+	}
+	sizedReadCloser struct {
+		io.ReadCloser
+		size int64
+	}
+	sizer interface {
+		Size() int64
+	}
+	// pendingBatch writes to batches of a datastore, committed every size
+	// writes, or only once committed explicitly if size is not positive.
+	// Pending writes are readable, since chunkers such as the HAMT
+	// chunker may load the blocks they store. It is safe for concurrent use,
+	// since chunkers may flush chunks from a timer.
+	pendingBatch struct {
+		ds      datastore.Batching
+		size    int
+		mu      sync.Mutex
+		batch   datastore.Batch
+		pending map[datastore.Key][]byte
+	}
+
+	// StreamingDatastore is implemented by datastores that can stream values
+	// without loading them into memory entirely. When the datastore backing
+	// Herald implements it, content is streamed directly to the response.
+	StreamingDatastore interface {
+		datastore.Datastore
+		GetReader(context.Context, datastore.Key) (io.ReadCloser, error)
 	}
 )
 
 func newDsPublisher(h *Herald) (*dsPublisher, error) {
 	var ds dsPublisher
 	ds.h = h
-	ds.ls = cidlink.DefaultLinkSystem()
-	ds.ls.StorageReadOpener = ds.storageReadOpener
-	ds.ls.StorageWriteOpener = ds.storageWriteOpener
+	ds.providerAddrs.Store(&h.providerAddrs)
+	ds.ls = newDsLinkSystem(h.ds, h.maxAdSize, ErrAdTooLarge, h.tracer)
+	ds.entriesDs = h.ds
+	if h.entriesDs != nil {
+		ds.entriesDs = h.entriesDs
+	}
+	ds.entriesLs = newDsLinkSystem(ds.entriesDs, h.maxEntryChunkSize, ErrEntryChunkTooLarge, h.tracer)
+	if h.recorderDs != nil {
+		var err error
+		if ds.recorder, err = newPublishRecorder(context.Background(), h.recorderDs); err != nil {
+			return nil, err
+		}
+	}
+	if err := ds.loadCatalogProviders(context.Background()); err != nil {
+		return nil, err
+	}
 	return &ds, nil
 }
 
-func (l *dsPublisher) storageWriteOpener(ctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
-	buf := bytesBuffers.Get().(*bytes.Buffer)
-	buf.Reset()
-	return buf, func(lnk ipld.Link) error {
-		defer bytesBuffers.Put(buf)
-		return l.h.ds.Put(ctx.Ctx, dsKey(lnk), buf.Bytes())
-	}, nil
+// newDsLinkSystem instantiates a link system that stores blocks in the given
+// datastore, refusing to store blocks larger than maxBlockSize bytes with an
+// error wrapping errTooLarge. A non-positive maxBlockSize disables the limit.
+// Every store is traced with the given tracer.
+func newDsLinkSystem(ds datastore.Datastore, maxBlockSize int, errTooLarge error, tracer trace.Tracer) ipld.LinkSystem {
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageReadOpener = dsReadOpener(ds)
+	ls.StorageWriteOpener = dsWriteOpener(ds, maxBlockSize, errTooLarge, tracer)
+	return ls
 }
 
-func (l *dsPublisher) storageReadOpener(ctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
-	val, err := l.h.ds.Get(ctx.Ctx, dsKey(lnk))
-	if err != nil {
-		return nil, err
+// dsReadOpener returns a block read opener that gets blocks from the given
+// datastore.
+func dsReadOpener(ds interface {
+	Get(context.Context, datastore.Key) ([]byte, error)
+}) linking.BlockReadOpener {
+	return func(ctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		val, err := ds.Get(ctx.Ctx, dsKey(lnk))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewBuffer(val), nil
 	}
-	return bytes.NewBuffer(val), nil
+}
+
+// dsWriteOpener returns a block write opener that puts blocks to the given
+// datastore, with the same limit as newDsLinkSystem.
+func dsWriteOpener(ds datastore.Write, maxBlockSize int, errTooLarge error, tracer trace.Tracer) linking.BlockWriteOpener {
+	return func(ctx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		buf := bytesBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+		return buf, func(lnk ipld.Link) (err error) {
+			defer bytesBuffers.Put(buf)
+			if maxBlockSize > 0 && buf.Len() > maxBlockSize {
+				return fmt.Errorf("%w: encoded size of %d bytes exceeds the limit of %d bytes", errTooLarge, buf.Len(), maxBlockSize)
+			}
+			_, span := tracer.Start(ctx.Ctx, "datastore.Put", trace.WithAttributes(
+				attribute.String("cid", lnk.String()),
+				attribute.Int("size", buf.Len())))
+			defer func() { endSpan(span, err) }()
+			// Copy the encoded block, since datastores may retain the value
+			// after Put returns, while the buffer is reused once pooled.
+			return ds.Put(ctx.Ctx, dsKey(lnk), bytes.Clone(buf.Bytes()))
+		}, nil
+	}
+}
+
+// adLinkSystem returns the link system through which advertisements are
+// written, along with the batch its writes are grouped into, if any, which is
+// committed along with the head so that neither is stored without the other.
+// Advertisements are written right away when blob publishers are configured,
+// since they read them from the datastore before the head is set.
+func (l *dsPublisher) adLinkSystem() (ipld.LinkSystem, *pendingBatch) {
+	bds, ok := l.h.ds.(datastore.Batching)
+	if !ok || len(l.h.blobPublishers) != 0 {
+		return l.ls, nil
+	}
+	batch := &pendingBatch{ds: bds}
+	ls := l.ls
+	ls.StorageReadOpener = dsReadOpener(batch)
+	ls.StorageWriteOpener = dsWriteOpener(batch, l.h.maxAdSize, ErrAdTooLarge, l.h.tracer)
+	return ls, batch
+}
+
+// entriesLinkSystem returns the link system through which entry chunks are
+// written, along with the batch its writes are grouped into, if any, which
+// must be committed once all chunks are written.
+func (l *dsPublisher) entriesLinkSystem() (ipld.LinkSystem, *pendingBatch) {
+	bds, ok := l.entriesDs.(datastore.Batching)
+	if !ok || l.h.entriesBatchSize < 2 {
+		return l.entriesLs, nil
+	}
+	batch := &pendingBatch{ds: bds, size: l.h.entriesBatchSize}
+	ls := l.entriesLs
+	ls.StorageReadOpener = dsReadOpener(batch)
+	ls.StorageWriteOpener = dsWriteOpener(batch, l.h.maxEntryChunkSize, ErrEntryChunkTooLarge, l.h.tracer)
+	return ls, batch
+}
+
+func (b *pendingBatch) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.begin(ctx); err != nil {
+		return err
+	}
+	if err := b.batch.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if b.pending[key] = value; b.size > 0 && len(b.pending) >= b.size {
+		return b.commit(ctx)
+	}
+	return nil
+}
+
+func (b *pendingBatch) Delete(ctx context.Context, key datastore.Key) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.begin(ctx); err != nil {
+		return err
+	}
+	delete(b.pending, key)
+	return b.batch.Delete(ctx, key)
+}
+
+// begin starts a batch, unless one is pending.
+func (b *pendingBatch) begin(ctx context.Context) error {
+	if b.batch != nil {
+		return nil
+	}
+	var err error
+	if b.batch, err = b.ds.Batch(ctx); err != nil {
+		return err
+	}
+	b.pending = make(map[datastore.Key][]byte, b.size)
+	return nil
+}
+
+func (b *pendingBatch) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	b.mu.Lock()
+	value, ok := b.pending[key]
+	b.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	return b.ds.Get(ctx, key)
+}
+
+// Commit commits the pending writes, if any.
+func (b *pendingBatch) Commit(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.commit(ctx)
+}
+
+func (b *pendingBatch) commit(ctx context.Context) error {
+	if b.batch == nil {
+		return nil
+	}
+	batch := b.batch
+	b.batch, b.pending = nil, nil
+	return batch.Commit(ctx)
 }
 
 func dsKey(l ipld.Link) datastore.Key {
-	return datastore.NewKey(l.(cidlink.Link).Cid.String())
+	return blockKey(l.(cidlink.Link).Cid.Hash())
+}
+
+// blockKey returns the key at which the block with the given multihash is
+// stored. Blocks are keyed by multihash so that they can be looked up
+// regardless of the codec and CID version used to refer to them.
+func blockKey(mh multihash.Multihash) datastore.Key {
+	return blocksPrefix.ChildString(mh.B58String())
 }
 
 func (l *dsPublisher) Publish(ctx context.Context, catalog Catalog) (cid.Cid, error) {
-	entries, err := l.generateEntries(ctx, catalog)
+	receipt, err := l.publish(ctx, catalog, &publishOptions{})
 	if err != nil {
 		return cid.Undef, err
 	}
-	return l.generateAdvertisement(ctx, catalog.ID(), entries, false)
+	return receipt.Advertisement, nil
 }
 
-func (l *dsPublisher) generateEntriesChunk(ctx context.Context, next ipld.Link, mhs []multihash.Multihash) (ipld.Link, error) {
-	chunk, err := schema.EntryChunk{
-		Entries: mhs,
-		Next:    next,
-	}.ToNode()
+func (l *dsPublisher) PublishAppend(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	receipt, err := l.publish(ctx, catalog, &publishOptions{appendEntries: true})
+	if err != nil {
+		return cid.Undef, err
+	}
+	return receipt.Advertisement, nil
+}
+
+func (l *dsPublisher) PublishWithOptions(ctx context.Context, catalog Catalog, opts *publishOptions) (*PublishReceipt, error) {
+	return l.publish(ctx, catalog, opts)
+}
+
+func (l *dsPublisher) publish(ctx context.Context, catalog Catalog, opts *publishOptions) (_ *PublishReceipt, err error) {
+	ctx, span := l.h.tracer.Start(ctx, "Publish", trace.WithAttributes(
+		attribute.String("contextID", catalogKeyString(catalog.ID())),
+		attribute.Bool("append", opts.appendEntries)))
+	defer func() { endSpan(span, err) }()
+	l.gcLocker.RLock()
+	defer l.gcLocker.RUnlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if err := l.h.validateContextID(catalog.ID()); err != nil {
+		return nil, err
+	}
+	journal, err := l.beginJournal(ctx, catalog.ID(), opts.queueID)
+	if err != nil {
+		return nil, err
+	}
+	defer l.endJournal(journal)
+	var previous ipld.Link
+	if opts.appendEntries {
+		switch _, ad, err := l.latestAdvertisement(ctx, catalog.ID()); {
+		case errors.Is(err, ErrCatalogNotFound):
+			l.h.publisherLogger.Debugw("no previous entries to append to; publishing catalog as new", "id", catalog.ID())
+		case err != nil:
+			return nil, err
+		case hasEntries(ad.Entries):
+			previous = ad.Entries
+		}
+	}
+	if l.recorder != nil {
+		op := recordOpPublish
+		if opts.appendEntries {
+			op = recordOpAppend
+		}
+		opts.record = l.recorder.newRecord(op, catalog.ID())
+	}
+	l.h.events.emit(PublishStarted{ID: catalog.ID(), Append: opts.appendEntries})
+	var receipt PublishReceipt
+	start := time.Now()
+	entries, err := l.generateEntries(ctx, catalog, previous, l.publishFilter(opts), opts.record, &receipt)
 	if err != nil {
 		return nil, err
 	}
-	return l.ls.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, chunk)
+	took := time.Since(start)
+	if entries == nil {
+		entries = schema.NoEntries
+	} else {
+		receipt.Entries = entries.(cidlink.Link).Cid
+	}
+	// Entries that were all stored before may be those of the latest
+	// advertisement of the catalog, in which case there is nothing to publish.
+	opts.skipUnchanged = !opts.publishUnchanged && receipt.ReusedChunkCount == receipt.ChunkCount
+	if opts.multihashCount == 0 {
+		opts.multihashCount = receipt.MultihashCount
+	}
+	if receipt.Advertisement, err = l.generateAdvertisement(ctx, catalog.ID(), entries, false, opts); err != nil {
+		return nil, err
+	}
+	receipt.Unchanged = opts.unchanged
+	l.h.metrics.observeEntries(&receipt, took)
+	return &receipt, nil
+}
+
+// publishFilter combines the content filter configured on Herald with the
+// one given for an individual publish.
+func (l *dsPublisher) publishFilter(opts *publishOptions) func(multihash.Multihash) bool {
+	if opts.skipFilters {
+		return nil
+	}
+	var filters []func(multihash.Multihash) bool
+	if l.h.contentFilter != nil {
+		filters = append(filters, l.h.contentFilter)
+	}
+	if l.h.badbits != nil {
+		filters = append(filters, l.h.badbits.Allowed)
+	}
+	if opts.filter != nil {
+		filters = append(filters, opts.filter)
+	}
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0]
+	default:
+		return func(mh multihash.Multihash) bool {
+			for _, allowed := range filters {
+				if !allowed(mh) {
+					return false
+				}
+			}
+			return true
+		}
+	}
 }
 
-func (l *dsPublisher) generateEntries(ctx context.Context, catalog Catalog) (ipld.Link, error) {
-	mhs := make([]multihash.Multihash, 0, l.h.adEntriesChunkSize)
-	var next ipld.Link
-	var mhCount, chunkCount int
+// generateEntries lays out the multihashes in the catalog using the configured
+// entry chunker, linking them to next when appending to existing entries.
+// The multihashes are also written to the given record, if any.
+func (l *dsPublisher) generateEntries(ctx context.Context, catalog Catalog, next ipld.Link, filter func(multihash.Multihash) bool, rec *publishRecord, receipt *PublishReceipt) (_ ipld.Link, err error) {
+	ctx, span := l.h.tracer.Start(ctx, "generateEntries")
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("multihashCount", receipt.MultihashCount),
+			attribute.Int("chunkCount", receipt.ChunkCount),
+			attribute.Int("filteredCount", receipt.FilteredCount))
+		endSpan(span, err)
+	}()
+	var mhCount, chunkCount, filteredCount, reusedCount int
+	ls, batch := l.entriesLinkSystem()
+	ls = countingLinkSystem(dedupingLinkSystem(ls, l.entriesDs, &reusedCount), &chunkCount)
+	chunker, err := l.h.entryChunker(withLoggers(withLinkPrototype(ctx, l.h.linkPrototype), l.h.loggers), ls, next)
+	if err != nil {
+		return nil, err
+	}
+	if rec != nil {
+		chunker = l.recorder.recordingChunker(ctx, rec, chunker)
+	}
 	for iter := catalog.Iterator(); !iter.Done(); {
 		mh, err := iter.Next()
 		if err != nil {
 			return nil, err
 		}
-		mhs = append(mhs, mh)
-		mhCount++
-		if len(mhs) >= l.h.adEntriesChunkSize {
-			next, err = l.generateEntriesChunk(ctx, next, mhs)
-			if err != nil {
-				return nil, err
-			}
-			chunkCount++
-			mhs = mhs[:0]
+		if filter != nil && !filter(mh) {
+			filteredCount++
+			continue
+		}
+		if err := chunker.Add(mh); err != nil {
+			return nil, err
 		}
+		mhCount++
 	}
-	if len(mhs) != 0 {
-		var err error
-		next, err = l.generateEntriesChunk(ctx, next, mhs)
-		if err != nil {
+	root, err := chunker.Finish()
+	if err != nil {
+		return nil, err
+	}
+	if batch != nil {
+		if err := batch.Commit(ctx); err != nil {
 			return nil, err
 		}
-		chunkCount++
 	}
-	logger.Infow("Generated linked chunks of multihashes", "link", next, "totalMhCount", mhCount, "chunkCount", chunkCount)
-	return next, nil
+	receipt.MultihashCount, receipt.ChunkCount, receipt.FilteredCount, receipt.ReusedChunkCount = mhCount, chunkCount, filteredCount, reusedCount
+	l.h.publisherLogger.Infow("Generated entries", "root", root, "totalMhCount", mhCount, "chunkCount", chunkCount, "filteredCount", filteredCount, "reusedChunkCount", reusedCount)
+	generated := ChunksGenerated{ID: catalog.ID(), MultihashCount: mhCount, ChunkCount: chunkCount, FilteredCount: filteredCount, ReusedChunkCount: reusedCount}
+	if root != nil {
+		generated.Entries = root.(cidlink.Link).Cid
+	}
+	l.h.events.emit(generated)
+	return root, nil
 }
 
 func (l *dsPublisher) Retract(ctx context.Context, id CatalogID) (cid.Cid, error) {
-	// TODO: find removed entries and remove from the datastore
-	return l.generateAdvertisement(ctx, id, schema.NoEntries, true)
+	return l.retract(ctx, id, &publishOptions{})
 }
 
-func (l *dsPublisher) generateAdvertisement(ctx context.Context, id CatalogID, entries ipld.Link, isRm bool) (cid.Cid, error) {
+func (l *dsPublisher) retract(ctx context.Context, id CatalogID, opts *publishOptions) (_ cid.Cid, err error) {
+	ctx, span := l.h.tracer.Start(ctx, "Retract", trace.WithAttributes(attribute.String("contextID", catalogKeyString(id))))
+	defer func() { endSpan(span, err) }()
+	l.gcLocker.RLock()
+	defer l.gcLocker.RUnlock()
+	if l.closed {
+		return cid.Undef, ErrClosed
+	}
+	if err := l.h.validateContextID(id); err != nil {
+		return cid.Undef, err
+	}
+	if l.recorder != nil {
+		opts.record = l.recorder.newRecord(recordOpRetract, id)
+	}
+	ad, err := l.generateAdvertisement(ctx, id, schema.NoEntries, true, opts)
+	if err != nil {
+		return cid.Undef, err
+	}
+	l.h.events.emit(RetractCompleted{ID: id, Advertisement: ad})
+	return ad, nil
+}
+
+// remove publishes a removal advertisement whose entries are the multihashes in
+// the given catalog, which indexers remove from the catalog while keeping the
+// rest of its multihashes.
+func (l *dsPublisher) remove(ctx context.Context, catalog Catalog, opts *publishOptions) (_ cid.Cid, err error) {
+	ctx, span := l.h.tracer.Start(ctx, "Remove", trace.WithAttributes(attribute.String("contextID", catalogKeyString(catalog.ID()))))
+	defer func() { endSpan(span, err) }()
+	l.gcLocker.RLock()
+	defer l.gcLocker.RUnlock()
+	if l.closed {
+		return cid.Undef, ErrClosed
+	}
+	if err := l.h.validateContextID(catalog.ID()); err != nil {
+		return cid.Undef, err
+	}
+	if l.recorder != nil {
+		opts.record = l.recorder.newRecord(recordOpRemove, catalog.ID())
+	}
+	// Removed multihashes are not filtered, since they may have been
+	// published before being denied.
+	var receipt PublishReceipt
+	entries, err := l.generateEntries(ctx, catalog, nil, nil, opts.record, &receipt)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if entries == nil {
+		return cid.Undef, errors.New("no multihashes to remove")
+	}
+	return l.generateAdvertisement(ctx, catalog.ID(), entries, true, opts)
+}
+
+func (l *dsPublisher) generateAdvertisement(ctx context.Context, id CatalogID, entries ipld.Link, isRm bool, opts *publishOptions) (_ cid.Cid, err error) {
+	ctx, span := l.h.tracer.Start(ctx, "generateAdvertisement", trace.WithAttributes(attribute.Bool("isRm", isRm)))
+	defer func() { endSpan(span, err) }()
 	l.locker.Lock()
 	defer l.locker.Unlock()
 
+	if l.readOnly {
+		return cid.Undef, ErrReadOnly
+	}
 	var previousID ipld.Link
-	if head, err := l.GetHead(ctx); err != nil {
+	head, err := l.GetHead(ctx)
+	if err != nil {
 		return cid.Undef, err
-	} else if !cid.Undef.Equals(head) {
+	}
+	if opts.expectHead && !head.Equals(opts.expectedHead) {
+		return cid.Undef, fmt.Errorf("%w: expected %s but is %s", ErrHeadMoved, headString(opts.expectedHead), headString(head))
+	}
+	if !cid.Undef.Equals(head) {
 		previousID = cidlink.Link{Cid: head}
 	}
 	ad := schema.Advertisement{
 		PreviousID: previousID,
-		Provider:   l.h.id.String(),
-		Addresses:  l.h.providerAddrs,
+		Provider:   l.h.providerID.String(),
+		Addresses:  *l.providerAddrs.Load(),
 		Entries:    entries,
 		ContextID:  id,
 		Metadata:   l.h.metadata,
 		IsRm:       isRm,
 	}
+	// Catalogs stay with the provider they were published for, which must
+	// also be the provider of their removals.
+	if opts.provider == "" && (isRm || l.h.providers.len() != 0) {
+		provider, p, err := l.catalogProvider(ctx, id, isRm)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if provider != "" {
+			ad.Provider, ad.Addresses, ad.Metadata = provider.String(), p.addrs, p.metadata
+		}
+	}
+	if opts.provider != "" {
+		ad.Provider = opts.provider.String()
+	}
+	if opts.metadata != nil {
+		ad.Metadata = opts.metadata
+	}
+	if opts.providerAddrs != nil {
+		ad.Addresses = opts.providerAddrs
+	}
+	if opts.skipUnchanged {
+		switch latest, err := l.unchangedAdvertisement(ctx, &ad); {
+		case err != nil:
+			return cid.Undef, err
+		case latest.Defined():
+			l.h.publisherLogger.Infow("Skipped publishing unchanged catalog", "id", id, "ad", latest)
+			opts.unchanged = true
+			return latest, nil
+		}
+	}
+	if err := ad.Validate(); err != nil {
+		l.h.publisherLogger.Errorw("generated advertisement is invalid", "err", err)
+		return cid.Undef, fmt.Errorf("%w: %v", ErrInvalidAdvertisement, err)
+	}
 	if err := ad.Sign(l.h.identity); err != nil {
-		logger.Errorw("failed to sign advertisement", "err", err)
+		l.h.publisherLogger.Errorw("failed to sign advertisement", "err", err)
 		return cid.Undef, err
 	}
+	if l.h.validateOnPublish {
+		if err := l.validateAdvertisement(ctx, &ad, false); err != nil {
+			l.h.publisherLogger.Errorw("generated advertisement fails ingest validation", "err", err)
+			return cid.Undef, err
+		}
+	}
 	adNode, err := ad.ToNode()
 	if err != nil {
-		logger.Errorw("failed to generate IPLD node from advertisement", "err", err)
+		l.h.publisherLogger.Errorw("failed to generate IPLD node from advertisement", "err", err)
 		return cid.Undef, err
 	}
-	adLink, err := l.ls.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, adNode)
+	ls, batch := l.adLinkSystem()
+	adLink, err := ls.Store(ipld.LinkContext{Ctx: ctx}, l.h.linkPrototype, adNode)
 	if err != nil {
-		logger.Errorw("failed to store advertisement", "err", err)
+		l.h.publisherLogger.Errorw("failed to store advertisement", "err", err)
 		return cid.Undef, err
 	}
 
 	newHead := adLink.(cidlink.Link).Cid
-	if err := l.h.ds.Put(ctx, headKey, newHead.Bytes()); err != nil {
-		logger.Errorw("failed to set new head", "newHead", newHead, "err", err)
+	l.h.events.emit(AdStored{ID: id, Advertisement: newHead, IsRm: isRm})
+	// Removals with entries remove only those from the catalog.
+	retracted := isRm && !hasEntries(entries)
+	span.SetAttributes(attribute.String("ad", newHead.String()))
+	// The indexes of the advertisement are committed along with the head, so
+	// that they are not updated by a publish whose advertisement fails to
+	// become the head. Datastores that do not batch are written once the
+	// head is set instead.
+	if batch == nil {
+		if bds, ok := l.h.ds.(datastore.Batching); ok {
+			batch = &pendingBatch{ds: bds}
+		}
+	}
+	if batch != nil {
+		if err := l.indexAdvertisement(ctx, batch, id, newHead, entries, retracted, opts); err != nil {
+			return cid.Undef, err
+		}
+	}
+	// The publish is recorded before the head is set, so that a publish whose
+	// advertisement became the head is always recorded; the record is removed
+	// otherwise.
+	if opts.record != nil {
+		opts.record.Metadata, opts.record.Addresses, opts.record.Ad = ad.Metadata, ad.Addresses, newHead.String()
+		if ad.Provider != l.h.providerID.String() {
+			opts.record.Provider = ad.Provider
+		}
+		if err := l.recorder.commit(ctx, opts.record); err != nil {
+			l.h.publisherLogger.Errorw("failed to record publish", "err", err)
+			return cid.Undef, err
+		}
+	}
+	if err := l.commitHead(ctx, newHead, batch); err != nil {
+		// The last committed record is removed, since its advertisement
+		// failed to become the head.
+		if opts.record != nil {
+			if err := l.recorder.remove(ctx, opts.record); err != nil {
+				l.h.logger.Errorw("failed to revert publish record", "seq", opts.record.seq, "err", err)
+			}
+		}
 		return cid.Undef, err
 	}
+	if batch == nil {
+		if err := l.indexAdvertisement(ctx, l.h.ds, id, newHead, entries, retracted, opts); err != nil {
+			// The publish succeeded regardless.
+			l.h.publisherLogger.Errorw("failed to index advertisement", "id", id, "ad", newHead, "err", err)
+		}
+	}
+	if opts.updateProviderAddrs {
+		l.providerAddrs.Store(&opts.providerAddrs)
+	}
+	status := &CatalogStatus{
+		ID:             id,
+		MultihashCount: opts.multihashCount,
+		Published:      time.Now(),
+		Retracted:      retracted,
+	}
+	// The provider of the advertisement is always encoded from a peer ID.
+	status.Provider, _ = peer.Decode(ad.Provider)
+	if hasEntries(entries) {
+		status.Entries = entries.(cidlink.Link).Cid
+	}
+	if err := l.trackCatalog(ctx, head, newHead, status, opts.appendEntries); err != nil {
+		// The publish succeeded regardless; the status is caught up with the
+		// chain once read.
+		l.h.publisherLogger.Errorw("failed to track catalog status", "id", id, "err", err)
+	}
+	l.h.metrics.observePublished(isRm)
 	return newHead, nil
 }
 
+// indexAdvertisement writes the entries, publish time and labels of the given
+// advertisement, as enabled by the options, to the given datastore.
+func (l *dsPublisher) indexAdvertisement(ctx context.Context, w datastore.Write, id CatalogID, ad cid.Cid, entries ipld.Link, retracted bool, opts *publishOptions) error {
+	if l.h.retractedEntriesRetention >= 0 {
+		var root cid.Cid
+		if hasEntries(entries) {
+			root = entries.(cidlink.Link).Cid
+		}
+		if err := l.trackEntries(ctx, w, id, root, retracted); err != nil {
+			l.h.publisherLogger.Errorw("failed to track entries", "err", err)
+			return err
+		}
+	}
+	if l.h.chainMaxAge > 0 {
+		if err := w.Put(ctx, publishedKey(ad), []byte(strconv.FormatInt(time.Now().UnixNano(), 10))); err != nil {
+			l.h.publisherLogger.Errorw("failed to record advertisement publish time", "err", err)
+			return err
+		}
+	}
+	if len(opts.labels) != 0 {
+		if err := l.putLabels(ctx, w, ad, opts.labels); err != nil {
+			l.h.publisherLogger.Errorw("failed to store advertisement labels", "err", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// headString formats the given head for messages, where no head is "none".
+func headString(head cid.Cid) string {
+	if !head.Defined() {
+		return "none"
+	}
+	return head.String()
+}
+
+func (l *dsPublisher) setHead(ctx context.Context, newHead cid.Cid) error {
+	return l.commitHead(ctx, newHead, nil)
+}
+
+// commitHead sets the head to newHead, committing the given batch along with
+// it, if any.
+func (l *dsPublisher) commitHead(ctx context.Context, newHead cid.Cid, batch *pendingBatch) error {
+	if l.h.syncBeforeHead {
+		if err := l.syncBlocks(ctx); err != nil {
+			l.h.publisherLogger.Errorw("failed to sync blocks before setting new head", "newHead", newHead, "err", err)
+			return err
+		}
+	}
+	for _, p := range l.h.blobPublishers {
+		if err := p.publish(ctx, newHead); err != nil {
+			l.h.publisherLogger.Errorw("failed to write chain blobs before setting new head", "newHead", newHead, "err", err)
+			return err
+		}
+	}
+	previous, err := l.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	var w datastore.Write = l.h.ds
+	if batch != nil {
+		w = batch
+	}
+	if err := l.putHead(ctx, w, newHead); err != nil {
+		l.h.publisherLogger.Errorw("failed to set new head", "newHead", newHead, "err", err)
+		return err
+	}
+	if batch != nil {
+		if err := batch.Commit(ctx); err != nil {
+			l.h.publisherLogger.Errorw("failed to commit new head", "newHead", newHead, "err", err)
+			return err
+		}
+	}
+	if l.h.syncBeforeHead {
+		if batch != nil {
+			// The advertisement was committed along with the head.
+			if err := l.h.ds.Sync(ctx, blocksPrefix); err != nil {
+				return err
+			}
+		}
+		if err := l.h.ds.Sync(ctx, headKey); err != nil {
+			return err
+		}
+	}
+	l.h.events.emit(HeadUpdated{Previous: previous, Head: newHead})
+	if l.h.headAnnouncer != nil {
+		l.h.headAnnouncer.enqueue(newHead)
+	}
+	return nil
+}
+
+// close stops accepting publishes, once those in flight complete. It returns
+// an error if they do not complete before the context is done, in which case
+// publishes are still stopped once they do.
+func (l *dsPublisher) close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.gcLocker.Lock()
+		defer l.gcLocker.Unlock()
+		l.closed = true
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("in-flight publishes did not complete: %w", ctx.Err())
+	}
+}
+
+// syncBlocks makes the blocks written so far durable, so that the head is
+// never exposed before the blocks it links to.
+func (l *dsPublisher) syncBlocks(ctx context.Context) error {
+	if l.h.entriesDs != nil {
+		if err := l.entriesDs.Sync(ctx, blocksPrefix); err != nil {
+			return err
+		}
+	}
+	return l.h.ds.Sync(ctx, blocksPrefix)
+}
+
 func (l *dsPublisher) GetContent(ctx context.Context, cid cid.Cid) (io.ReadCloser, error) {
-	key := dsKey(cidlink.Link{Cid: cid})
-	switch value, err := l.h.ds.Get(ctx, key); {
+	return l.GetContentByMultihash(ctx, cid.Hash())
+}
+
+func (l *dsPublisher) GetContentByMultihash(ctx context.Context, mh multihash.Multihash) (io.ReadCloser, error) {
+	key := blockKey(mh)
+	body, err := l.getContent(ctx, l.h.ds, key)
+	if errors.Is(err, datastore.ErrNotFound) && l.h.entriesDs != nil {
+		body, err = l.getContent(ctx, l.entriesDs, key)
+	}
+	switch {
 	case errors.Is(err, datastore.ErrNotFound):
 		return nil, ErrContentNotFound
 	case err != nil:
 		return nil, err
 	default:
-		buf := bytesBuffers.Get().(*bytes.Buffer)
-		buf.Reset()
-		buf.Grow(len(value))
-		_, _ = buf.Write(value)
-		return &pooledBytesBufferCloser{buf: buf}, err
+		return body, nil
+	}
+}
+
+func (l *dsPublisher) getContent(ctx context.Context, ds datastore.Datastore, key datastore.Key) (io.ReadCloser, error) {
+	if sds, ok := ds.(StreamingDatastore); ok {
+		size, err := sds.GetSize(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		r, err := sds.GetReader(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return &sizedReadCloser{ReadCloser: r, size: int64(size)}, nil
+	}
+	// The value is owned by the caller, and is therefore read in place
+	// rather than copied, so that it is held in memory only once.
+	value, err := ds.Get(ctx, key)
+	if err != nil {
+		return nil, err
 	}
+	return bytesReadCloser{Reader: bytes.NewReader(value)}, nil
 }
 
 func (l *dsPublisher) GetHead(ctx context.Context) (cid.Cid, error) {
@@ -191,15 +824,12 @@ func (l *dsPublisher) GetHead(ctx context.Context) (cid.Cid, error) {
 	default:
 		_, head, err := cid.CidFromBytes(value)
 		if err != nil {
-			logger.Errorw("failed to decode stored head as CID", "err", err)
+			l.h.publisherLogger.Errorw("failed to decode stored head as CID", "err", err)
 		}
 		return head, nil
 	}
 }
 
-func (c *pooledBytesBufferCloser) Read(b []byte) (n int, err error) { return c.buf.Read(b) }
+func (bytesReadCloser) Close() error { return nil }
 
-func (c *pooledBytesBufferCloser) Close() error {
-	bytesBuffers.Put(c.buf)
-	return nil
-}
+func (c *sizedReadCloser) Size() int64 { return c.size }
@@ -0,0 +1,65 @@
+package herald_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+)
+
+// failingBlobStore discards blobs, or fails to store them while failing.
+type failingBlobStore struct {
+	failing atomic.Bool
+}
+
+func (s *failingBlobStore) Has(context.Context, string) (bool, error) { return false, nil }
+
+func (s *failingBlobStore) Put(context.Context, string, []byte) error {
+	if s.failing.Load() {
+		return errors.New("blob store unavailable")
+	}
+	return nil
+}
+
+func TestFailedPublishKeepsRetraction(t *testing.T) {
+	ctx := context.Background()
+	store := &failingBlobStore{}
+	h, err := herald.New(heraldtest.Options(
+		herald.WithPublisherTransport(herald.BlobStoreTransport(store)),
+		herald.WithRetractedEntriesRetention(time.Nanosecond),
+		herald.WithMaintenance(time.Hour, herald.RetractedEntriesGC),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if _, err := h.Publish(ctx, heraldtest.Catalog("a", 3)); err != nil {
+		t.Fatal(err)
+	}
+	status, err := h.GetCatalogStatus(ctx, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Retract(ctx, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A publish whose advertisement fails to become the head leaves the
+	// retraction pending, so that the retracted entries are still deleted.
+	store.failing.Store(true)
+	if _, err := h.Publish(ctx, heraldtest.Catalog("a", 5)); err == nil {
+		t.Fatal("expected publish to fail")
+	}
+	store.failing.Store(false)
+	if err := h.RunMaintenance(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.GetContent(ctx, status.Entries); err == nil {
+		t.Fatalf("expected retracted entries %s to be deleted", status.Entries)
+	}
+}
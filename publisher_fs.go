@@ -0,0 +1,74 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var _ BlobStore = (*dirBlobStore)(nil)
+
+// dirBlobStore is a BlobStore that writes blobs as files under a directory,
+// e.g. to be served by nginx.
+type dirBlobStore struct {
+	dir string
+	// sync makes files durable before they are renamed into place.
+	sync bool
+}
+
+// FilesystemTransport writes the chain as flat files under the given
+// directory, to be served by a static file server such as nginx. See
+// BlobStoreTransport.
+func FilesystemTransport(dir string) PublisherTransport {
+	return func(o *options) error {
+		if dir == "" {
+			return errors.New("publisher directory must not be empty")
+		}
+		o.localPublisherDir = dir
+		return nil
+	}
+}
+
+func (s *dirBlobStore) Has(_ context.Context, key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, filepath.FromSlash(key)))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// Put writes the file for the given key atomically, such that it is never
+// served partially written.
+func (s *dirBlobStore) Put(_ context.Context, key string, data []byte) error {
+	name := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if s.sync {
+		if err := f.Sync(); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
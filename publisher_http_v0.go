@@ -6,11 +6,18 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/multiformats/go-multihash"
+	"golang.org/x/net/netutil"
 )
 
 var (
@@ -18,8 +25,10 @@ var (
 
 	ErrContentNotFound = errors.New("content is not found")
 
+	// contentBuffers are the buffers through which content is streamed to
+	// responses, when it is not loaded in memory already.
 	contentBuffers = sync.Pool{
-		New: func() any { return new([1024]byte) },
+		New: func() any { return new([32 << 10]byte) },
 	}
 )
 
@@ -28,50 +37,154 @@ type (
 		h           *Herald
 		server      http.Server
 		dsPublisher *dsPublisher
+		redirect    atomic.Pointer[url.URL]
+		rateLimiter *rateLimiter
+		// addr is the address the publisher listens on once started.
+		addr        atomic.Pointer[net.Addr]
+		signedHeads signedHeadCache
 	}
 )
 
 func newHttpPublisher(h *Herald, dspub *dsPublisher) (*httpPublisher, error) {
 	var pub httpPublisher
 	pub.h = h
-	pub.server.Handler = pub.serveMux()
+	pub.rateLimiter = newRateLimiter(h.options)
+	pub.server.Handler = pub.redirecting(pub.serveMux())
+	if h.httpPublisherPathPrefix != "" {
+		// The libp2p well-known resource must be served at the root, where
+		// it maps protocols to paths that include the prefix.
+		mux := http.NewServeMux()
+		mux.Handle("/", http.StripPrefix(h.httpPublisherPathPrefix, pub.server.Handler))
+		mux.Handle(libp2pWellKnownPath, h.metrics.instrument("libp2pWellKnown", traced(h.tracer, "libp2pWellKnown", pub.handleGetLibp2pWellKnown)))
+		pub.server.Handler = mux
+	}
+	pub.server.Handler = pub.withClientAddr(pub.withMiddleware(pub.server.Handler))
+	pub.server.ReadTimeout = h.httpReadTimeout
+	pub.server.WriteTimeout = h.httpWriteTimeout
+	pub.server.IdleTimeout = h.httpIdleTimeout
+	pub.server.MaxHeaderBytes = h.httpMaxHeaderBytes
 	pub.dsPublisher = dspub
 	return &pub, nil
 }
 
+// PublisherHandler returns the handler of the HTTP publisher, which serves the
+// head and content of the chain to indexers, for the application to mount into
+// its own server, e.g. along with HttpHandlerTransport. It is the handler
+// served on the address set via WithHttpPublisherListenAddr, and therefore
+// expects requests under the prefix set via WithHttpPublisherPathPrefix, if
+// any, or at the root otherwise, e.g. once stripped via http.StripPrefix. The
+// settings of the HTTP server itself, e.g. WithHttpServerTimeouts, do not
+// apply to it.
+func (h *Herald) PublisherHandler() http.Handler {
+	return h.publisher.server.Handler
+}
+
 func (p *httpPublisher) Start(ctx context.Context) error {
-	listener, err := net.Listen("tcp", p.h.httpPublisherListenAddr)
-	if err != nil {
-		return err
+	listener := p.h.listener
+	switch {
+	case listener != nil:
+	case p.h.httpPublisherListenMultiaddr != nil:
+		ml, err := manet.Listen(p.h.httpPublisherListenMultiaddr)
+		if err != nil {
+			return err
+		}
+		listener = manet.NetListener(ml)
+	default:
+		var err error
+		if listener, err = net.Listen("tcp", p.h.httpPublisherListenAddr); err != nil {
+			return err
+		}
 	}
+	if p.h.httpMaxConns > 0 {
+		listener = netutil.LimitListener(listener, p.h.httpMaxConns)
+	}
+	addr := listener.Addr()
+	p.addr.Store(&addr)
 	go func() {
 		if err := p.server.Serve(listener); errors.Is(err, http.ErrServerClosed) {
-			logger.Info("HTTP publisher stopped successfully.")
+			p.h.httpLogger.Info("HTTP publisher stopped successfully.")
 		} else {
-			logger.Errorw("HTTP publisher stopped erroneously.", "err", err)
+			p.h.httpLogger.Errorw("HTTP publisher stopped erroneously.", "err", err)
 		}
 	}()
-	logger.Infow("HTTP publisher started successfully.", "address", listener.Addr())
+	p.h.httpLogger.Infow("HTTP publisher started successfully.", "address", listener.Addr(), "pathPrefix", p.h.httpPublisherPathPrefix)
 	return nil
 }
 
 func (p *httpPublisher) serveMux() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/head", p.handleGetHead)
-	mux.HandleFunc("/*", p.handleGetContent)
+	handle := func(pattern, endpoint string, h http.HandlerFunc) {
+		mux.Handle(pattern, p.h.metrics.instrument(endpoint, traced(p.h.tracer, endpoint, h)))
+	}
+	// Head and content fetches hit the datastore, and are therefore subject
+	// to rate limits. Their responses are also compressed, since entry chunks
+	// compress well.
+	throttled := func(pattern, endpoint string, h http.HandlerFunc) {
+		handle(pattern, endpoint, p.throttled(endpoint, p.compressed(h)))
+	}
+	throttled("/head", "legacyHead", p.handleGetLegacyHead)
+	throttled("/head/plain", "plainHead", p.handleGetPlainHead)
+	throttled(ipnisync.IpniPath+"/head", "head", p.handleGetHead)
+	throttled(ipnisync.IpniPath+"/", "ipniContent", p.handleGetIpniContent)
+	handle("/provider", "provider", p.handleGetProviderInfo)
+	handle(wellKnownPath, "wellKnown", p.handleGetWellKnown)
+	handle(libp2pWellKnownPath, "libp2pWellKnown", p.handleGetLibp2pWellKnown)
+	// Blocks are served at the root as well, as expected by dagsync HTTP
+	// clients that predate ipnisync.
+	throttled("/", "content", p.handleGetContent)
 	return mux
 }
 
+// redirecting wraps the given handler to redirect requests to the instance
+// that took over the chain once a handover is complete.
+func (p *httpPublisher) redirecting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirect := p.redirect.Load()
+		if redirect == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target := *redirect
+		target.Path = strings.TrimSuffix(redirect.Path, "/") + r.URL.Path
+		target.RawPath = ""
+		target.RawQuery = r.URL.RawQuery
+		http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+	})
+}
+
+// handleGetHead serves the head signed along with the topic, as expected by
+// ipnisync clients, or as plain text if preferred by the client.
 func (p *httpPublisher) handleGetHead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	p.serveHead(w, r, p.h.topic, prefersPlainText(r.Header.Values("Accept")))
+}
+
+// handleGetLegacyHead serves the head signed without the topic, as expected by
+// dagsync HTTP clients that predate ipnisync, which reject unknown fields, or
+// as plain text if preferred by the client.
+func (p *httpPublisher) handleGetLegacyHead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	p.serveHead(w, r, "", prefersPlainText(r.Header.Values("Accept")))
+}
+
+// handleGetPlainHead serves the head CID as plain text, for tooling that does
+// not verify signed heads.
+func (p *httpPublisher) handleGetPlainHead(w http.ResponseWriter, r *http.Request) {
+	p.serveHead(w, r, "", true)
+}
+
+// serveHead serves the head, either as a signed head message with the given
+// topic or, if plain, as the bare CID string.
+func (p *httpPublisher) serveHead(w http.ResponseWriter, r *http.Request, topic string, plain bool) {
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	h, err := p.GetHead(r.Context())
 	if err != nil {
-		logger.Errorw("failed to get head CID", "err", err)
+		p.h.httpLogger.Errorw("failed to get head CID", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
@@ -79,66 +192,178 @@ func (p *httpPublisher) handleGetHead(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "", http.StatusNoContent)
 		return
 	}
-	signedHead, err := head.NewSignedHead(h, p.h.topic, p.h.identity)
-	if err != nil {
-		logger.Errorw("failed to generate signed head message", "err", err)
-		http.Error(w, "", http.StatusInternalServerError)
+	// The head is short-lived, but may still be cached briefly to absorb
+	// polling by many indexers, e.g. via a CDN.
+	tag := etag(h.String())
+	if plain {
+		// Both representations are cached under the same URL when
+		// negotiated.
+		tag = etag(h.String() + "/plain")
+	}
+	w.Header().Set("ETag", tag)
+	if maxAge := p.h.headCacheMaxAge; maxAge > 0 {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(int64(maxAge/time.Second), 10))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if notModified(r, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if plain {
+		resp := h.String()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		if written, err := io.WriteString(w, resp); err != nil {
+			p.h.httpLogger.Errorw("failed to write plain head response", "written", written, "client", clientAddr(r), "err", err)
+		}
 		return
 	}
-	resp, err := signedHead.Encode()
+	resp, cached, err := p.signedHeads.get(h, topic, p.h.identity)
 	if err != nil {
-		logger.Errorw("failed to encode signed head message", "err", err)
+		p.h.httpLogger.Errorw("failed to generate signed head message", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
+	p.h.metrics.observeSignedHeadCache(cached)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	if r.Method == http.MethodHead {
+		return
+	}
 	if written, err := w.Write(resp); err != nil {
-		logger.Errorw("failed to write encoded head response", "written", written, "err", err)
+		p.h.httpLogger.Errorw("failed to write encoded head response", "written", written, "client", clientAddr(r), "err", err)
 	} else {
-		logger.Debugw("successfully responded with head message", "head", h, "written", written)
+		p.h.httpLogger.Debugw("successfully responded with head message", "head", h, "topic", topic, "written", written, "client", clientAddr(r))
+	}
+}
+
+// prefersPlainText reports whether the given Accept header values accept
+// text/plain without accepting application/json, the media type of signed
+// heads, explicitly.
+func prefersPlainText(values []string) bool {
+	var plainOk, jsonOk bool
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			mediaType, params, _ := strings.Cut(element, ";")
+			if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+				if v, err := strconv.ParseFloat(q, 64); err != nil || v <= 0 {
+					continue
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(mediaType)) {
+			case "text/plain":
+				plainOk = true
+			case "application/json":
+				jsonOk = true
+			}
+		}
 	}
+	return plainOk && !jsonOk
 }
 
+// handleGetContent serves content at the legacy path "/{cid}".
 func (p *httpPublisher) handleGetContent(w http.ResponseWriter, r *http.Request) {
+	p.serveContent(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+}
+
+// handleGetIpniContent serves content at the ipnisync path "/ipni/v1/ad/{cid}".
+func (p *httpPublisher) handleGetIpniContent(w http.ResponseWriter, r *http.Request) {
+	p.serveContent(w, r, strings.TrimPrefix(r.URL.Path, ipnisync.IpniPath+"/"))
+}
+
+func (p *httpPublisher) serveContent(w http.ResponseWriter, r *http.Request, pathParam string) {
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	pathParam := strings.TrimPrefix("/", r.URL.RawPath)
-	id, err := cid.Decode(pathParam)
-	if err != nil {
-		logger.Debugw("invalid CID as path parameter while getting content", "pathParam", pathParam, "err", err)
-		http.Error(w, "invalid CID: "+pathParam, http.StatusBadRequest)
+	if pathParam == "" || strings.Contains(pathParam, "/") {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	// Content may be requested by CID or by bare multihash.
+	var id cid.Cid
+	var mh multihash.Multihash
+	if c, err := cid.Decode(pathParam); err == nil {
+		id, mh = c, c.Hash()
+	} else if mh, err = multihash.FromB58String(pathParam); err != nil {
+		p.h.httpLogger.Debugw("invalid CID or multihash as path parameter while getting content", "pathParam", pathParam, "client", clientAddr(r), "err", err)
+		http.Error(w, "invalid CID or multihash: "+pathParam, http.StatusBadRequest)
 		return
 	}
-	switch body, err := p.GetContent(r.Context(), id); {
+	switch body, err := p.dsPublisher.GetContentByMultihash(r.Context(), mh); {
 	case errors.Is(err, ErrContentNotFound):
 		http.Error(w, "", http.StatusNotFound)
 		return
 	case err != nil:
-		logger.Errorw("failed to get content from store", "id", id, "err", err)
+		p.h.httpLogger.Errorw("failed to get content from store", "mh", mh, "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	default:
 		defer func() {
 			if err := body.Close(); err != nil {
-				logger.Debugw("failed to close reader for content", "id", id, "err", err)
+				p.h.httpLogger.Debugw("failed to close reader for content", "mh", mh, "err", err)
 			}
 		}()
-		switch id.Prefix().Codec {
-		case cid.DagJSON:
-			w.Header().Set("Content-Type", "application/json")
-		case cid.DagCBOR:
-			w.Header().Set("Content-Type", "application/cbor")
+		if id.Defined() {
+			switch id.Prefix().Codec {
+			case cid.DagJSON:
+				w.Header().Set("Content-Type", "application/json")
+			case cid.DagCBOR:
+				w.Header().Set("Content-Type", "application/cbor")
+			}
 		}
-		buf := contentBuffers.Get().(*[1024]byte)
+		// Content is immutable and identified by its multihash, whether it
+		// is requested by CID or by multihash.
+		tag := etag(mh.B58String())
+		w.Header().Set("ETag", tag)
+		if maxAge := p.h.contentCacheMaxAge; maxAge > 0 {
+			w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatInt(int64(maxAge/time.Second), 10)+", immutable")
+		}
+		if notModified(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		var src io.Reader = body
+		if s, ok := body.(sizer); ok {
+			// Ranges are supported when the size is known, so that
+			// interrupted downloads of large blocks can be resumed.
+			size := s.Size()
+			w.Header().Set("Accept-Ranges", "bytes")
+			switch start, length, ok, err := requestedRange(r, size, tag); {
+			case errors.Is(err, errRangeNotSatisfiable):
+				w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+				http.Error(w, "", http.StatusRequestedRangeNotSatisfiable)
+				return
+			case ok:
+				if err := skip(body, start); err != nil {
+					p.h.httpLogger.Errorw("failed to skip to start of requested range", "mh", mh, "start", start, "err", err)
+					http.Error(w, "", http.StatusInternalServerError)
+					return
+				}
+				src = io.LimitReader(body, length)
+				w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(start+length-1, 10)+"/"+strconv.FormatInt(size, 10))
+				w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+				w.WriteHeader(http.StatusPartialContent)
+			default:
+				w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+			}
+		}
+		if r.Method == http.MethodHead {
+			// Load balancers and CDNs probe content without reading it.
+			return
+		}
+		buf := contentBuffers.Get().(*[32 << 10]byte)
 		defer contentBuffers.Put(buf)
-		if written, err := io.CopyBuffer(w, body, buf[:]); err != nil {
-			logger.Errorw("failed to write content response", "written", written, "err", err)
+		if written, err := io.CopyBuffer(w, src, buf[:]); err != nil {
+			p.h.httpLogger.Errorw("failed to write content response", "written", written, "client", clientAddr(r), "err", err)
 		} else {
-			logger.Debugw("successfully responded with content", "id", id, "written", written)
+			p.h.httpLogger.Debugw("successfully responded with content", "mh", mh, "written", written, "client", clientAddr(r))
 		}
 	}
 }
@@ -147,6 +372,14 @@ func (p *httpPublisher) Publish(ctx context.Context, catalog Catalog) (cid.Cid,
 	return p.dsPublisher.Publish(ctx, catalog)
 }
 
+func (p *httpPublisher) PublishAppend(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	return p.dsPublisher.PublishAppend(ctx, catalog)
+}
+
+func (p *httpPublisher) PublishWithOptions(ctx context.Context, catalog Catalog, opts *publishOptions) (*PublishReceipt, error) {
+	return p.dsPublisher.PublishWithOptions(ctx, catalog, opts)
+}
+
 func (p *httpPublisher) Retract(ctx context.Context, id CatalogID) (cid.Cid, error) {
 	return p.dsPublisher.Retract(ctx, id)
 }
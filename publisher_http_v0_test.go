@@ -0,0 +1,50 @@
+package herald_test
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestHttpPublisherPathPrefixAddrs(t *testing.T) {
+	indexer, err := url.Parse("http://127.0.0.1:3001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := herald.New(heraldtest.Options(
+		herald.WithHttpPublisherPathPrefix("/ipni"),
+		herald.WithHttpAnnouncer(indexer),
+	)...); err == nil {
+		t.Fatal("expected announcing a prefixed chain without publisher addresses to fail")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := herald.New(heraldtest.Options(
+		herald.WithHttpPublisherPathPrefix("/ipni"),
+		herald.WithListener(l),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Addrs()) == 0 {
+		t.Fatal("expected the listen addresses of the started publisher")
+	}
+	// The listen addresses do not reach the prefixed chain.
+	for _, a := range h.AddrInfo().Addrs {
+		if _, err := a.ValueForProtocol(multiaddr.P_HTTP); err == nil {
+			t.Fatalf("expected no HTTP address without the path prefix, got %s", a)
+		}
+	}
+}
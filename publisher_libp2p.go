@@ -0,0 +1,118 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/zap"
+)
+
+// Libp2pProtocolID is the protocol over which the advertisement chain is
+// served to libp2p peers, as HTTP requests over libp2p streams.
+const Libp2pProtocolID = protocol.ID("/ipni-sync/1")
+
+var (
+	_ net.Listener = (*streamListener)(nil)
+	_ net.Conn     = (*streamConn)(nil)
+	_ net.Addr     = peerAddr("")
+)
+
+type (
+	// libp2pPublisher serves the same endpoints as httpPublisher over streams
+	// of a libp2p host.
+	libp2pPublisher struct {
+		host   host.Host
+		server http.Server
+		logger *zap.SugaredLogger
+	}
+	// streamListener accepts the libp2p streams opened for Libp2pProtocolID
+	// as connections.
+	streamListener struct {
+		host      host.Host
+		streams   chan network.Stream
+		closed    chan struct{}
+		closeOnce sync.Once
+	}
+	streamConn struct {
+		network.Stream
+	}
+	peerAddr peer.ID
+)
+
+func newLibp2pPublisher(h host.Host, handler http.Handler, logger *zap.SugaredLogger) *libp2pPublisher {
+	var pub libp2pPublisher
+	pub.host = h
+	pub.server.Handler = handler
+	pub.logger = logger
+	return &pub
+}
+
+func (p *libp2pPublisher) Start(context.Context) error {
+	listener := &streamListener{
+		host:    p.host,
+		streams: make(chan network.Stream),
+		closed:  make(chan struct{}),
+	}
+	p.host.SetStreamHandler(Libp2pProtocolID, listener.handleStream)
+	go func() {
+		if err := p.server.Serve(listener); errors.Is(err, http.ErrServerClosed) {
+			p.logger.Info("libp2p publisher stopped successfully.")
+		} else {
+			p.logger.Errorw("libp2p publisher stopped erroneously.", "err", err)
+		}
+	}()
+	p.logger.Infow("libp2p publisher started successfully.", "peerID", p.host.ID(), "addrs", p.host.Addrs(), "protocol", Libp2pProtocolID)
+	return nil
+}
+
+func (p *libp2pPublisher) Shutdown(ctx context.Context) error {
+	return p.server.Shutdown(ctx)
+}
+
+func (l *streamListener) handleStream(s network.Stream) {
+	select {
+	case l.streams <- s:
+	case <-l.closed:
+		_ = s.Reset()
+	}
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	select {
+	case s := <-l.streams:
+		return &streamConn{Stream: s}, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *streamListener) Close() error {
+	l.closeOnce.Do(func() {
+		l.host.RemoveStreamHandler(Libp2pProtocolID)
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *streamListener) Addr() net.Addr {
+	return peerAddr(l.host.ID())
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return peerAddr(c.Conn().LocalPeer())
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return peerAddr(c.Conn().RemotePeer())
+}
+
+func (a peerAddr) Network() string { return "libp2p" }
+
+func (a peerAddr) String() string { return peer.ID(a).String() }
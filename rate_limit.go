@@ -0,0 +1,105 @@
+package herald
+
+import (
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// clientLimiterIdleTimeout is the time after which the limiter of a client
+// that made no requests is forgotten.
+const clientLimiterIdleTimeout = 10 * time.Minute
+
+type (
+	// rateLimiter throttles requests to the head and content endpoints, in
+	// total and per client address, so that a misbehaving syncer cannot
+	// saturate the datastore. See WithRateLimit and WithClientRateLimit.
+	rateLimiter struct {
+		global *rate.Limiter
+
+		clientLimit rate.Limit
+		clientBurst int
+		mu          sync.Mutex
+		clients     map[netip.Addr]*clientLimiter
+		lastSweep   time.Time
+	}
+	clientLimiter struct {
+		*rate.Limiter
+		lastSeen time.Time
+	}
+)
+
+func newRateLimiter(o *options) *rateLimiter {
+	if o.rateLimit <= 0 && o.clientRateLimit <= 0 {
+		return nil
+	}
+	var l rateLimiter
+	if o.rateLimit > 0 {
+		l.global = rate.NewLimiter(rate.Limit(o.rateLimit), o.rateBurst)
+	}
+	if o.clientRateLimit > 0 {
+		l.clientLimit = rate.Limit(o.clientRateLimit)
+		l.clientBurst = o.clientRateBurst
+		l.clients = make(map[netip.Addr]*clientLimiter)
+	}
+	return &l
+}
+
+// allow reports whether a request from the given client may be served, and
+// otherwise the scope of the limit that was exceeded: "global" or "client".
+func (l *rateLimiter) allow(addr netip.Addr) (bool, string) {
+	if l == nil {
+		return true, ""
+	}
+	// The client limit is checked first, so that a throttled client does not
+	// consume the tokens shared by all clients.
+	if l.clients != nil && addr.IsValid() && !l.client(addr).Allow() {
+		return false, "client"
+	}
+	if l.global != nil && !l.global.Allow() {
+		return false, "global"
+	}
+	return true, ""
+}
+
+func (l *rateLimiter) client(addr netip.Addr) *rate.Limiter {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > clientLimiterIdleTimeout {
+		for a, c := range l.clients {
+			if now.Sub(c.lastSeen) > clientLimiterIdleTimeout {
+				delete(l.clients, a)
+			}
+		}
+		l.lastSweep = now
+	}
+	c, ok := l.clients[addr]
+	if !ok {
+		c = &clientLimiter{Limiter: rate.NewLimiter(l.clientLimit, l.clientBurst)}
+		l.clients[addr] = c
+	}
+	c.lastSeen = now
+	return c.Limiter
+}
+
+// throttled wraps the handler of the given endpoint to respond with 429 Too
+// Many Requests to requests that exceed the configured rate limits.
+func (p *httpPublisher) throttled(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	if p.rateLimiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, scope := p.rateLimiter.allow(clientAddr(r)); !ok {
+			p.h.metrics.observeThrottled(endpoint, scope)
+			p.h.httpLogger.Debugw("throttling request", "endpoint", endpoint, "scope", scope, "client", clientAddr(r))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
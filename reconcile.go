@@ -0,0 +1,116 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+)
+
+type (
+	// CatalogSource lists the catalogs currently available upstream of
+	// Herald, for sources from which catalogs are pulled rather than pushed.
+	CatalogSource interface {
+		// CatalogIDs returns the IDs of every catalog currently available.
+		CatalogIDs(context.Context) ([]CatalogID, error)
+		// Catalog returns the catalog with the given ID.
+		Catalog(context.Context, CatalogID) (Catalog, error)
+	}
+
+	ReconcileOption  func(*reconcileOptions)
+	reconcileOptions struct {
+		publishMissing bool
+		dryRun         bool
+	}
+
+	// ReconcileReport describes the changes made, or that would be made in a
+	// dry run, by reconciling with a CatalogSource.
+	ReconcileReport struct {
+		// Retracted are the IDs of advertised catalogs that are no longer
+		// available from the source.
+		Retracted []CatalogID
+		// Published are the IDs of catalogs available from the source that
+		// were not advertised.
+		Published []CatalogID
+		// Head is the head after reconciliation.
+		Head cid.Cid
+	}
+)
+
+// WithReconcilePublishMissing also publishes the catalogs available from the
+// source that are not advertised. Disabled by default, in which case only
+// retractions are made.
+func WithReconcilePublishMissing(v bool) ReconcileOption {
+	return func(o *reconcileOptions) {
+		o.publishMissing = v
+	}
+}
+
+// WithReconcileDryRun reports the changes that reconciliation would make
+// without making them.
+func WithReconcileDryRun(v bool) ReconcileOption {
+	return func(o *reconcileOptions) {
+		o.dryRun = v
+	}
+}
+
+// Reconcile compares the catalogs available from the given source against
+// those currently advertised, and retracts the advertised catalogs that are
+// no longer available, so that the advertised state does not drift from the
+// source when removals upstream are missed. Catalogs published for providers
+// registered via WithProvider or AddProvider are left advertised.
+func (h *Herald) Reconcile(ctx context.Context, src CatalogSource, o ...ReconcileOption) (*ReconcileReport, error) {
+	var opts reconcileOptions
+	for _, apply := range o {
+		apply(&opts)
+	}
+	upstream, err := src.CatalogIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	live, err := h.publisher.dsPublisher.liveCatalogs(ctx, h.providerID)
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]struct{}, len(upstream))
+	for _, id := range upstream {
+		available[string(id)] = struct{}{}
+	}
+	advertised := make(map[string]struct{}, len(live))
+	var report ReconcileReport
+	for _, status := range live {
+		advertised[string(status.ID)] = struct{}{}
+		if _, ok := available[string(status.ID)]; !ok {
+			report.Retracted = append(report.Retracted, status.ID)
+		}
+	}
+	if opts.publishMissing {
+		for _, id := range upstream {
+			if _, ok := advertised[string(id)]; !ok {
+				report.Published = append(report.Published, id)
+			}
+		}
+	}
+	if !opts.dryRun {
+		for _, id := range report.Retracted {
+			if _, err := h.Retract(ctx, id); err != nil {
+				h.logger.Errorw("failed to retract catalog no longer available from source", "id", id, "err", err)
+				return nil, err
+			}
+		}
+		for _, id := range report.Published {
+			catalog, err := src.Catalog(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := h.Publish(ctx, catalog); err != nil {
+				h.logger.Errorw("failed to publish catalog available from source", "id", id, "err", err)
+				return nil, err
+			}
+		}
+	}
+	if report.Head, err = h.GetHead(ctx); err != nil {
+		return nil, err
+	}
+	h.logger.Infow("Reconciled with catalog source", "retracted", len(report.Retracted), "published", len(report.Published), "dryRun", opts.dryRun, "head", report.Head)
+	return &report, nil
+}
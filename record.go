@@ -0,0 +1,396 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	recordOpPublish = "publish"
+	recordOpAppend  = "append"
+	recordOpRetract = "retract"
+	recordOpRemove  = "remove"
+
+	// recordPartSize is the size in bytes above which the multihashes of a
+	// record are written out as a part.
+	recordPartSize = 1 << 20
+)
+
+var (
+	_ EntryChunker    = (*recordingEntryChunker)(nil)
+	_ Catalog         = (*recordCatalog)(nil)
+	_ CatalogIterator = (*recordCatalogIterator)(nil)
+
+	recordSeqKey      = datastore.NewKey("seq")
+	recordsPrefix     = datastore.NewKey("records")
+	recordPartsPrefix = datastore.NewKey("multihashes")
+
+	// ErrReplayDiverged signals that replaying a record produced a different
+	// advertisement than the one originally published, e.g. because Herald
+	// is configured with a different identity.
+	ErrReplayDiverged = errors.New("replayed advertisement differs from record")
+)
+
+type (
+	// publishRecord captures the inputs of a publish or retraction. The
+	// multihashes of a publish are stored separately in parts keyed by the
+	// record ID, as they are chunked, so that they are never held in memory.
+	publishRecord struct {
+		ID        string   `json:"id"`
+		Op        string   `json:"op"`
+		ContextID []byte   `json:"contextID"`
+		Metadata  []byte   `json:"metadata,omitempty"`
+		Addresses []string `json:"addresses,omitempty"`
+		Parts     int      `json:"parts,omitempty"`
+		Ad        string   `json:"ad"`
+		// Original is the advertisement as published, when Ad has since
+		// been rewritten by pruning the chain. Replay reproduces it.
+		Original string `json:"original,omitempty"`
+		// Provider is the provider of the advertisement, when other than
+		// the default provider.
+		Provider string `json:"provider,omitempty"`
+		seq      uint64
+	}
+	// publishRecorder stores records in the order their advertisements were
+	// added to the chain. Records are committed while holding the publisher
+	// lock, which also guards next.
+	publishRecorder struct {
+		ds datastore.Datastore
+		// partsPrefix is the namespace under which the multihashes of
+		// records are stored.
+		partsPrefix datastore.Key
+		next        uint64
+	}
+	recordingEntryChunker struct {
+		EntryChunker
+		ctx context.Context
+		r   *publishRecorder
+		rec *publishRecord
+		buf []byte
+	}
+	recordCatalog struct {
+		ctx context.Context
+		r   *publishRecorder
+		rec *publishRecord
+	}
+	recordCatalogIterator struct {
+		*recordCatalog
+		part   int
+		reader multihash.Reader
+		next   multihash.Multihash
+		err    error
+	}
+)
+
+func newPublishRecorder(ctx context.Context, ds datastore.Datastore) (*publishRecorder, error) {
+	r := &publishRecorder{ds: ds, partsPrefix: recordPartsPrefix}
+	switch value, err := ds.Get(ctx, recordSeqKey); {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		if r.next, err = strconv.ParseUint(string(value), 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid publish record sequence %q: %w", value, err)
+		}
+	}
+	return r, nil
+}
+
+func recordKey(seq uint64) datastore.Key {
+	return recordsPrefix.ChildString(fmt.Sprintf("%020d", seq))
+}
+
+func (r *publishRecorder) partKey(id string, part int) datastore.Key {
+	return r.partsPrefix.ChildString(id).ChildString(fmt.Sprintf("%010d", part))
+}
+
+func (r *publishRecorder) newRecord(op string, id CatalogID) *publishRecord {
+	var rid [16]byte
+	_, _ = rand.Read(rid[:])
+	return &publishRecord{
+		ID:        hex.EncodeToString(rid[:]),
+		Op:        op,
+		ContextID: id,
+	}
+}
+
+// recordingChunker wraps the given chunker to write added multihashes to the
+// record. Parts written by publishes that fail are left behind unreferenced.
+func (r *publishRecorder) recordingChunker(ctx context.Context, rec *publishRecord, c EntryChunker) EntryChunker {
+	return &recordingEntryChunker{EntryChunker: c, ctx: ctx, r: r, rec: rec}
+}
+
+func (r *publishRecorder) setNext(ctx context.Context, next uint64) error {
+	if err := r.ds.Put(ctx, recordSeqKey, []byte(strconv.FormatUint(next, 10))); err != nil {
+		return err
+	}
+	r.next = next
+	return nil
+}
+
+// commit appends the record to the sequence of records.
+func (r *publishRecorder) commit(ctx context.Context, rec *publishRecord) error {
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	rec.seq = r.next
+	if err := r.ds.Put(ctx, recordKey(rec.seq), value); err != nil {
+		return err
+	}
+	return r.setNext(ctx, rec.seq+1)
+}
+
+func (r *publishRecorder) remove(ctx context.Context, rec *publishRecord) error {
+	if err := r.ds.Delete(ctx, recordKey(rec.seq)); err != nil {
+		return err
+	}
+	for part := 0; part < rec.Parts; part++ {
+		if err := r.ds.Delete(ctx, r.partKey(rec.ID, part)); err != nil {
+			return err
+		}
+	}
+	return r.setNext(ctx, rec.seq)
+}
+
+// truncate removes the records of advertisements published after the given
+// one. Nothing is removed if none of the records is of the given
+// advertisement, e.g. because it was imported.
+func (r *publishRecorder) truncate(ctx context.Context, ad cid.Cid) error {
+	var discarded []*publishRecord
+	for seq := r.next; ; seq-- {
+		if seq == 0 {
+			return fmt.Errorf("no publish record of advertisement %s", ad)
+		}
+		rec, err := r.load(ctx, seq-1)
+		if err != nil {
+			return err
+		}
+		if rec.Ad == ad.String() {
+			break
+		}
+		discarded = append(discarded, rec)
+	}
+	for _, rec := range discarded {
+		if err := r.remove(ctx, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remap updates the advertisements of the records once the chain is
+// rewritten, keeping the ones they were published with.
+func (r *publishRecorder) remap(ctx context.Context, rewritten map[cid.Cid]cid.Cid) error {
+	// The rewritten advertisements are the most recent, so the records are
+	// remapped from the newest until all are found.
+	remaining := len(rewritten)
+	for seq := r.next; seq > 0 && remaining > 0; seq-- {
+		rec, err := r.load(ctx, seq-1)
+		if err != nil {
+			return err
+		}
+		ad, err := cid.Decode(rec.Ad)
+		if err != nil {
+			return fmt.Errorf("invalid advertisement of publish record %d: %w", rec.seq, err)
+		}
+		c, ok := rewritten[ad]
+		if !ok {
+			continue
+		}
+		remaining--
+		if rec.Original == "" {
+			rec.Original = rec.Ad
+		}
+		rec.Ad = c.String()
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err := r.ds.Put(ctx, recordKey(rec.seq), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *publishRecorder) load(ctx context.Context, seq uint64) (*publishRecord, error) {
+	value, err := r.ds.Get(ctx, recordKey(seq))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get publish record %d: %w", seq, err)
+	}
+	var rec publishRecord
+	if err := json.Unmarshal(value, &rec); err != nil {
+		return nil, fmt.Errorf("failed to decode publish record %d: %w", seq, err)
+	}
+	rec.seq = seq
+	return &rec, nil
+}
+
+// Replay rebuilds the advertisement chain from the records kept by a Herald
+// instance configured with WithPublishRecorder. Every record is published
+// anew with its original metadata, addresses and multihashes, bypassing
+// filters, and the resulting advertisement is checked against the record;
+// replay fails with ErrReplayDiverged on the first mismatch. Herald must be
+// configured with the identity that published the records.
+//
+// Replay fails with ErrHeadExists if Herald has already published an
+// advertisement. The records must not be stored in the datastore set by
+// WithPublishRecorder on this instance. Chains imported via
+// ImportIndexProvider or TakeOver are not recorded, and cannot be replayed.
+// Records of a chain since pruned by PruneChain reproduce the unpruned chain,
+// i.e. the advertisements as originally published, and its head.
+func (h *Herald) Replay(ctx context.Context, records datastore.Datastore) (cid.Cid, error) {
+	p := h.publisher.dsPublisher
+	if p.recorder != nil && p.recorder.ds == records {
+		return cid.Undef, errors.New("cannot replay records into the datastore they are read from")
+	}
+	if head, err := p.GetHead(ctx); err != nil {
+		return cid.Undef, err
+	} else if head.Defined() {
+		return cid.Undef, ErrHeadExists
+	}
+	src, err := newPublishRecorder(ctx, records)
+	if err != nil {
+		return cid.Undef, err
+	}
+	var head cid.Cid
+	for seq := uint64(0); seq < src.next; seq++ {
+		rec, err := src.load(ctx, seq)
+		if err != nil {
+			return cid.Undef, err
+		}
+		// Records may include publishes that did not change their catalog,
+		// made before those were skipped.
+		opts := &publishOptions{
+			metadata:         rec.Metadata,
+			providerAddrs:    rec.Addresses,
+			skipFilters:      true,
+			publishUnchanged: true,
+		}
+		if rec.Provider != "" {
+			if opts.provider, err = peer.Decode(rec.Provider); err != nil {
+				return cid.Undef, fmt.Errorf("invalid provider of publish record %d: %w", seq, err)
+			}
+		}
+		switch rec.Op {
+		case recordOpRetract:
+			head, err = p.retract(ctx, rec.ContextID, opts)
+		case recordOpRemove:
+			head, err = p.remove(ctx, &recordCatalog{ctx: ctx, r: src, rec: rec}, opts)
+		case recordOpPublish, recordOpAppend:
+			opts.appendEntries = rec.Op == recordOpAppend
+			var receipt *PublishReceipt
+			if receipt, err = p.publish(ctx, &recordCatalog{ctx: ctx, r: src, rec: rec}, opts); err == nil {
+				head = receipt.Advertisement
+			}
+		default:
+			err = fmt.Errorf("unknown publish record operation: %q", rec.Op)
+		}
+		if err != nil {
+			return cid.Undef, fmt.Errorf("failed to replay publish record %d: %w", seq, err)
+		}
+		published := rec.Ad
+		if rec.Original != "" {
+			published = rec.Original
+		}
+		if head.String() != published {
+			return cid.Undef, fmt.Errorf("%w: record %d produced %s instead of %s", ErrReplayDiverged, seq, head, published)
+		}
+	}
+	h.logger.Infow("Replayed publish records", "count", src.next, "head", head)
+	return head, nil
+}
+
+func (c *recordingEntryChunker) Add(mh multihash.Multihash) error {
+	c.buf = append(c.buf, mh...)
+	if len(c.buf) >= recordPartSize {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	return c.EntryChunker.Add(mh)
+}
+
+func (c *recordingEntryChunker) Finish() (ipld.Link, error) {
+	if len(c.buf) != 0 {
+		if err := c.flush(); err != nil {
+			return nil, err
+		}
+	}
+	return c.EntryChunker.Finish()
+}
+
+func (c *recordingEntryChunker) flush() error {
+	if err := c.r.ds.Put(c.ctx, c.r.partKey(c.rec.ID, c.rec.Parts), bytes.Clone(c.buf)); err != nil {
+		return err
+	}
+	c.rec.Parts++
+	c.buf = c.buf[:0]
+	return nil
+}
+
+func (c *recordCatalog) ID() []byte { return c.rec.ContextID }
+
+func (c *recordCatalog) Iterator() CatalogIterator {
+	iter := &recordCatalogIterator{recordCatalog: c}
+	iter.advance()
+	return iter
+}
+
+func (c *recordCatalog) Transport() interface{ Providers() any } { return nil }
+
+func (i *recordCatalogIterator) advance() {
+	i.next = nil
+	for i.err == nil {
+		if i.reader == nil {
+			if i.part >= i.rec.Parts {
+				return
+			}
+			value, err := i.r.ds.Get(i.ctx, i.r.partKey(i.rec.ID, i.part))
+			if err != nil {
+				i.err = fmt.Errorf("failed to get part %d of publish record %d: %w", i.part, i.rec.seq, err)
+				return
+			}
+			i.reader = multihash.NewReader(bytes.NewReader(value))
+			i.part++
+		}
+		mh, err := i.reader.ReadMultihash()
+		switch {
+		case errors.Is(err, io.EOF):
+			i.reader = nil
+		case err != nil:
+			i.err = err
+		default:
+			i.next = mh
+			return
+		}
+	}
+}
+
+func (i *recordCatalogIterator) Next() (multihash.Multihash, error) {
+	switch {
+	case i.err != nil:
+		return nil, i.err
+	case i.next == nil:
+		return nil, ErrCatalogIteratorDone
+	}
+	next := i.next
+	i.advance()
+	return next, nil
+}
+
+func (i *recordCatalogIterator) Done() bool { return i.next == nil && i.err == nil }
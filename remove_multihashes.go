@@ -0,0 +1,79 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/multiformats/go-multihash"
+)
+
+// RemoveMultihashes publishes a removal advertisement whose entries are the
+// given multihashes, such that indexers stop providing them for the catalog
+// with the given ID while its other multihashes stay advertised. Unlike
+// Retract, the catalog is not retracted as a whole. Duplicate multihashes are
+// removed once, and the multihashes are not retained. ErrCatalogNotFound is
+// returned if the catalog is not currently advertised.
+//
+// The multihashes tracked for catalogs published by diff are updated
+// accordingly. Concurrent removals and diffs of the same catalog must be
+// serialized by the caller.
+func (h *Herald) RemoveMultihashes(ctx context.Context, id CatalogID, mhs []multihash.Multihash) (cid.Cid, error) {
+	if err := h.limiter.acquire(ctx); err != nil {
+		return cid.Undef, err
+	}
+	defer h.limiter.release()
+	return h.publisher.dsPublisher.removeMultihashes(ctx, id, mhs)
+}
+
+func (l *dsPublisher) removeMultihashes(ctx context.Context, id CatalogID, mhs []multihash.Multihash) (cid.Cid, error) {
+	if err := l.h.validateContextID(id); err != nil {
+		return cid.Undef, err
+	}
+	removed := make([]multihash.Multihash, 0, len(mhs))
+	seen := make(map[string]struct{}, len(mhs))
+	for _, mh := range mhs {
+		if _, err := multihash.Decode(mh); err != nil {
+			return cid.Undef, fmt.Errorf("invalid multihash %x: %w", []byte(mh), err)
+		}
+		if _, ok := seen[string(mh)]; ok {
+			continue
+		}
+		seen[string(mh)] = struct{}{}
+		removed = append(removed, mh)
+	}
+	if len(removed) == 0 {
+		return cid.Undef, errors.New("no multihashes to remove")
+	}
+	status, err := l.getCatalogStatus(ctx, id)
+	switch {
+	case err != nil:
+		return cid.Undef, err
+	case status.Retracted:
+		return cid.Undef, ErrCatalogNotFound
+	}
+	var tracked bool
+	switch ad, err := l.h.ds.Get(ctx, diffAdKey(id)); {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return cid.Undef, err
+	default:
+		tracked = bytes.Equal(ad, status.Advertisement.Bytes())
+	}
+	ad, err := l.remove(ctx, &sliceCatalog{id: id, mhs: removed}, &publishOptions{})
+	if err != nil {
+		return cid.Undef, err
+	}
+	if tracked {
+		if err := l.updateDiff(ctx, id, ad, nil, removed); err != nil {
+			// The removal was published regardless; the advertised
+			// multihashes are taken from the chain by the next diff.
+			l.h.publisherLogger.Errorw("failed to track removed multihashes of catalog diff", "id", id, "err", err)
+		}
+	}
+	l.h.publisherLogger.Infow("Removed multihashes from catalog", "id", id, "ad", ad, "count", len(removed))
+	return ad, nil
+}
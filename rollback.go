@@ -0,0 +1,146 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+)
+
+// ErrNotAncestor signals that an advertisement is not part of the chain
+// leading to the current head.
+var ErrNotAncestor = errors.New("advertisement is not an ancestor of the head")
+
+type (
+	RollbackOption  func(*rollbackOptions)
+	rollbackOptions struct {
+		prune bool
+	}
+)
+
+// WithRollbackPrune deletes the advertisements that are no longer part of the
+// chain after the rollback, along with their labels, publish times and the
+// diffs they published. Their entries are kept, since they may be shared with
+// advertisements that remain on the chain, but are no longer tracked for
+// their catalog unless published by one of those.
+func WithRollbackPrune(v bool) RollbackOption {
+	return func(o *rollbackOptions) {
+		o.prune = v
+	}
+}
+
+// RollbackHead resets the head to the given advertisement, discarding every
+// advertisement published after it. This is intended to recover from an
+// erroneous publish before indexers ingest it; indexers that have already
+// synced past the target will not roll back with it. The rollback fails with
+// ErrNotAncestor if the target is not on the chain leading to the head.
+func (h *Herald) RollbackHead(ctx context.Context, target cid.Cid, o ...RollbackOption) error {
+	var opts rollbackOptions
+	for _, apply := range o {
+		apply(&opts)
+	}
+	p := h.publisher.dsPublisher
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.readOnly {
+		return ErrReadOnly
+	}
+
+	head, err := p.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	var discarded []prunableAd
+	found := false
+	if err := p.walkChain(ctx, head, func(c cid.Cid, ad *schema.Advertisement) (bool, error) {
+		if c.Equals(target) {
+			found = true
+			return false, nil
+		}
+		pad := prunableAd{cid: c, id: ad.ContextID, isRm: ad.IsRm}
+		if hasEntries(ad.Entries) {
+			pad.entries = ad.Entries.(cidlink.Link).Cid
+		}
+		discarded = append(discarded, pad)
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNotAncestor, target)
+	}
+	if len(discarded) == 0 {
+		return nil
+	}
+	// The records are truncated first, so that a target without a record
+	// fails the rollback rather than leaving records of discarded
+	// advertisements.
+	if p.recorder != nil {
+		if err := p.recorder.truncate(ctx, target); err != nil {
+			h.logger.Errorw("failed to remove publish records discarded by rollback", "err", err)
+			return err
+		}
+	}
+	if err := p.setHead(ctx, target); err != nil {
+		return err
+	}
+	h.logger.Warnw("Rolled back head", "from", head, "to", target, "discarded", len(discarded))
+	if opts.prune {
+		return p.pruneDiscarded(ctx, target, discarded)
+	}
+	return nil
+}
+
+// pruneDiscarded deletes the given advertisements discarded by rolling back to
+// target, along with the keys stored for them.
+func (l *dsPublisher) pruneDiscarded(ctx context.Context, target cid.Cid, discarded []prunableAd) error {
+	// The entries published for a catalog are tracked until no advertisement
+	// left on the chain publishes them.
+	indexed := make(map[datastore.Key]struct{})
+	for _, ad := range discarded {
+		if !ad.isRm && ad.entries.Defined() {
+			indexed[entriesIndexKey(ad.id, ad.entries)] = struct{}{}
+		}
+	}
+	if len(indexed) != 0 {
+		if err := l.walkChain(ctx, target, func(_ cid.Cid, ad *schema.Advertisement) (bool, error) {
+			if !ad.IsRm && hasEntries(ad.Entries) {
+				delete(indexed, entriesIndexKey(ad.ContextID, ad.Entries.(cidlink.Link).Cid))
+			}
+			return len(indexed) != 0, nil
+		}); err != nil {
+			return err
+		}
+	}
+	for _, ad := range discarded {
+		keys := []datastore.Key{dsKey(cidlink.Link{Cid: ad.cid}), labelsKey(ad.cid), publishedKey(ad.cid)}
+		if key := entriesIndexKey(ad.id, ad.entries); ad.entries.Defined() {
+			if _, ok := indexed[key]; ok {
+				keys = append(keys, key)
+				delete(indexed, key)
+			}
+		}
+		// The multihashes tracked for a catalog published by diff are stale
+		// once its latest diff is discarded, and are tracked anew by the
+		// next.
+		switch value, err := l.h.ds.Get(ctx, diffAdKey(ad.id)); {
+		case errors.Is(err, datastore.ErrNotFound):
+		case err != nil:
+			return err
+		case bytes.Equal(value, ad.cid.Bytes()):
+			keys = append(keys, diffAdKey(ad.id))
+		}
+		for _, key := range keys {
+			if err := l.h.ds.Delete(ctx, key); err != nil {
+				l.h.logger.Errorw("failed to prune advertisement discarded by rollback", "ad", ad.cid, "key", key, "err", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
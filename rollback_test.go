@@ -0,0 +1,51 @@
+package herald_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/herald"
+	"github.com/ipni/herald/heraldtest"
+)
+
+func TestRollbackPruneDeletesAdvertisementKeys(t *testing.T) {
+	ctx := context.Background()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	h, err := herald.New(heraldtest.Options(
+		herald.WithDatastore(ds),
+		herald.WithChainPruning(0, time.Hour),
+		herald.WithRetractedEntriesRetention(time.Hour),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	target, err := h.Publish(ctx, heraldtest.Catalog("a", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.PublishDiff(ctx, heraldtest.Catalog("b", 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.RollbackHead(ctx, target, herald.WithRollbackPrune(true)); err != nil {
+		t.Fatal(err)
+	}
+	for prefix, want := range map[string]int{"/published": 1, "/entries": 1, "/diff-ads": 0} {
+		results, err := ds.Query(ctx, query.Query{Prefix: prefix, KeysOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := results.Rest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != want {
+			t.Fatalf("expected %d keys under %s once b is rolled back, got %d", want, prefix, len(keys))
+		}
+	}
+}
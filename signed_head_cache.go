@@ -0,0 +1,50 @@
+package herald
+
+import (
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+type (
+	// signedHeadCache holds the latest head signed and encoded for each topic,
+	// such that polling the head does not sign it on every request. Entries
+	// are replaced once the head changes, whether by publishing or otherwise,
+	// e.g. by a rollback.
+	signedHeadCache struct {
+		mu      sync.Mutex
+		entries map[string]signedHeadEntry
+	}
+	signedHeadEntry struct {
+		head    cid.Cid
+		encoded []byte
+	}
+)
+
+// get returns the given head signed with the given identity for the given
+// topic and encoded, and whether it was cached. The returned bytes must not be
+// modified.
+func (c *signedHeadCache) get(h cid.Cid, topic string, identity crypto.PrivKey) ([]byte, bool, error) {
+	// Heads are signed with the lock held, such that requests polling a new
+	// head concurrently sign it once.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[topic]; ok && e.head.Equals(h) {
+		return e.encoded, true, nil
+	}
+	signedHead, err := head.NewSignedHead(h, topic, identity)
+	if err != nil {
+		return nil, false, err
+	}
+	encoded, err := signedHead.Encode()
+	if err != nil {
+		return nil, false, err
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]signedHeadEntry)
+	}
+	c.entries[topic] = signedHeadEntry{head: h, encoded: encoded}
+	return encoded, false, nil
+}
@@ -0,0 +1,169 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// ValidateAdvertisement checks that the advertisement with the given CID, and
+// its entries, would pass the validation rules applied by indexers on
+// ingestion. The returned error wraps ErrInvalidAdvertisement if a rule is
+// violated.
+func (h *Herald) ValidateAdvertisement(ctx context.Context, c cid.Cid) error {
+	p := h.publisher.dsPublisher
+	ad, err := p.loadAdvertisement(ctx, c)
+	if err != nil {
+		return fmt.Errorf("%w: %s: failed to load: %v", ErrInvalidAdvertisement, c, err)
+	}
+	if err := p.validateAdvertisement(ctx, ad, false); err != nil {
+		return fmt.Errorf("%s: %w", c, err)
+	}
+	return nil
+}
+
+// ValidateChain checks every advertisement in the chain, from the head
+// backwards, as ValidateAdvertisement does, and returns the error of the
+// first advertisement that violates a rule.
+func (h *Herald) ValidateChain(ctx context.Context) error {
+	p := h.publisher.dsPublisher
+	head, err := p.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	// Like indexers, skip the entries of advertisements whose catalog is
+	// retracted later in the chain, since they may have been deleted.
+	retracted := make(map[string]struct{})
+	var count int
+	if err := p.walkChain(ctx, head, func(c cid.Cid, ad *schema.Advertisement) (bool, error) {
+		_, skipEntries := retracted[string(ad.ContextID)]
+		if isRetraction(ad) {
+			retracted[string(ad.ContextID)] = struct{}{}
+		}
+		if err := p.validateAdvertisement(ctx, ad, skipEntries); err != nil {
+			return false, fmt.Errorf("%s: %w", c, err)
+		}
+		count++
+		return true, nil
+	}); err != nil {
+		h.logger.Errorw("advertisement chain is invalid", "head", head, "validated", count, "err", err)
+		return err
+	}
+	h.logger.Infow("Validated advertisement chain", "head", head, "count", count)
+	return nil
+}
+
+// validateAdvertisement checks the given advertisement against the rules
+// applied by indexers on ingestion, including its entries unless skipEntries.
+func (l *dsPublisher) validateAdvertisement(ctx context.Context, ad *schema.Advertisement, skipEntries bool) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidAdvertisement, fmt.Sprintf(format, args...))
+	}
+	if err := ad.Validate(); err != nil {
+		return invalid("%v", err)
+	}
+	signer, err := ad.VerifySignature()
+	if err != nil {
+		return invalid("signature verification failed: %v", err)
+	}
+	if signer != l.h.id {
+		return invalid("signed by %s instead of publisher %s", signer, l.h.id)
+	}
+	if _, err := peer.Decode(ad.Provider); err != nil {
+		return invalid("invalid provider ID %q: %v", ad.Provider, err)
+	}
+	for _, addr := range ad.Addresses {
+		if _, err := multiaddr.NewMultiaddr(addr); err != nil {
+			return invalid("invalid provider address %q: %v", addr, err)
+		}
+	}
+	if isRetraction(ad) {
+		return nil
+	}
+	if len(ad.ContextID) == 0 {
+		return invalid("context ID is empty")
+	}
+	// The metadata of removals is ignored by indexers.
+	if !ad.IsRm {
+		if len(ad.Metadata) == 0 {
+			return invalid("metadata is empty")
+		}
+		if _, _, err := varint.FromUvarint(ad.Metadata); err != nil {
+			return invalid("metadata does not start with a protocol ID: %v", err)
+		}
+	}
+	if ad.Entries == nil {
+		return invalid("entries link is missing")
+	}
+	if !hasEntries(ad.Entries) || skipEntries {
+		return nil
+	}
+	return l.validateEntries(ctx, ad.Entries.(cidlink.Link).Cid)
+}
+
+func (l *dsPublisher) validateEntries(ctx context.Context, root cid.Cid) error {
+	if n, err := l.entriesLs.Load(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: root}, hamt.HashMapRootPrototype.Representation()); err == nil {
+		return l.validateHamtEntries(root, n)
+	}
+	for next := root; !cid.Undef.Equals(next); {
+		chunk, err := l.loadEntryChunk(ctx, next)
+		if err != nil {
+			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return err
+			}
+			return fmt.Errorf("%w: entry chunk %s: %v", ErrInvalidAdvertisement, next, err)
+		}
+		if len(chunk.Entries) == 0 {
+			return fmt.Errorf("%w: entry chunk %s is empty", ErrInvalidAdvertisement, next)
+		}
+		for _, mh := range chunk.Entries {
+			if _, err := multihash.Decode(mh); err != nil {
+				return fmt.Errorf("%w: entry chunk %s has invalid multihash: %v", ErrInvalidAdvertisement, next, err)
+			}
+		}
+		if chunk.Next == nil {
+			break
+		}
+		next = chunk.Next.(cidlink.Link).Cid
+	}
+	return nil
+}
+
+func (l *dsPublisher) validateHamtEntries(root cid.Cid, n ipld.Node) error {
+	hamtRoot, ok := bindnode.Unwrap(n).(*hamt.HashMapRoot)
+	if !ok {
+		return fmt.Errorf("%w: entries HAMT %s has unexpected root", ErrInvalidAdvertisement, root)
+	}
+	var count int
+	node := hamt.Node{HashMapRoot: *hamtRoot}
+	for it := node.WithLinking(l.entriesLs, l.h.linkPrototype).MapIterator(); !it.Done(); {
+		k, _, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("%w: entries HAMT %s: %v", ErrInvalidAdvertisement, root, err)
+		}
+		// Keys are the raw bytes of multihashes, exposed as strings.
+		key, err := k.AsString()
+		if err != nil {
+			return fmt.Errorf("%w: entries HAMT %s has invalid key: %v", ErrInvalidAdvertisement, root, err)
+		}
+		if _, err := multihash.Decode([]byte(key)); err != nil {
+			return fmt.Errorf("%w: entries HAMT %s has invalid multihash: %v", ErrInvalidAdvertisement, root, err)
+		}
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: entries HAMT %s is empty", ErrInvalidAdvertisement, root)
+	}
+	return nil
+}
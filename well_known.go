@@ -0,0 +1,114 @@
+package herald
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// wellKnownPath is the path at which the discovery document is served.
+	wellKnownPath = "/.well-known/ipni"
+	// libp2pWellKnownPath is the path at which the protocols served over HTTP
+	// are listed, per the libp2p HTTP specification.
+	libp2pWellKnownPath = "/.well-known/libp2p"
+)
+
+// syncProtocols lists the HTTP sync protocols served by the HTTP publisher.
+var syncProtocols = []SyncProtocol{
+	{Name: "ipnisync/v1", Head: ipnisync.IpniPath + "/head", Content: ipnisync.IpniPath + "/{cid}"},
+	{Name: "dagsync-http/v0", Head: "/head", Content: "/{cid}"},
+}
+
+type (
+	// DiscoveryDocument describes how to sync advertisements from Herald,
+	// and is served at /.well-known/ipni.
+	DiscoveryDocument struct {
+		ProviderID string `json:"providerID"`
+		// PublisherID is the peer ID of the publisher, when it differs from
+		// the provider. See WithProviderID.
+		PublisherID string `json:"publisherID,omitempty"`
+		// Addrs are the multiaddrs of the publisher, including its peer ID,
+		// such that clients can verify the identity of the server against
+		// the signature of the head.
+		Addrs     []string       `json:"addrs,omitempty"`
+		Topic     string         `json:"topic"`
+		Protocols []SyncProtocol `json:"protocols"`
+		// ProviderInfo is the path at which ProviderInfo is served.
+		ProviderInfo string `json:"providerInfo"`
+	}
+	// SyncProtocol describes the paths at which a sync protocol is served,
+	// relative to the base URL of the HTTP publisher.
+	SyncProtocol struct {
+		Name string `json:"name"`
+		Head string `json:"head"`
+		// Content is the path template at which blocks are served, where
+		// {cid} stands for the block CID.
+		Content string `json:"content,omitempty"`
+	}
+	// libp2pProtocolMeta describes where a protocol is served in the libp2p
+	// well-known resource.
+	libp2pProtocolMeta struct {
+		Path string `json:"path"`
+	}
+)
+
+// DiscoveryDocument returns the document served at /.well-known/ipni, with
+// paths including the prefix set by WithHttpPublisherPathPrefix.
+func (h *Herald) DiscoveryDocument() *DiscoveryDocument {
+	prefix := h.httpPublisherPathPrefix
+	doc := &DiscoveryDocument{
+		ProviderID:   h.providerID.String(),
+		Topic:        h.topic,
+		Protocols:    make([]SyncProtocol, 0, len(syncProtocols)),
+		ProviderInfo: prefix + "/provider",
+	}
+	if h.providerID != h.id {
+		doc.PublisherID = h.id.String()
+	}
+	for _, addr := range h.AddrInfo().Addrs {
+		doc.Addrs = append(doc.Addrs, addr.Encapsulate(multiaddr.StringCast("/p2p/"+h.id.String())).String())
+	}
+	for _, p := range syncProtocols {
+		p.Head = prefix + p.Head
+		if p.Content != "" {
+			p.Content = prefix + p.Content
+		}
+		doc.Protocols = append(doc.Protocols, p)
+	}
+	return doc
+}
+
+func (p *httpPublisher) handleGetWellKnown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.h.DiscoveryDocument()); err != nil {
+		p.h.httpLogger.Errorw("failed to write discovery document response", "client", clientAddr(r), "err", err)
+	}
+}
+
+// handleGetLibp2pWellKnown lists the ipnisync protocol, under the ID it is
+// served with over libp2p streams, along with the path at which it is served
+// over HTTP, so that libp2p HTTP clients can discover it.
+func (p *httpPublisher) handleGetLibp2pWellKnown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	protocols := map[string]libp2pProtocolMeta{
+		string(Libp2pProtocolID): {Path: p.h.httpPublisherPathPrefix + ipnisync.IpniPath + "/"},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(protocols); err != nil {
+		p.h.httpLogger.Errorw("failed to write libp2p well-known response", "client", clientAddr(r), "err", err)
+	}
+}